@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/starlingbank/vaultsmith"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/mask"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+// Environment variable to read the backup passphrase from, so it is not visible in the process list
+const backupPassphraseEnv = "VAULTSMITH_BACKUP_PASSPHRASE"
+
+var flags = flag.NewFlagSet("Vaultsmith", flag.ExitOnError)
+var documentPaths []string
+var overlays []string
+var dry bool
+var templateFile string
+var vaultRole string
+var logLevel string
+var templateParams []string
+var httpAuthToken string
+var httpAuthScheme string
+var httpHeaders []string
+var httpCACert string
+var httpClientCert string
+var httpClientKey string
+var httpProxy string
+var httpTimeout time.Duration
+var httpRetries int
+var s3Endpoint string
+var cacheDir string
+var offlineOk bool
+var tarDir string
+var noCleanUp bool
+var forceWriteOnly bool
+var timeout time.Duration
+var parallelism int
+var rollback bool
+var rollbackFile string
+var backupDir string
+var backupKeyFile string
+var autoApprove bool
+var archiveMaxSize int64
+var archiveMaxFiles int
+var documentSha256 string
+var documentSignatureKey string
+var documentSignature string
+var renderOut string
+var renderPath string
+var showSecrets bool
+
+func init() {
+	flags.StringArrayVar(
+		// TODO: remove default value of "./example", could do bad things in production
+		&documentPaths, "document-path", []string{},
+		"The root directory of the configuration. Can be a local directory, local archive "+
+			"(tar, tar.gz, tar.bz2, tar.xz or zip), http or s3 url to an archive, or git repository url, e.g. "+
+			"git+https://host/repo.git//subdir?ref=v1.0.0. If repeated, later paths are overlays.",
+	)
+	flags.StringArrayVar(
+		&overlays, "overlay", []string{}, "Document path to merge over document-path, "+
+			"replacing files at the same relative path. May be repeated; later overlays take "+
+			"precedence. A _delete file in an overlay lists paths to remove.",
+	)
+	flags.StringVar(
+		&vaultRole, "role", "root", "The Vault role to authenticate as",
+	)
+	flags.StringVar(
+		&templateFile, "template-file", "", "JSON or YAML file containing template "+
+			"mappings. If not specified, vaultsmith will look for \"_vaultsmith.json\" (or "+
+			"\"_vaultsmith.yaml\") in the base of the document path.",
+	)
+	flags.BoolVar(
+		&dry, "dry", false, "Dry run; will read from but not write to vault",
+	)
+	flags.StringVar(
+		&logLevel, "log-level", "info", fmt.Sprintf("Log level, valid "+
+			"values are %+v", log.AllLevels),
+	)
+	flags.StringSliceVar(
+		&templateParams, "template-params", []string{}, "Template parameters. "+
+			"Applies globally, but values in template-file take precedence. E.G.: service=foo,account=bar",
+	)
+	flags.StringVar(
+		&httpAuthToken, "http-auth-token", "", "Auth token to pass as "+
+			"'Authorization' header. Useful for passing user tokens to private github repos.",
+	)
+	flags.StringVar(
+		&httpAuthScheme, "http-auth-scheme", document.AuthSchemeToken, "How to pass "+
+			"http-auth-token: token (as Github expects), bearer, or basic (token is user:password)",
+	)
+	flags.StringArrayVar(
+		&httpHeaders, "http-header", []string{}, "Extra header for http requests, as "+
+			"\"Name: value\". May be repeated.",
+	)
+	flags.StringVar(
+		&httpCACert, "http-ca-cert", "", "PEM file of CA certificates to trust for https, in "+
+			"addition to the system pool",
+	)
+	flags.StringVar(
+		&httpClientCert, "http-client-cert", "", "PEM file of a client certificate for https",
+	)
+	flags.StringVar(
+		&httpClientKey, "http-client-key", "", "PEM file of the key for http-client-cert",
+	)
+	flags.StringVar(
+		&httpProxy, "http-proxy", "", "Proxy url for http requests. If not specified, "+
+			"HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used.",
+	)
+	flags.DurationVar(
+		&httpTimeout, "http-timeout", 5*time.Minute, "Timeout of each http request, "+
+			"including the download. Zero means no timeout.",
+	)
+	flags.IntVar(
+		&httpRetries, "http-retries", 3, "Number of times to retry an http request on a 5xx "+
+			"response or connection error",
+	)
+	flags.StringVar(
+		&tarDir, "tar-dir", "", "Directory within the tarball to use as the "+
+			"document-path. If not specified, and there is only one directory within the archive, "+
+			"that one will be used. If there is more than one diretory, the root directory of the "+
+			"archive will be used.",
+	)
+	flags.BoolVar(
+		&noCleanUp, "no-cleanup", false, "Don't clean up temp directory on exit",
+	)
+	flags.BoolVar(
+		&forceWriteOnly, "force-write-only", false, "Write documents containing write-only "+
+			"fields (e.g. secret_key), even if they otherwise appear to be applied. Useful after "+
+			"rotating credentials.",
+	)
+	flags.Int64Var(
+		&archiveMaxSize, "archive-max-size", document.DefaultArchiveLimits.MaxBytes, "Maximum "+
+			"size in bytes of a downloaded archive, and of the files extracted from an archive document-path",
+	)
+	flags.IntVar(
+		&archiveMaxFiles, "archive-max-files", document.DefaultArchiveLimits.MaxFiles, "Maximum "+
+			"number of entries in an archive document-path",
+	)
+	flags.StringVar(
+		&documentSha256, "document-sha256", "", "Expected SHA-256 digest (hex) of an archive "+
+			"document-path. The run is aborted before extraction if it does not match.",
+	)
+	flags.StringVar(
+		&documentSignatureKey, "document-signature-key", "", "PEM file containing the ed25519 "+
+			"public key an archive document-path must be signed with",
+	)
+	flags.StringVar(
+		&documentSignature, "document-signature", "", "Local path or url of the detached "+
+			"signature of an archive document-path. Defaults to the document-path with \".sig\" "+
+			"appended.",
+	)
+	flags.StringVar(
+		&s3Endpoint, "s3-endpoint", "", "Endpoint of S3 compatible storage for an s3:// "+
+			"document-path, e.g. http://localhost:9000. Defaults to AWS.",
+	)
+	flags.StringVar(
+		&cacheDir, "cache-dir", "", "Directory to cache archives downloaded over http between "+
+			"runs. Cached archives are revalidated with If-None-Match and If-Modified-Since.",
+	)
+	flags.BoolVar(
+		&offlineOk, "offline-ok", false, "If the server is unreachable, use the last archive in "+
+			"cache-dir rather than failing",
+	)
+	flags.StringVar(
+		&renderOut, "out", "", "render: directory to write every rendered document to, at its "+
+			"API path with .json appended",
+	)
+	flags.StringVar(
+		&renderPath, "path", "", "render: API path of a single document to print to stdout, e.g. "+
+			"auth/approle/role/foo",
+	)
+	flags.BoolVar(
+		&showSecrets, "show-secrets", false, "render: include the values of secrets resolved "+
+			"by template functions, rather than masking them",
+	)
+	flags.IntVar(
+		&parallelism, "parallelism", 4, "Maximum number of directories of the document tree to "+
+			"apply at once. Directories are only applied in parallel if they do not depend on "+
+			"each other.",
+	)
+	flags.BoolVar(
+		&rollback, "rollback", false, "If the run fails, undo the changes it made, restoring "+
+			"the state of each path from before it was first written",
+	)
+	flags.StringVar(
+		&rollbackFile, "rollback-file", "", "File to write the operations which undo the run "+
+			"to. vaultsmith rollback reads the operations to apply from this file. It contains "+
+			"the prior values of documents, so is created readable only by the owner.",
+	)
+	flags.StringVar(
+		&backupDir, "backup-dir", "", "Directory to write a backup of every path the documents "+
+			"manage to before changing anything. The backup is an archive of documents, so can "+
+			"be restored with --document-path.",
+	)
+	flags.StringVar(
+		&backupKeyFile, "backup-key-file", "", "File containing the key to encrypt backups "+
+			"with, and decrypt them when given as --document-path. A passphrase can instead be "+
+			"given with the "+backupPassphraseEnv+" environment variable.",
+	)
+	flags.BoolVar(
+		&autoApprove, "auto-approve", false, "Apply the changes without showing them and asking "+
+			"for confirmation first. Deletions and auth method disables are refused without it "+
+			"if stdin is not a terminal.",
+	)
+	flags.DurationVar(
+		&timeout, "timeout", 0, "Stop the run after this duration, e.g. 10m. Zero means no "+
+			"timeout.",
+	)
+
+	flags.Usage = func() {
+		fmt.Printf("Usage of vaultsmith:\n" +
+			"  vaultsmith [apply] [flags]    Apply the documents to Vault\n" +
+			"  vaultsmith validate [flags]   Check the documents without connecting to Vault\n" +
+			"  vaultsmith render [flags]     Write the documents as they would be sent to Vault\n" +
+			"  vaultsmith rollback [flags]   Undo a run, using the --rollback-file it wrote\n\n" +
+			"Flags:\n")
+		flags.PrintDefaults()
+		fmt.Print("\nNotes:\n" +
+			"• BE CAREFUL with this tool, it will faithfully apply whatever config you give it! " +
+			"Unless --auto-approve is given, it shows the changes it will make and asks for " +
+			"confirmation first. Use --dry until you are confident.\n" +
+			"• Vault authentication is handled by environment variables (the same " +
+			"ones as the Vault client, as vaultsmith uses the same code). So ensure VAULT_ADDR " +
+			"and VAULT_TOKEN are set.\n" +
+			"• Files that start with an underscore (e.g. _vaultsmith.json) are not published to " +
+			"vault.\n" +
+			"• If template-file is not specified, it is not mandatory for _vaultsmith.json to be " +
+			"present.\n" +
+			"• Specifying a parameter with --template-params allows only a single value. If you " +
+			"need multiple values, please use a template-file.\n" +
+			"• vaultsmith rollback enables an auth method the run disabled again, but can not " +
+			"restore the roles and configuration within it. Use --backup-dir to keep a copy." +
+			"\n\n")
+	}
+
+	// Avoid parsing flags passed on running `go test`
+	var args []string
+
+	for _, s := range os.Args[1:] {
+		if !strings.HasPrefix(s, "-test.") {
+			args = append(args, s)
+		}
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	ll, err := log.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.SetLevel(ll)
+
+	command := flags.Arg(0)
+	var documentPath string
+	var layers []string
+	if len(documentPaths) > 0 {
+		documentPath, layers = documentPaths[0], documentPaths[1:]
+	}
+	if documentPath == "" && command != "rollback" {
+		log.Fatalln("Please specify --document-path")
+	}
+	// Only check if specified, otherwise no template file is OK
+	if templateFile != "" {
+		if _, err := os.Stat(templateFile); os.IsNotExist(err) {
+			log.Fatalf("Specified template-file does not exist: %s", err)
+		}
+	}
+
+	conf := config.VaultsmithConfig{
+		DocumentPath:         documentPath,
+		Overlays:             append(layers, overlays...),
+		VaultRole:            vaultRole,
+		TemplateFile:         templateFile,
+		Dry:                  dry,
+		TemplateParams:       templateParams,
+		HttpAuthToken:        httpAuthToken,
+		HttpAuthScheme:       httpAuthScheme,
+		HttpHeaders:          httpHeaders,
+		HttpCACert:           httpCACert,
+		HttpClientCert:       httpClientCert,
+		HttpClientKey:        httpClientKey,
+		HttpProxy:            httpProxy,
+		HttpTimeout:          httpTimeout,
+		HttpRetries:          httpRetries,
+		S3Endpoint:           s3Endpoint,
+		CacheDir:             cacheDir,
+		OfflineOk:            offlineOk,
+		TarDir:               tarDir,
+		ForceWriteOnly:       forceWriteOnly,
+		NoCleanUp:            noCleanUp,
+		ArchiveMaxSize:       archiveMaxSize,
+		ArchiveMaxFiles:      archiveMaxFiles,
+		DocumentSha256:       documentSha256,
+		DocumentSignatureKey: documentSignatureKey,
+		DocumentSignature:    documentSignature,
+		Parallelism:          parallelism,
+		Rollback:             rollback,
+		RollbackFile:         rollbackFile,
+		BackupDir:            backupDir,
+		BackupKeyFile:        backupKeyFile,
+		BackupPassphrase:     os.Getenv(backupPassphraseEnv),
+	}
+
+	ctx, cancel := runContext()
+	defer cancel()
+
+	switch command {
+	case "", "apply":
+		apply(ctx, conf)
+	case "validate":
+		validate(ctx, conf)
+	case "render":
+		render(ctx, conf)
+	case "rollback":
+		undo(ctx, conf)
+	default:
+		log.Fatalf("Unknown command %q, expected apply, validate, render or rollback", command)
+	}
+}
+
+// Apply the documents to Vault
+func apply(ctx context.Context, conf config.VaultsmithConfig) {
+	if conf.Dry {
+		log.Info("Dry mode enabled, no changes will be made")
+	}
+	client, err := vault.NewVaultClient(conf.Dry)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := vaultsmith.Options{
+		Config: conf,
+		Client: client,
+	}
+	if !autoApprove {
+		opts.Confirm = confirmChanges
+	}
+	result, err := vaultsmith.Run(ctx, opts)
+	if result.DocumentVersion != "" {
+		log.Infof("Documents at version %s", result.DocumentVersion)
+	}
+	if result.DocumentCacheStatus != "" {
+		log.Infof("Documents %s", result.DocumentCacheStatus)
+	}
+	if result.DocumentBytes > 0 {
+		log.Infof("%d bytes downloaded", result.DocumentBytes)
+	}
+	if result.BackupFile != "" {
+		log.Infof("Backed up to %s", result.BackupFile)
+	}
+	if result.Dry {
+		log.Infof("%d changes would have been made", len(result.Changes))
+	} else {
+		log.Infof("%d changes made", len(result.Changes))
+	}
+	if len(result.RolledBack) > 0 {
+		log.Warnf("%d changes made to undo the run", len(result.RolledBack))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// Report what was applied, as the declared state was only partially reached
+			for _, c := range result.Changes {
+				log.WithFields(log.Fields{"action": c.Action, "path": c.Path}).Warn("Applied before stopping")
+			}
+		}
+		log.Fatalf("Error: %s", err)
+	}
+	log.Debugf("Success")
+}
+
+// Print the planned changes and ask for confirmation of them, then separately of any deletions and
+// auth method disables. If stdin is not a terminal, the changes are made without asking, unless any
+// of them are destructive, in which case they are refused.
+func confirmChanges(plan []vault.PlannedChange) error {
+	if len(plan) == 0 {
+		fmt.Println("No changes to make")
+		return nil
+	}
+	var deletions, disables int
+	for _, c := range plan {
+		fmt.Print(c.Diff())
+		if c.Action == "DisableAuth" {
+			disables++
+		} else if c.Destructive() {
+			deletions++
+		}
+	}
+	fmt.Println()
+
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		if deletions+disables > 0 {
+			return fmt.Errorf("refusing to make %d deletions and %d auth method disables without "+
+				"--auto-approve, as stdin is not a terminal", deletions, disables)
+		}
+		return nil
+	}
+	questions := []string{fmt.Sprintf("Make these %d changes?", len(plan))}
+	if deletions > 0 {
+		questions = append(questions, fmt.Sprintf("Delete %d documents and policies?", deletions))
+	}
+	if disables > 0 {
+		questions = append(questions, fmt.Sprintf(
+			"Disable %d auth methods, destroying every role within them?", disables))
+	}
+	in := bufio.NewReader(os.Stdin)
+	for _, q := range questions {
+		fmt.Printf("%s [y/N] ", q)
+		answer, _ := in.ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("changes not confirmed, nothing was changed")
+		}
+	}
+	return nil
+}
+
+// Undo a run with the operations in the rollback file it wrote
+func undo(ctx context.Context, conf config.VaultsmithConfig) {
+	if conf.RollbackFile == "" {
+		log.Fatalln("Please specify --rollback-file")
+	}
+	if conf.Dry {
+		log.Info("Dry mode enabled, no changes will be made")
+	}
+	client, err := vault.NewVaultClient(conf.Dry)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	result, err := vaultsmith.Rollback(ctx, vaultsmith.Options{Config: conf, Client: client}, conf.RollbackFile)
+	if result.DocumentVersion != "" {
+		log.Infof("Undoing the run of documents at version %s", result.DocumentVersion)
+	}
+	if result.Dry {
+		log.Infof("%d changes would have been made", len(result.Changes))
+	} else {
+		log.Infof("%d changes made", len(result.Changes))
+	}
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+}
+
+// Check the documents without Vault, printing every problem found
+func validate(ctx context.Context, conf config.VaultsmithConfig) {
+	problems, err := vaultsmith.Validate(ctx, vaultsmith.Options{Config: conf})
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("%d problems found", len(problems))
+	}
+	log.Info("Documents are valid")
+}
+
+// Write the documents as they would be sent to Vault, to renderOut or stdout
+func render(ctx context.Context, conf config.VaultsmithConfig) {
+	if renderOut == "" && renderPath == "" {
+		log.Fatalln("Please specify --out or --path")
+	}
+	docs, problems, err := vaultsmith.Render(ctx, vaultsmith.Options{Config: conf})
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	for _, p := range problems {
+		log.Error(p)
+	}
+
+	if renderPath != "" {
+		found := false
+		for _, doc := range docs {
+			if doc.Path == strings.Trim(renderPath, "/") {
+				found = true
+				os.Stdout.Write(renderedJSON(doc))
+			}
+		}
+		if !found {
+			log.Fatalf("No document rendered at %s", renderPath)
+		}
+	}
+	if renderOut != "" {
+		for _, doc := range docs {
+			p := filepath.Join(renderOut, filepath.FromSlash(doc.Path)+".json")
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				log.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, renderedJSON(doc), 0600); err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Infof("%d documents written to %s", len(docs), renderOut)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("%d problems found", len(problems))
+	}
+}
+
+// Return a rendered document as indented json, with secrets masked unless showSecrets is set
+func renderedJSON(doc path_handlers.RenderedDocument) []byte {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc.Data); err != nil {
+		log.Fatalf("Could not encode %s: %s", doc.Path, err)
+	}
+	if showSecrets {
+		return b.Bytes()
+	}
+	return []byte(mask.String(b.String()))
+}
+
+// Return a context which is cancelled on SIGINT or SIGTERM, or when the timeout expires. The run
+// stops cleanly between operations; a second signal kills the process immediately.
+func runContext() (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Warnf("Received %s, stopping after the current operation", sig)
+			// restore default behaviour, so a second signal is not ignored
+			signal.Stop(sigs)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
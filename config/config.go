@@ -1,11 +1,48 @@
 package config
 
+import "time"
+
 type VaultsmithConfig struct {
-	DocumentPath   string
-	Dry            bool
-	VaultRole      string
-	TemplateFile   string
-	TemplateParams []string
-	HttpAuthToken  string
-	TarDir         string
+	DocumentPath    string
+	Overlays        []string // document paths merged over DocumentPath, in order
+	Dry             bool
+	VaultRole       string
+	TemplateFile    string
+	TemplateParams  []string
+	HttpAuthToken   string
+	HttpAuthScheme  string        // token, bearer or basic
+	HttpHeaders     []string      // extra headers, as "Name: value"
+	HttpCACert      string        // PEM file of CAs to trust for https
+	HttpClientCert  string        // PEM file of a client certificate for https
+	HttpClientKey   string        // PEM file of the key for HttpClientCert
+	HttpProxy       string        // proxy url, overriding the environment
+	HttpTimeout     time.Duration // timeout of each http request
+	HttpRetries     int           // number of retries on 5xx responses or connection errors
+	CacheDir        string        // directory to cache http downloads in, between runs
+	OfflineOk       bool          // use the cached download if the server is unreachable
+	TarDir          string
+	ForceWriteOnly  bool
+	NoCleanUp       bool  // don't remove temporary files, e.g. extracted archives
+	ArchiveMaxSize  int64 // maximum total size of files extracted from an archive. Zero for default
+	ArchiveMaxFiles int   // maximum number of entries in an archive. Zero for default
+	// expected SHA-256 digest of an archive DocumentPath, hex encoded
+	DocumentSha256 string
+	// PEM encoded ed25519 public key, which an archive DocumentPath must be signed with
+	DocumentSignatureKey string
+	// local path or url of the detached signature. Defaults to DocumentPath + ".sig"
+	DocumentSignature string
+	// endpoint of S3 compatible storage for s3:// document paths. Defaults to AWS
+	S3Endpoint string
+	// maximum number of directories of the document tree to apply at once. Zero is treated as one
+	Parallelism int
+	// on failure, undo the changes made by the run
+	Rollback bool
+	// file to write the operations which undo the run to, for vaultsmith rollback
+	RollbackFile string
+	// directory to write a backup of the managed paths to, before changing anything
+	BackupDir string
+	// file containing the key to encrypt backups with, and decrypt encrypted archives
+	BackupKeyFile string
+	// passphrase to encrypt backups with, and decrypt encrypted archives. Not with BackupKeyFile
+	BackupPassphrase string
 }
@@ -0,0 +1,392 @@
+package document
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
+)
+
+// Archive formats, as detected from the first bytes of the file
+const (
+	formatUnknown = "unknown"
+	formatTar     = "tar"
+	formatGzip    = "tar.gz"
+	formatBzip2   = "tar.bz2"
+	formatXz      = "tar.xz"
+	formatZip     = "zip"
+)
+
+// Length of header needed to detect any format; the tar magic is at offset 257
+const formatHeaderLen = 262
+
+// Determine the archive format from the header of the file
+func detectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return formatGzip
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return formatBzip2
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return formatXz
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return formatZip
+	case len(header) >= formatHeaderLen && bytes.Equal(header[257:262], []byte("ustar")):
+		return formatTar
+	default:
+		return formatUnknown
+	}
+}
+
+// An entry within an archive
+type archiveEntry struct {
+	Name     string      // path relative to the root of the archive, slash separated
+	Mode     os.FileMode // permission and type bits
+	Linkname string      // target of a symlink
+}
+
+// Reads the entries of an archive, regardless of its format
+type archiveReader interface {
+	// Advance to the next entry, returning io.EOF at the end of the archive
+	Next() (*archiveEntry, error)
+	// Read the contents of the current entry
+	io.Reader
+	io.Closer
+}
+
+// Open the archive at path, detecting its format
+func openArchive(path string) (archiveReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file %q: %s", path, err)
+	}
+	br := bufio.NewReaderSize(f, formatHeaderLen)
+	// an archive may be shorter than formatHeaderLen, so EOF is not an error
+	header, err := br.Peek(formatHeaderLen)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("could not read %q: %s", path, err)
+	}
+	err = nil
+
+	format := detectFormat(header)
+	log.Debugf("Detected %s archive format for %q", format, path)
+	var r io.Reader
+	switch format {
+	case formatTar:
+		r = br
+	case formatGzip:
+		r, err = gzip.NewReader(br)
+	case formatBzip2:
+		r = bzip2.NewReader(br)
+	case formatXz:
+		r, err = xz.NewReader(br)
+	case formatZip:
+		f.Close()
+		return newZipReader(path)
+	default:
+		f.Close()
+		return nil, fmt.Errorf("%q is not a recognised archive, expected tar, tar.gz, tar.bz2, tar.xz or zip", path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not create %s reader for %q: %s", format, path, err)
+	}
+	return &tarReader{Reader: tar.NewReader(r), file: f}, nil
+}
+
+// Reads a tar archive, from an optionally compressed stream
+type tarReader struct {
+	*tar.Reader
+	file *os.File
+}
+
+func (t *tarReader) Next() (*archiveEntry, error) {
+	for {
+		hdr, err := t.Reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		entry := &archiveEntry{Name: hdr.Name, Mode: os.FileMode(hdr.Mode).Perm(), Linkname: hdr.Linkname}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entry.Mode |= os.ModeDir
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeSymlink:
+			entry.Mode |= os.ModeSymlink
+		default:
+			log.Debugf("Unhandled tar type: %+v", hdr)
+			continue
+		}
+		return entry, nil
+	}
+}
+
+func (t *tarReader) Close() error {
+	return t.file.Close()
+}
+
+// Maximum length of a symlink target, as PATH_MAX on linux
+const maxLinkTarget = 4096
+
+// Reads a zip archive
+type zipReader struct {
+	zr      *zip.ReadCloser
+	next    int           // index of the next file
+	current io.ReadCloser // contents of the current file
+}
+
+func newZipReader(path string) (*zipReader, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create zip reader for %q: %s", path, err)
+	}
+	return &zipReader{zr: zr}, nil
+}
+
+func (z *zipReader) Next() (*archiveEntry, error) {
+	if z.current != nil {
+		z.current.Close()
+		z.current = nil
+	}
+	if z.next >= len(z.zr.File) {
+		return nil, io.EOF
+	}
+	f := z.zr.File[z.next]
+	z.next++
+
+	entry := &archiveEntry{Name: f.Name, Mode: f.Mode()}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("could not read %q: %s", f.Name, err)
+	}
+	if entry.Mode&os.ModeSymlink != 0 {
+		// the link target is stored as the file contents, which may claim to be any size
+		var target bytes.Buffer
+		_, err := io.Copy(&target, io.LimitReader(rc, maxLinkTarget+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read %q: %s", f.Name, err)
+		}
+		if target.Len() > maxLinkTarget {
+			return nil, fmt.Errorf("symlink %q has a target longer than %d bytes", f.Name, maxLinkTarget)
+		}
+		entry.Linkname = target.String()
+		return entry, nil
+	}
+	z.current = rc
+	return entry, nil
+}
+
+func (z *zipReader) Read(p []byte) (int, error) {
+	if z.current == nil {
+		return 0, io.EOF
+	}
+	return z.current.Read(p)
+}
+
+func (z *zipReader) Close() error {
+	if z.current != nil {
+		z.current.Close()
+	}
+	return z.zr.Close()
+}
+
+// Limits on the content of an archive, to protect against archive bombs
+type ArchiveLimits struct {
+	MaxBytes int64 // total uncompressed size of files. Zero means DefaultArchiveLimits.MaxBytes
+	MaxFiles int   // number of entries. Zero means DefaultArchiveLimits.MaxFiles
+}
+
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxBytes: 100 << 20,
+	MaxFiles: 10000,
+}
+
+// Return the limits, with defaults in place of unset values
+func (l ArchiveLimits) orDefault() ArchiveLimits {
+	if l.MaxBytes == 0 {
+		l.MaxBytes = DefaultArchiveLimits.MaxBytes
+	}
+	if l.MaxFiles == 0 {
+		l.MaxFiles = DefaultArchiveLimits.MaxFiles
+	}
+	return l
+}
+
+// Copy a download from src to dst, failing if it is larger than max bytes
+func copyLimited(dst io.Writer, src io.Reader, max int64) (int64, error) {
+	n, err := io.Copy(dst, io.LimitReader(src, max+1))
+	if err == nil && n > max {
+		return n, fmt.Errorf("download is larger than the limit of %d bytes", max)
+	}
+	return n, err
+}
+
+// Return the slash separated path of an entry, relative to the root of the archive. Absolute
+// paths, and paths which escape the root, are refused.
+func entryPath(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty entry name")
+	}
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("entry %q has an absolute path", name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("entry %q is outside the archive root", name)
+	}
+	return clean, nil
+}
+
+// Ensure no existing component of rel, beneath destDir, is a symlink. Writing through a symlink
+// could otherwise place files outside destDir.
+func checkNoSymlinks(destDir string, rel string) error {
+	p := destDir
+	for _, c := range strings.Split(rel, "/") {
+		p = filepath.Join(p, c)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%q would be written through symlink %q", rel, p)
+		}
+	}
+	return nil
+}
+
+// Ensure symlink targets resolve to a path within destDir
+func checkSymlinkTargets(destDir string, links []string) error {
+	root, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return fmt.Errorf("could not resolve symlink %q: %s", link, err)
+		}
+		if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+			return fmt.Errorf("symlink %q points outside the archive root, to %q", link, target)
+		}
+	}
+	return nil
+}
+
+// Extract the archive at archivePath into destDir. Entries must stay within destDir, and the
+// archive must be within limits. Symlinks are created only if they point within destDir.
+func extractArchive(ctx context.Context, archivePath string, destDir string, limits ArchiveLimits) error {
+	limits = limits.orDefault()
+	ar, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("error creating directory %q: %s", destDir, err)
+	}
+
+	var files int
+	var size int64
+	var links []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading archive %q: %s", archivePath, err)
+		}
+		if files++; files > limits.MaxFiles {
+			return fmt.Errorf("archive %q has more than %d entries", archivePath, limits.MaxFiles)
+		}
+		rel, err := entryPath(entry.Name)
+		if err != nil {
+			return fmt.Errorf("refusing to extract %q: %s", archivePath, err)
+		}
+		if rel == "." {
+			continue
+		}
+		if err := checkNoSymlinks(destDir, rel); err != nil {
+			return fmt.Errorf("refusing to extract %q: %s", archivePath, err)
+		}
+		dest := filepath.Join(destDir, filepath.FromSlash(rel))
+
+		switch {
+		case entry.Mode.IsDir():
+			log.Debugf("Creating %q", dest)
+			err := os.MkdirAll(dest, 0755)
+			if err != nil {
+				return fmt.Errorf("error creating directory %q: %s", dest, err)
+			}
+		case entry.Mode.IsRegular():
+			log.Infof("Extracting %q", dest)
+			// not all archives have entries for parent directories
+			err := os.MkdirAll(filepath.Dir(dest), 0755)
+			if err != nil {
+				return fmt.Errorf("error creating directory %q: %s", filepath.Dir(dest), err)
+			}
+			w, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return fmt.Errorf("error creating file %q: %s", dest, err)
+			}
+			// read one byte beyond the limit, to detect exceeding it
+			n, err := io.Copy(w, io.LimitReader(ar, limits.MaxBytes-size+1))
+			w.Close()
+			if err != nil {
+				return fmt.Errorf("error writing to file %q: %s", dest, err)
+			}
+			if size += n; size > limits.MaxBytes {
+				return fmt.Errorf("archive %q is larger than %d bytes", archivePath, limits.MaxBytes)
+			}
+		case entry.Mode&os.ModeSymlink != 0:
+			target := entry.Linkname
+			if size += int64(len(target)); size > limits.MaxBytes {
+				return fmt.Errorf("archive %q is larger than %d bytes", archivePath, limits.MaxBytes)
+			}
+			if path.IsAbs(target) || filepath.IsAbs(target) {
+				return fmt.Errorf("refusing to extract %q: symlink %q has absolute target %q", archivePath, rel, target)
+			}
+			if _, err := entryPath(path.Join(path.Dir(rel), target)); err != nil {
+				return fmt.Errorf("refusing to extract %q: symlink %q points outside the archive root", archivePath, rel)
+			}
+			log.Debugf("Creating symlink %q to %q", dest, target)
+			err := os.MkdirAll(filepath.Dir(dest), 0755)
+			if err != nil {
+				return fmt.Errorf("error creating directory %q: %s", filepath.Dir(dest), err)
+			}
+			if err := os.Symlink(filepath.FromSlash(target), dest); err != nil {
+				return fmt.Errorf("error creating symlink %q: %s", dest, err)
+			}
+			links = append(links, dest)
+		default:
+			log.Debugf("Skipping %q, unhandled mode %s", entry.Name, entry.Mode)
+		}
+	}
+
+	// a symlink may point through another symlink, so targets can only be checked once all exist
+	if err := checkSymlinkTargets(destDir, links); err != nil {
+		return fmt.Errorf("refusing to extract %q: %s", archivePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package document
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+var archiveFiles = map[string]string{
+	"docs/sys/policy/read.json":       `{"policy": "path \"secret/*\" { capabilities = [\"read\"] }"}`,
+	"docs/auth/approle/role/foo.json": `{"policies": "read"}`,
+}
+
+func writeTestTar(t *testing.T, w io.Writer) {
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range archiveFiles {
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Create an archive of archiveFiles in dir, in the given format
+func createTestArchive(t *testing.T, dir string, format string) string {
+	path := filepath.Join(dir, "docs."+format)
+	var buf bytes.Buffer
+	switch format {
+	case formatTar:
+		writeTestTar(t, &buf)
+	case formatGzip:
+		gw := gzip.NewWriter(&buf)
+		writeTestTar(t, gw)
+		gw.Close()
+	case formatXz:
+		xw, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestTar(t, xw)
+		xw.Close()
+	case formatBzip2:
+		// the standard library has no bzip2 writer
+		if _, err := exec.LookPath("bzip2"); err != nil {
+			t.Skip("bzip2 is not installed")
+		}
+		writeTestTar(t, &buf)
+		cmd := exec.Command("bzip2", "-c")
+		cmd.Stdin = bytes.NewReader(buf.Bytes())
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		buf.Write(out)
+	case formatZip:
+		zw := zip.NewWriter(&buf)
+		for name, content := range archiveFiles {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(content))
+		}
+		zw.Close()
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDetectFormat(t *testing.T) {
+	tarHeader := make([]byte, formatHeaderLen)
+	copy(tarHeader[257:], "ustar")
+	tests := []struct {
+		name   string
+		header []byte
+		exp    string
+	}{
+		{name: "tar", header: tarHeader, exp: formatTar},
+		{name: "gzip", header: []byte{0x1f, 0x8b, 0x08, 0x00}, exp: formatGzip},
+		{name: "bzip2", header: []byte("BZh91AY&SY"), exp: formatBzip2},
+		{name: "xz", header: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, exp: formatXz},
+		{name: "zip", header: []byte("PK\x03\x04\x14\x00"), exp: formatZip},
+		{name: "empty zip", header: []byte("PK\x05\x06\x00\x00"), exp: formatZip},
+		{name: "json", header: []byte(`{"policy": ""}`), exp: formatUnknown},
+		{name: "empty", header: []byte{}, exp: formatUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if r := detectFormat(test.header); r != test.exp {
+				t.Errorf("Expected %s, got %s", test.exp, r)
+			}
+		})
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, format := range []string{formatTar, formatGzip, formatBzip2, formatXz, formatZip} {
+		t.Run(format, func(t *testing.T) {
+			archive := createTestArchive(t, tmpDir, format)
+			dest := filepath.Join(tmpDir, format+"-extract")
+			if err := extractArchive(context.Background(), archive, dest, ArchiveLimits{}); err != nil {
+				t.Fatalf("Error extracting %s: %s", format, err)
+			}
+			for name, content := range archiveFiles {
+				b, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+				if err != nil {
+					t.Errorf("Could not read %s: %s", name, err)
+					continue
+				}
+				if string(b) != content {
+					t.Errorf("Expected %q in %s, got %q", content, name, b)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchive_unknownFormat(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "docs.tar.gz")
+	if err := ioutil.WriteFile(path, []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractArchive(context.Background(), path, filepath.Join(tmpDir, "extract"), ArchiveLimits{}); err == nil {
+		t.Error("Expected error extracting unknown format, got nil")
+	}
+}
+
+type testTarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func createTestTar(t *testing.T, dir string, entries []testTarEntry) string {
+	f, err := ioutil.TempFile(dir, "entries-*.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.content))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func file(name string, content string) testTarEntry {
+	return testTarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg}, content: content}
+}
+
+func symlink(name string, target string) testTarEntry {
+	return testTarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}}
+}
+
+func TestExtractArchive_hardening(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name    string
+		entries []testTarEntry
+		limits  ArchiveLimits
+		errors  bool
+		exist   []string // paths expected within the extracted dir
+	}{
+		{name: "no parent dir headers", entries: []testTarEntry{file("a/b/c.json", "{}")}, exist: []string{"a/b/c.json"}},
+		{name: "dot prefix", entries: []testTarEntry{file("./a.json", "{}")}, exist: []string{"a.json"}},
+		{name: "parent traversal", entries: []testTarEntry{file("../evil.json", "{}")}, errors: true},
+		{name: "nested traversal", entries: []testTarEntry{file("a/../../evil.json", "{}")}, errors: true},
+		{name: "absolute path", entries: []testTarEntry{file("/tmp/evil.json", "{}")}, errors: true},
+		{name: "symlink within root", entries: []testTarEntry{
+			file("a/real.json", "{}"), symlink("b/link.json", "../a/real.json"),
+		}, exist: []string{"b/link.json"}},
+		{name: "absolute symlink", entries: []testTarEntry{symlink("link", "/etc/passwd")}, errors: true},
+		{name: "escaping symlink", entries: []testTarEntry{symlink("a/link", "../../etc")}, errors: true},
+		{name: "symlink escaping via symlink", entries: []testTarEntry{
+			testTarEntry{hdr: tar.Header{Name: "b/", Typeflag: tar.TypeDir, Mode: 0755}},
+			symlink("b/c", ".."), symlink("z", "b/c/.."),
+		}, errors: true},
+		{name: "write through symlink", entries: []testTarEntry{
+			testTarEntry{hdr: tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755}},
+			symlink("link", "a"), file("link/evil.json", "{}"),
+		}, errors: true},
+		{name: "too many files", entries: []testTarEntry{file("a.json", "{}"), file("b.json", "{}")},
+			limits: ArchiveLimits{MaxFiles: 1}, errors: true},
+		{name: "too large", entries: []testTarEntry{file("a.json", "{}"), file("b.json", `{"a": "b"}`)},
+			limits: ArchiveLimits{MaxBytes: 10}, errors: true},
+		{name: "symlink target counted in size", entries: []testTarEntry{file("a.json", "{}"), symlink("b.json", "a.json")},
+			limits: ArchiveLimits{MaxBytes: 7}, errors: true},
+		{name: "exactly at size limit", entries: []testTarEntry{file("a.json", "{}"), file("b.json", `{"a": 1}`)},
+			limits: ArchiveLimits{MaxBytes: 10}, exist: []string{"a.json", "b.json"}},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := createTestTar(t, tmpDir, test.entries)
+			dest := filepath.Join(tmpDir, "extract", strconv.Itoa(i))
+			err := extractArchive(context.Background(), archive, dest, test.limits)
+			if test.errors {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				if _, err := os.Stat(filepath.Join(tmpDir, "evil.json")); !os.IsNotExist(err) {
+					t.Error("Expected nothing to be written outside the extract dir")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error extracting: %s", err)
+			}
+			for _, p := range test.exist {
+				if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(p))); err != nil {
+					t.Errorf("Expected %s to exist: %s", p, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEntryPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		exp    string
+		errors bool
+	}{
+		{name: "a/b.json", exp: "a/b.json"},
+		{name: "./a/b.json", exp: "a/b.json"},
+		{name: "a/../b.json", exp: "b.json"},
+		{name: "a/", exp: "a"},
+		{name: "./", exp: "."},
+		{name: "..", errors: true},
+		{name: "../a", errors: true},
+		{name: "a/../../b", errors: true},
+		{name: "/a", errors: true},
+		{name: "", errors: true},
+	}
+	for _, test := range tests {
+		r, err := entryPath(test.name)
+		if test.errors {
+			if err == nil {
+				t.Errorf("Expected error for %q, got %q", test.name, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", test.name, err)
+		}
+		if r != test.exp {
+			t.Errorf("Expected %q for %q, got %q", test.exp, test.name, r)
+		}
+	}
+}
+
+func TestExtractArchive_zipSymlinkTarget(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		target string
+		errors bool
+	}{
+		{name: "short target", target: "a.json"},
+		{name: "long target", target: strings.Repeat("a/", maxLinkTarget), errors: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "links.zip")
+			f, err := os.Create(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw := zip.NewWriter(f)
+			w, _ := zw.Create("a.json")
+			w.Write([]byte("{}"))
+			hdr := &zip.FileHeader{Name: "link.json", Method: zip.Deflate}
+			hdr.SetMode(os.ModeSymlink | 0777)
+			w, err = zw.CreateHeader(hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(test.target))
+			zw.Close()
+			f.Close()
+
+			err = extractArchive(context.Background(), archive, filepath.Join(dir, test.name), ArchiveLimits{})
+			if test.errors && (err == nil || !strings.Contains(err.Error(), "longer than 4096 bytes")) {
+				t.Errorf("Expected an error for a long symlink target, got %v", err)
+			}
+			if !test.errors && err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package document
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/starlingbank/vaultsmith/config"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Header of a file encrypted by Encrypt. It is followed by the salt, nonce and ciphertext.
+var encryptedMagic = []byte("VSENC01\n")
+
+const saltLen = 16
+
+// Encrypt data with AES-256-GCM, using a key derived from secret with scrypt
+func Encrypt(data []byte, secret []byte) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(append(append([]byte{}, encryptedMagic...), salt...), nonce...)
+	return gcm.Seal(out, nonce, data, encryptedMagic), nil
+}
+
+// Decrypt data encrypted by Encrypt
+func Decrypt(data []byte, secret []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("not encrypted by vaultsmith")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < saltLen {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	gcm, err := newGCM(secret, data[:saltLen])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltLen:]
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt, the key or passphrase may be wrong")
+	}
+	return plain, nil
+}
+
+// Whether data, or its first bytes, were encrypted by Encrypt
+func IsEncrypted(header []byte) bool {
+	return bytes.HasPrefix(header, encryptedMagic)
+}
+
+func newGCM(secret []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Return the secret to encrypt backups, and decrypt encrypted archives, with: the contents of
+// config.BackupKeyFile or config.BackupPassphrase. Returns nil if neither is set.
+func EncryptionSecret(config config.VaultsmithConfig) ([]byte, error) {
+	switch {
+	case config.BackupKeyFile != "" && config.BackupPassphrase != "":
+		return nil, fmt.Errorf("specify a backup key file or passphrase, not both")
+	case config.BackupKeyFile != "":
+		key, err := ioutil.ReadFile(config.BackupKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read backup key file: %s", err)
+		}
+		key = bytes.TrimSpace(key)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("backup key file %s is empty", config.BackupKeyFile)
+		}
+		return key, nil
+	case config.BackupPassphrase != "":
+		return []byte(config.BackupPassphrase), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,118 @@
+package document
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/config"
+)
+
+func TestEncrypt(t *testing.T) {
+	data := []byte("some secret data")
+	encrypted, err := Encrypt(data, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(string(encrypted), string(data)) {
+		t.Errorf("Expected the data to be encrypted, got %q", encrypted)
+	}
+
+	decrypted, err := Decrypt(encrypted, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Error calling Decrypt: %s", err)
+	}
+	if string(decrypted) != string(data) {
+		t.Errorf("Expected %q, got %q", data, decrypted)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		secret string
+	}{
+		{"wrong passphrase", encrypted, "wrong"},
+		{"truncated", encrypted[:len(encryptedMagic)+4], "passphrase"},
+		{"tampered", append(append([]byte{}, encrypted[:len(encrypted)-1]...), encrypted[len(encrypted)-1]^1), "passphrase"},
+		{"not encrypted", data, "passphrase"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.data, []byte(tt.secret)); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestEncryptionSecret(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte("key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(emptyFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  config.VaultsmithConfig
+		exp     string
+		wantErr bool
+	}{
+		{"none", config.VaultsmithConfig{}, "", false},
+		{"key file", config.VaultsmithConfig{BackupKeyFile: keyFile}, "key", false},
+		{"passphrase", config.VaultsmithConfig{BackupPassphrase: "pass"}, "pass", false},
+		{"both", config.VaultsmithConfig{BackupKeyFile: keyFile, BackupPassphrase: "pass"}, "", true},
+		{"empty key file", config.VaultsmithConfig{BackupKeyFile: emptyFile}, "", true},
+		{"missing key file", config.VaultsmithConfig{BackupKeyFile: filepath.Join(dir, "missing")}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := EncryptionSecret(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if string(secret) != tt.exp {
+				t.Errorf("Expected secret %q, got %q", tt.exp, secret)
+			}
+		})
+	}
+}
+
+func TestLocalTarball_encrypted(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join(examplePath(), "example.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := Encrypt(data, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "example.tar.gz.enc")
+	if err := ioutil.WriteFile(archivePath, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l := LocalTarball{WorkDir: t.TempDir(), ArchivePath: archivePath}
+	if err := l.Get(context.Background()); err == nil || !strings.Contains(err.Error(), "is encrypted") {
+		t.Errorf("Expected an error without a secret, got %v", err)
+	}
+
+	l = LocalTarball{WorkDir: t.TempDir(), ArchivePath: archivePath, Secret: []byte("passphrase")}
+	if err := l.Get(context.Background()); err != nil {
+		t.Fatalf("Error calling Get: %s", err)
+	}
+	path, err := l.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "sys")); err != nil {
+		t.Errorf("Expected the documents to be extracted: %s", err)
+	}
+}
@@ -0,0 +1,119 @@
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Return whether the file is a YAML document, by its extension. Anything else is parsed as JSON.
+func IsYAML(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// Parse a JSON or YAML document into v, choosing the format by the extension of name. YAML is
+// converted to JSON first, so json struct tags apply to both. Errors give the line and column of
+// the failure where it is known.
+func Unmarshal(name string, data []byte, v interface{}) error {
+	if !IsYAML(name) {
+		return jsonError(data, json.Unmarshal(data, v))
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if len(root.Content) == 0 {
+		return fmt.Errorf("document is empty")
+	}
+	var generic interface{}
+	if err := root.Decode(&generic); err != nil {
+		return fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	b, err := json.Marshal(jsonCompatible(generic))
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, v)
+	if e, ok := err.(*json.UnmarshalTypeError); ok {
+		// offsets are into the converted document, so find the field in the original instead
+		if n := findField(root.Content[0], e.Field); n != nil {
+			return fmt.Errorf("line %d, column %d: cannot use %s as %s in field %q",
+				n.Line, n.Column, e.Value, e.Type, e.Field)
+		}
+	}
+	return err
+}
+
+// Add the position of a JSON syntax or type error to its message
+func jsonError(data []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	return fmt.Errorf("line %d, column %d: %s", line, column, err)
+}
+
+// Convert maps with non-string keys, which YAML allows, to maps json can encode
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = jsonCompatible(e)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range t {
+			t[i] = jsonCompatible(e)
+		}
+	}
+	return v
+}
+
+// Return the value node of a dotted field path within a mapping, or nil if it is not found
+func findField(n *yaml.Node, field string) *yaml.Node {
+	if field == "" {
+		return n
+	}
+	for _, key := range strings.Split(field, ".") {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				next = n.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+package document
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		data   string
+		exp    map[string]interface{}
+		errMsg string // expected substring of the error, if any
+	}{
+		{name: "json", file: "role.json", data: `{"policies": ["a"], "ttl": 60}`,
+			exp: map[string]interface{}{"policies": []interface{}{"a"}, "ttl": float64(60)}},
+		{name: "no extension is json", file: "role", data: `{"ttl": "1h"}`,
+			exp: map[string]interface{}{"ttl": "1h"}},
+		{name: "json syntax error", file: "role.json", data: "{\n  \"ttl\": 60,\n}",
+			errMsg: "line 3, column 1: invalid character '}'"},
+		{name: "json type error", file: "role.json", data: "[\n  1\n]",
+			errMsg: "line 1, column 1: json: cannot unmarshal array"},
+		{name: "yaml", file: "role.yaml", data: "# comment\npolicies:\n  - a # inline\nttl: 60\n",
+			exp: map[string]interface{}{"policies": []interface{}{"a"}, "ttl": float64(60)}},
+		{name: "yml", file: "role.YML", data: "nested: {a: true}",
+			exp: map[string]interface{}{"nested": map[string]interface{}{"a": true}}},
+		{name: "yaml non-string keys", file: "role.yaml", data: "codes:\n  200: ok\n",
+			exp: map[string]interface{}{"codes": map[string]interface{}{"200": "ok"}}},
+		{name: "yaml syntax error", file: "role.yaml", data: "ttl: 60\n  policies: a\n",
+			errMsg: "line 2: mapping values are not allowed"},
+		{name: "yaml empty", file: "role.yaml", data: "# nothing here\n", errMsg: "document is empty"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got map[string]interface{}
+			err := Unmarshal(test.file, []byte(test.data), &got)
+			if test.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+					t.Errorf("Expected error containing %q, got %v", test.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.exp) {
+				t.Errorf("Expected %#v, got %#v", test.exp, got)
+			}
+		})
+	}
+}
+
+func TestUnmarshal_yamlStruct(t *testing.T) {
+	var tp TemplateParams
+	data := "variables:\n  env: prod\ninstances:\n  service: [a, b]\n"
+	if err := Unmarshal("_vaultsmith.yaml", []byte(data), &tp); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if tp.Variables["env"] != "prod" || !reflect.DeepEqual(tp.Instances["service"], []string{"a", "b"}) {
+		t.Errorf("Unexpected params %+v", tp)
+	}
+
+	data = "variables:\n  env: prod\ninstances:\n  service: a\n"
+	err := Unmarshal("_vaultsmith.yaml", []byte(data), &tp)
+	if err == nil || !strings.Contains(err.Error(), "line 4, column 12") {
+		t.Errorf("Expected error at line 4, column 12, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FS is a document set read from an fs.FS, e.g. an embed.FS or fstest.MapFS, for use when
+// vaultsmith is imported as a library. The rest of vaultsmith operates on a directory of files, so
+// the documents are copied into the work dir.
+// Implements document.Set
+type FS struct {
+	WorkDir string
+	FS      fs.FS
+}
+
+func (f *FS) Get(ctx context.Context) (err error) {
+	destDir := f.extractPath()
+	log.Debugf("Copying documents to %s", destDir)
+	return fs.WalkDir(f.FS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error reading %q: %s", path, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		if !d.Type().IsRegular() {
+			log.Debugf("Skipping %q, not a regular file", path)
+			return nil
+		}
+		return copyFromFS(f.FS, path, dest)
+	})
+}
+
+// Return the path to the copied documents
+func (f *FS) Path() (path string, err error) {
+	return f.extractPath(), nil
+}
+
+func (f *FS) CleanUp() {
+	log.Infof("Removing %s", f.extractPath())
+	err := os.RemoveAll(f.extractPath())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (f *FS) extractPath() string {
+	return filepath.Join(f.WorkDir, "fs")
+}
+
+func copyFromFS(fsys fs.FS, path string, dest string) error {
+	in, err := fsys.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %q: %s", path, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating file %q: %s", dest, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("error writing to file %q: %s", dest, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package document
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS_Get(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	f := FS{
+		WorkDir: tmpDir,
+		FS: fstest.MapFS{
+			"sys/policy/foo.json": &fstest.MapFile{Data: []byte(`{"policy": ""}`)},
+			"_vaultsmith.json":    &fstest.MapFile{Data: []byte(`{}`)},
+		},
+	}
+	if err := f.Get(context.Background()); err != nil {
+		t.Fatalf("Error calling Get: %s", err)
+	}
+	defer f.CleanUp()
+
+	path, err := f.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ioutil.ReadFile(filepath.Join(path, "sys", "policy", "foo.json"))
+	if err != nil {
+		t.Fatalf("Expected document to be copied: %s", err)
+	}
+	if string(c) != `{"policy": ""}` {
+		t.Errorf("Unexpected content %q", c)
+	}
+}
@@ -0,0 +1,147 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GitRepository is a document set cloned from a git repository, given a document path such as
+// git+https://github.com/org/repo.git//data?ref=v1.0.0
+// The ref may be a branch, tag or commit SHA, and the optional //subdir is the directory within
+// the repository containing the documents. The git command must be installed; authentication is
+// left to it, e.g. via ssh-agent or a credential helper.
+// Implements document.Set and document.Versioned
+type GitRepository struct {
+	WorkDir string
+	Url     string // url of the repository, as understood by git
+	Ref     string // branch, tag or commit SHA to check out. Defaults to HEAD
+	SubDir  string // directory within the repository containing the documents
+	commit  string // the resolved commit SHA
+}
+
+// Parse a git+<scheme>:// document path into a GitRepository
+func NewGitRepository(workDir string, documentPath string) (*GitRepository, error) {
+	if !strings.HasPrefix(documentPath, "git+") {
+		return nil, fmt.Errorf("%q is not a git url, expected git+https:// or git+ssh://", documentPath)
+	}
+	u, err := url.Parse(strings.TrimPrefix(documentPath, "git+"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %s", documentPath, err)
+	}
+
+	query := u.Query()
+	ref := query.Get("ref")
+	query.Del("ref")
+	u.RawQuery = query.Encode()
+
+	var subDir string
+	if i := strings.Index(u.Path, "//"); i >= 0 {
+		subDir = strings.Trim(u.Path[i+2:], "/")
+		u.Path = u.Path[:i]
+	}
+
+	return &GitRepository{
+		WorkDir: workDir,
+		Url:     u.String(),
+		Ref:     ref,
+		SubDir:  subDir,
+	}, nil
+}
+
+// Fetch only the requested ref, with no history
+func (g *GitRepository) Get(ctx context.Context) (err error) {
+	ref := g.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// the ref comes from the document path, so must not be taken as an option by git
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid ref %q, refs may not start with -", ref)
+	}
+	dir := g.clonePath()
+	log.Infof("Fetching %s at %s to %s", g.redactedUrl(), ref, dir)
+
+	if _, err := g.git(ctx, "", "init", "--quiet", dir); err != nil {
+		return err
+	}
+	// fetching by ref, rather than cloning, allows commit SHAs as well as branches and tags
+	if _, err := g.git(ctx, dir, "fetch", "--quiet", "--depth", "1", "--", g.Url, ref); err != nil {
+		return err
+	}
+	if _, err := g.git(ctx, dir, "checkout", "--quiet", "--detach", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	commit, err := g.git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	g.commit = strings.TrimSpace(commit)
+	log.Infof("Checked out %s at commit %s", g.redactedUrl(), g.commit)
+	// the repository metadata would otherwise be walked as documents if there is no SubDir
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Return the path to the documents within the repository. It does not guarantee that the path
+// exists.
+func (g *GitRepository) Path() (path string, err error) {
+	return filepath.Join(g.clonePath(), filepath.FromSlash(g.SubDir)), nil
+}
+
+func (g *GitRepository) CleanUp() {
+	log.Infof("Removing %s", g.clonePath())
+	err := os.RemoveAll(g.clonePath())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Return the commit SHA checked out by Get
+func (g *GitRepository) Version() string {
+	return g.commit
+}
+
+func (g *GitRepository) clonePath() string {
+	return filepath.Join(g.WorkDir, "git")
+}
+
+// Return the url without any credentials in it, for logs and errors. A token may be given as the
+// user alone, so url.URL.Redacted is not enough.
+func (g *GitRepository) redactedUrl() string {
+	u, err := url.Parse(g.Url)
+	if err != nil || u.User == nil {
+		return g.Url
+	}
+	u.User = nil
+	return u.String()
+}
+
+// Run a git command in dir, returning its output
+func (g *GitRepository) git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// never prompt for credentials, there is nobody to answer
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		// git may echo the url in its errors
+		msg := strings.ReplaceAll(strings.TrimSpace(stderr.String()), g.Url, g.redactedUrl())
+		return "", fmt.Errorf("git %s failed: %s: %s", args[0], err, msg)
+	}
+	return stdout.String(), nil
+}
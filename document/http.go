@@ -0,0 +1,223 @@
+package document
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Authorization header schemes for HttpOptions.AuthScheme
+const (
+	AuthSchemeToken  = "token"  // "Authorization: token <AuthToken>", as used by Github
+	AuthSchemeBearer = "bearer" // "Authorization: Bearer <AuthToken>"
+	AuthSchemeBasic  = "basic"  // AuthToken is "user:password"
+)
+
+// How documents are fetched over http
+type HttpOptions struct {
+	AuthToken  string
+	AuthScheme string            // one of the AuthScheme constants. Defaults to AuthSchemeToken
+	Headers    map[string]string // extra request headers
+	CACert     string            // PEM file of CAs to trust, in addition to the system pool
+	ClientCert string            // PEM file of a client certificate, for mutual TLS
+	ClientKey  string            // PEM file of the key for ClientCert
+	Proxy      string            // proxy url. If empty, HTTP_PROXY and friends are used
+	Timeout    time.Duration     // timeout of each request, including reading the body. Zero for none
+	Retries    int               // number of times to retry on a 5xx response or connection error
+	RetryWait  time.Duration     // wait before the first retry, doubled for each retry. Defaults to 1s
+	MaxBytes   int64             // maximum size of a download. Zero means DefaultArchiveLimits.MaxBytes
+}
+
+// Build an http.Client for the options
+func (o HttpOptions) client() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if o.Proxy != "" {
+		proxy, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse proxy url %q: %s", o.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if o.CACert != "" || o.ClientCert != "" {
+		tlsConfig := &tls.Config{}
+		if o.CACert != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			pem, err := ioutil.ReadFile(o.CACert)
+			if err != nil {
+				return nil, fmt.Errorf("could not read CA certificate: %s", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %q", o.CACert)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if o.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("could not load client certificate: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport, Timeout: o.Timeout}, nil
+}
+
+// Set the authorization and extra headers on req
+func (o HttpOptions) authorize(req *http.Request) error {
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+	if o.AuthToken == "" {
+		return nil
+	}
+	switch strings.ToLower(o.AuthScheme) {
+	case "", AuthSchemeToken:
+		req.Header.Set("Authorization", fmt.Sprintf("token %s", o.AuthToken))
+	case AuthSchemeBearer:
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.AuthToken))
+	case AuthSchemeBasic:
+		if !strings.Contains(o.AuthToken, ":") {
+			return fmt.Errorf("basic auth token must be of the form user:password")
+		}
+		req.Header.Set("Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(o.AuthToken)))
+	default:
+		return fmt.Errorf("unknown auth scheme %q, expected token, bearer or basic", o.AuthScheme)
+	}
+	return nil
+}
+
+// Returned by fetch when a conditional request finds the resource is not modified
+var errNotModified = errors.New("not modified")
+
+// Returned by fetch when the server could not be reached, or failed, after all retries
+type unreachableError struct {
+	err error
+}
+
+func (e *unreachableError) Error() string {
+	return e.err.Error()
+}
+
+// Download u to the file at path, retrying on server errors. header is added to the request, e.g.
+// for conditional requests. Returns the number of bytes written and the response headers.
+func (o HttpOptions) fetch(ctx context.Context, u *url.URL, path string, header http.Header) (n int64, resHeader http.Header, err error) {
+	client, err := o.client()
+	if err != nil {
+		return 0, nil, err
+	}
+	wait := o.RetryWait
+	if wait == 0 {
+		wait = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		n, resHeader, retry, err = o.fetchOnce(ctx, client, u, path, header)
+		if err == nil || !retry {
+			return n, resHeader, err
+		}
+		if attempt >= o.Retries {
+			return n, resHeader, &unreachableError{err: err}
+		}
+		log.Warnf("Fetching %s failed, retrying in %s: %s", u.Redacted(), wait, err)
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// Make a single attempt to download u to path, returning whether a failure may be retried
+func (o HttpOptions) fetchOnce(ctx context.Context, client *http.Client, u *url.URL, path string, header http.Header) (n int64, resHeader http.Header, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if err := o.authorize(req); err != nil {
+		return 0, nil, false, err
+	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, nil, ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return 0, res.Header, true, fmt.Errorf("status code %v", res.StatusCode)
+	}
+	if res.StatusCode == http.StatusNotModified {
+		return 0, res.Header, false, errNotModified
+	}
+	if res.StatusCode != 200 {
+		return 0, res.Header, false, fmt.Errorf("status code %v", res.StatusCode)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, res.Header, false, err
+	}
+	defer out.Close()
+
+	max := ArchiveLimits{MaxBytes: o.MaxBytes}.orDefault().MaxBytes
+	if res.ContentLength > max {
+		return 0, res.Header, false, fmt.Errorf("download is larger than the limit of %d bytes", max)
+	}
+	p := &progress{url: u, total: res.ContentLength, interval: 5 * time.Second, last: time.Now()}
+	n, err = copyLimited(out, io.TeeReader(res.Body, p), max)
+	if n > max {
+		return n, res.Header, false, err
+	}
+	if err != nil {
+		// the connection may have dropped part way through
+		return n, res.Header, ctx.Err() == nil, err
+	}
+	return n, res.Header, false, nil
+}
+
+// Logs the progress of a download periodically
+type progress struct {
+	url      *url.URL
+	total    int64 // expected size, or -1 if unknown
+	read     int64
+	interval time.Duration
+	last     time.Time
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.read += int64(len(b))
+	if time.Since(p.last) < p.interval {
+		return len(b), nil
+	}
+	p.last = time.Now()
+	if p.total > 0 {
+		log.Infof("Downloaded %d of %d bytes (%d%%) from %s",
+			p.read, p.total, p.read*100/p.total, p.url.Redacted())
+	} else {
+		log.Infof("Downloaded %d bytes from %s", p.read, p.url.Redacted())
+	}
+	return len(b), nil
+}
@@ -0,0 +1,132 @@
+package document
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How the documents were obtained, when an http cache is used
+const (
+	CacheStatusDownloaded  = "downloaded"
+	CacheStatusNotModified = "not modified, used cached copy"
+	CacheStatusOffline     = "server unreachable, used cached copy"
+)
+
+// Implemented by a Set which may use cached documents
+type Cached interface {
+	CacheStatus() string // one of the CacheStatus constants, or empty if not cached. Only valid after Get
+}
+
+// A persistent cache of http downloads, keyed by url
+type httpCache struct {
+	Dir string
+}
+
+// Metadata of a cached download, used to make conditional requests
+type cacheEntry struct {
+	Url          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// Return an identifier of the cached version: the ETag, or the Last-Modified time
+func (e cacheEntry) version() string {
+	if e.ETag != "" {
+		return strings.Trim(e.ETag, `"`)
+	}
+	return e.LastModified
+}
+
+// Return the url identifying a cache entry: u without any credentials, so they are not written to
+// disk
+func cacheUrl(u *url.URL) string {
+	withoutUser := *u
+	withoutUser.User = nil
+	return withoutUser.String()
+}
+
+// Return the paths of the cached content, and its metadata
+func (c httpCache) paths(u *url.URL) (content string, meta string) {
+	sum := sha256.Sum256([]byte(cacheUrl(u)))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(c.Dir, key), filepath.Join(c.Dir, key+".json")
+}
+
+// Return the cache entry for u, or nil if there is none
+func (c httpCache) load(u *url.URL) (*cacheEntry, error) {
+	content, meta := c.paths(u)
+	b, err := ioutil.ReadFile(meta)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(content); os.IsNotExist(err) {
+		return nil, nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, fmt.Errorf("could not parse cache metadata %q: %s", meta, err)
+	}
+	if entry.Url != cacheUrl(u) {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// Store the file at src as the cached content of u
+func (c httpCache) store(u *url.URL, src string, entry cacheEntry) error {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	content, meta := c.paths(u)
+	// write to temporary files and rename, so a concurrent run never sees a partial entry
+	if err := copyFile(src, content+".tmp"); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(meta+".tmp", b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(content+".tmp", content); err != nil {
+		return err
+	}
+	return os.Rename(meta+".tmp", meta)
+}
+
+// Copy the cached content of u to dest
+func (c httpCache) copyTo(u *url.URL, dest string) error {
+	content, _ := c.paths(u)
+	return copyFile(content, dest)
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
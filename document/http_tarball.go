@@ -1,31 +1,50 @@
 package document
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
-// Implements document.Set
+// Implements document.Set, document.Versioned, document.Downloaded and document.Cached
 type HttpTarball struct {
 	LocalTarball
-	Url       *url.URL
-	AuthToken string
+	HttpOptions
+	Url *url.URL
+	// location of the detached signature, downloaded if LocalTarball.Integrity has a PublicKey
+	SignatureUrl *url.URL
+	// directory to cache downloads in, between runs. If empty, nothing is cached
+	CacheDir string
+	// use the cached archive if the server cannot be reached
+	OfflineOk   bool
+	bytes       int64  // bytes downloaded
+	version     string // ETag or Last-Modified time of the archive
+	cacheStatus string
 }
 
 // download tarball from Github
-func (h *HttpTarball) Get() (err error) {
-	downloadPath, err := h.download()
+func (h *HttpTarball) Get(ctx context.Context) (err error) {
+	downloadPath, err := h.download(ctx)
 	if err != nil {
 		return fmt.Errorf("error downloading tarball: %s", err)
 	}
 
 	h.LocalTarball.ArchivePath = downloadPath
-	err = h.LocalTarball.extract()
+	if h.Integrity.PublicKey != nil && h.SignatureUrl != nil {
+		sigPath := downloadPath + ".sig"
+		log.Infof("Downloading signature from %s to %s", h.SignatureUrl.Redacted(), sigPath)
+		_, _, err := h.fetchCached(ctx, h.SignatureUrl, sigPath)
+		if err != nil {
+			return fmt.Errorf("error downloading signature: %s", err)
+		}
+		h.Integrity.Signature = sigPath
+	}
+	err = h.LocalTarball.extract(ctx)
 	if err != nil {
 		return fmt.Errorf("error extracting tarball: %s", err)
 	}
@@ -47,39 +66,100 @@ func (h *HttpTarball) CleanUp() {
 	return
 }
 
-func (h *HttpTarball) download() (path string, err error) {
-	log.Infof("Downloading from %s to %s", h.Url.String(), h.archivePath())
-	out, err := os.Create(h.archivePath())
-	if err != nil {
-		return "", err
-	}
-	defer out.Close()
+// Return the number of bytes downloaded by Get
+func (h *HttpTarball) BytesDownloaded() int64 {
+	return h.bytes
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", h.Url.String(), nil)
+// Return the ETag, or Last-Modified time, of the archive, if the server gave one
+func (h *HttpTarball) Version() string {
+	return h.version
+}
+
+// Return how the archive was obtained, if CacheDir is set
+func (h *HttpTarball) CacheStatus() string {
+	return h.cacheStatus
+}
+
+func (h *HttpTarball) download(ctx context.Context) (path string, err error) {
+	log.Infof("Downloading from %s to %s", h.Url.Redacted(), h.archivePath())
+	version, status, err := h.fetchCached(ctx, h.Url, h.archivePath())
 	if err != nil {
 		return "", err
 	}
-	if h.AuthToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("token %s", h.AuthToken))
+	h.version = version
+	h.cacheStatus = status
+
+	return h.archivePath(), nil
+}
+
+// Download u to dest, via the cache if CacheDir is set. Returns the version of the content and,
+// if cached, how it was obtained.
+func (h *HttpTarball) fetchCached(ctx context.Context, u *url.URL, dest string) (version string, status string, err error) {
+	if h.CacheDir == "" {
+		n, resHeader, err := h.fetch(ctx, u, dest, nil)
+		h.bytes += n
+		if err != nil {
+			return "", "", err
+		}
+		log.Infof("%v bytes written to %s", n, dest)
+		return responseEntry(u, resHeader).version(), "", nil
 	}
-	res, err := client.Do(req)
+
+	cache := httpCache{Dir: h.CacheDir}
+	entry, err := cache.load(u)
 	if err != nil {
-		return "", err
+		log.Warnf("Ignoring cache for %s: %s", u.Redacted(), err)
+		entry = nil
+	}
+	header := http.Header{}
+	if entry != nil {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		return "", fmt.Errorf("status code %v", res.StatusCode)
+	n, resHeader, err := h.fetch(ctx, u, dest, header)
+	h.bytes += n
+	if err == nil {
+		log.Infof("%v bytes written to %s", n, dest)
+		newEntry := responseEntry(u, resHeader)
+		if err := cache.store(u, dest, newEntry); err != nil {
+			log.Warnf("Could not cache %s: %s", u.Redacted(), err)
+		}
+		return newEntry.version(), CacheStatusDownloaded, nil
+	}
+	if entry == nil {
+		return "", "", err
 	}
 
-	n, err := io.Copy(out, res.Body)
-	if err != nil {
-		return "", err
+	if err == errNotModified {
+		status = CacheStatusNotModified
+		log.Infof("%s not modified since %s, using cached copy", u.Redacted(), entry.Fetched.Format(time.RFC3339))
+	} else if _, unreachable := err.(*unreachableError); unreachable && h.OfflineOk {
+		status = CacheStatusOffline
+		log.Warnf("Could not fetch %s, using cached copy from %s: %s",
+			u.Redacted(), entry.Fetched.Format(time.RFC3339), err)
+	} else {
+		return "", "", err
+	}
+	if err := cache.copyTo(u, dest); err != nil {
+		return "", "", fmt.Errorf("could not use cached copy: %s", err)
 	}
-	log.Infof("%v bytes written to %s", n, h.archivePath())
+	return entry.version(), status, nil
+}
 
-	return out.Name(), nil
+// Return the cache entry describing a response
+func responseEntry(u *url.URL, header http.Header) cacheEntry {
+	return cacheEntry{
+		Url:          cacheUrl(u),
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+	}
 }
 
 func (h *HttpTarball) archivePath() (path string) {
@@ -1,6 +1,7 @@
 package document
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -51,7 +52,7 @@ func TestHttpTarball_Get(t *testing.T) {
 		Url: url,
 	}
 
-	p.Get()
+	p.Get(context.Background())
 	defer p.CleanUp()
 
 	if _, err := os.Stat(p.archivePath()); os.IsNotExist(err) {
@@ -67,6 +68,9 @@ func TestHttpTarball_Get(t *testing.T) {
 	if string(c) != expected+"\n" {
 		t.Errorf("Expected file contents to be %q, got %q", expected, c)
 	}
+	if p.BytesDownloaded() != int64(len(expected)+1) {
+		t.Errorf("Expected %d bytes downloaded, got %d", len(expected)+1, p.BytesDownloaded())
+	}
 }
 
 func TestHttpTarball_extract(t *testing.T) {
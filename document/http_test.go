@@ -0,0 +1,246 @@
+package document
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/starlingbank/vaultsmith/config"
+)
+
+func TestHttpOptions_authorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   HttpOptions
+		exp    string
+		errors bool
+	}{
+		{name: "no token", opts: HttpOptions{}, exp: ""},
+		{name: "default scheme", opts: HttpOptions{AuthToken: "abc"}, exp: "token abc"},
+		{name: "token", opts: HttpOptions{AuthToken: "abc", AuthScheme: "token"}, exp: "token abc"},
+		{name: "bearer", opts: HttpOptions{AuthToken: "abc", AuthScheme: "Bearer"}, exp: "Bearer abc"},
+		{name: "basic", opts: HttpOptions{AuthToken: "user:pass", AuthScheme: "basic"}, exp: "Basic dXNlcjpwYXNz"},
+		{name: "basic without password", opts: HttpOptions{AuthToken: "user", AuthScheme: "basic"}, errors: true},
+		{name: "unknown", opts: HttpOptions{AuthToken: "abc", AuthScheme: "digest"}, errors: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "https://example.com", nil)
+			err := test.opts.authorize(req)
+			if test.errors {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if r := req.Header.Get("Authorization"); r != test.exp {
+				t.Errorf("Expected Authorization %q, got %q", test.exp, r)
+			}
+		})
+	}
+}
+
+func TestHttpOptions_fetchHeaders(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte("data"))
+	}))
+	defer ts.Close()
+
+	opts, err := httpOptions(config.VaultsmithConfig{
+		HttpAuthToken:  "abc",
+		HttpAuthScheme: "bearer",
+		HttpHeaders:    []string{"X-Api-Key: secret", "Accept:application/octet-stream"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	u, _ := url.Parse(ts.URL)
+	if _, _, err := opts.fetch(context.Background(), u, filepath.Join(t.TempDir(), "out"), nil); err != nil {
+		t.Fatalf("Error fetching: %s", err)
+	}
+	exp := map[string]string{
+		"Authorization": "Bearer abc",
+		"X-Api-Key":     "secret",
+		"Accept":        "application/octet-stream",
+	}
+	for k, v := range exp {
+		if got.Get(k) != v {
+			t.Errorf("Expected header %s to be %q, got %q", k, v, got.Get(k))
+		}
+	}
+
+	if _, err := httpOptions(config.VaultsmithConfig{HttpHeaders: []string{"no colon"}}); err == nil {
+		t.Error("Expected error for malformed header, got nil")
+	}
+}
+
+func TestHttpOptions_fetchRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int // number of requests to fail before succeeding
+		status   int
+		retries  int
+		errors   bool
+		requests int
+	}{
+		{name: "success", failures: 0, status: 503, retries: 2, requests: 1},
+		{name: "retried", failures: 2, status: 503, retries: 2, requests: 3},
+		{name: "retries exhausted", failures: 3, status: 500, retries: 2, errors: true, requests: 3},
+		{name: "client error not retried", failures: 1, status: 404, retries: 2, errors: true, requests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= test.failures {
+					w.WriteHeader(test.status)
+					return
+				}
+				w.Write([]byte("data"))
+			}))
+			defer ts.Close()
+
+			opts := HttpOptions{Retries: test.retries, RetryWait: time.Millisecond}
+			u, _ := url.Parse(ts.URL)
+			n, _, err := opts.fetch(context.Background(), u, filepath.Join(t.TempDir(), "out"), nil)
+			if test.errors && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !test.errors && (err != nil || n != 4) {
+				t.Errorf("Expected 4 bytes and no error, got %d bytes and %v", n, err)
+			}
+			if requests != test.requests {
+				t.Errorf("Expected %d requests, got %d", test.requests, requests)
+			}
+		})
+	}
+}
+
+func TestHttpOptions_fetchProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("data"))
+	}))
+	defer proxy.Close()
+
+	opts := HttpOptions{Proxy: proxy.URL}
+	u, _ := url.Parse("http://artifacts.internal/docs.tar.gz")
+	if _, _, err := opts.fetch(context.Background(), u, filepath.Join(t.TempDir(), "out"), nil); err != nil {
+		t.Fatalf("Error fetching: %s", err)
+	}
+	if proxied != u.String() {
+		t.Errorf("Expected request for %s via proxy, got %q", u, proxied)
+	}
+}
+
+// Write a self-signed certificate and key to dir, returning their paths
+func createTestCert(t *testing.T, dir string) (certPath string, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vaultsmith"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath = filepath.Join(dir, "client.pem")
+	keyPath = filepath.Join(dir, "client-key.pem")
+	ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certPath, keyPath
+}
+
+func TestHttpOptions_fetchTLS(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644)
+	certPath, keyPath := createTestCert(t, dir)
+
+	tests := []struct {
+		name   string
+		opts   HttpOptions
+		errors bool
+	}{
+		{name: "untrusted server", opts: HttpOptions{ClientCert: certPath, ClientKey: keyPath}, errors: true},
+		{name: "no client certificate", opts: HttpOptions{CACert: caPath}, errors: true},
+		{name: "mutual TLS", opts: HttpOptions{CACert: caPath, ClientCert: certPath, ClientKey: keyPath}},
+		{name: "missing CA file", opts: HttpOptions{CACert: filepath.Join(dir, "missing.pem")}, errors: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, _ := url.Parse(ts.URL)
+			_, _, err := test.opts.fetch(context.Background(), u, filepath.Join(t.TempDir(), "out"), nil)
+			if test.errors && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !test.errors && err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestHttpOptions_fetchLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// no Content-Length, so the limit is only found while reading
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/sized", "/chunked"} {
+		t.Run(path, func(t *testing.T) {
+			u, _ := url.Parse(ts.URL + path)
+			opts := HttpOptions{MaxBytes: 10}
+			if _, _, err := opts.fetch(context.Background(), u, filepath.Join(t.TempDir(), "out"), nil); err != nil {
+				t.Errorf("Expected a download of the limit to succeed, got %s", err)
+			}
+			opts = HttpOptions{MaxBytes: 9, Retries: 2, RetryWait: time.Millisecond}
+			_, _, err := opts.fetch(context.Background(), u, filepath.Join(t.TempDir(), "out"), nil)
+			if err == nil || !strings.Contains(err.Error(), "larger than the limit of 9 bytes") {
+				t.Errorf("Expected a download over the limit to fail, got %v", err)
+			}
+		})
+	}
+}
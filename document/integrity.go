@@ -0,0 +1,115 @@
+package document
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Integrity checks for an archive, made before it is extracted
+type Integrity struct {
+	Sha256    string            // expected hex encoded SHA-256 digest of the archive. Empty to skip
+	PublicKey ed25519.PublicKey // key the archive must be signed with. Nil to skip
+	Signature string            // path to the detached signature. Defaults to the archive path + ".sig"
+}
+
+// Whether any checks are configured
+func (i Integrity) enabled() bool {
+	return i.Sha256 != "" || i.PublicKey != nil
+}
+
+// Verify the archive at archivePath, returning an error if any configured check fails
+func (i Integrity) verify(archivePath string) error {
+	if !i.enabled() {
+		return nil
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("could not open file %q: %s", archivePath, err)
+	}
+	defer f.Close()
+
+	if i.Sha256 != "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return fmt.Errorf("could not read %q: %s", archivePath, err)
+		}
+		digest := hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(digest, strings.TrimSpace(i.Sha256)) {
+			return fmt.Errorf("sha256 of %q is %s, expected %s", archivePath, digest, i.Sha256)
+		}
+		log.Infof("Verified sha256 of %q", archivePath)
+	}
+
+	if i.PublicKey != nil {
+		sigPath := i.Signature
+		if sigPath == "" {
+			sigPath = archivePath + ".sig"
+		}
+		sig, err := readSignature(sigPath)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		// ed25519 signs the whole message, not a digest, so the archive must be read into memory
+		message, err := ioutil.ReadAll(f)
+		if err != nil {
+			return fmt.Errorf("could not read %q: %s", archivePath, err)
+		}
+		if !ed25519.Verify(i.PublicKey, message, sig) {
+			return fmt.Errorf("signature %q does not match %q", sigPath, archivePath)
+		}
+		log.Infof("Verified signature of %q", archivePath)
+	}
+	return nil
+}
+
+// Read a detached ed25519 signature, either raw or base64 encoded
+func readSignature(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signature: %s", err)
+	}
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%q is not an ed25519 signature, raw or base64 encoded", path)
+	}
+	return sig, nil
+}
+
+// LoadPublicKey reads a PEM encoded ed25519 public key, as written by
+// `openssl pkey -in key.pem -pubout`
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read public key: %s", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%q does not contain a PEM encoded public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key %q: %s", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %q is %T, expected ed25519", path, key)
+	}
+	return edKey, nil
+}
@@ -0,0 +1,201 @@
+package document
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write a public key in PEM form to dir, returning its path and the private key
+func createTestKey(t *testing.T, dir string) (string, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "key.pub.pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, priv
+}
+
+func TestIntegrity_verify(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive := createTestArchive(t, tmpDir, formatGzip)
+	content, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(content)
+	keyPath, priv := createTestKey(t, tmpDir)
+	pub, err := LoadPublicKey(keyPath)
+	if err != nil {
+		t.Fatalf("Error loading public key: %s", err)
+	}
+	_, otherPriv := createTestKey(t, t.TempDir())
+
+	sig := ed25519.Sign(priv, content)
+	rawSig := filepath.Join(tmpDir, "raw.sig")
+	b64Sig := filepath.Join(tmpDir, "b64.sig")
+	badSig := filepath.Join(tmpDir, "bad.sig")
+	notSig := filepath.Join(tmpDir, "not.sig")
+	ioutil.WriteFile(rawSig, sig, 0644)
+	ioutil.WriteFile(b64Sig, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644)
+	ioutil.WriteFile(badSig, ed25519.Sign(otherPriv, content), 0644)
+	ioutil.WriteFile(notSig, []byte("not a signature"), 0644)
+	ioutil.WriteFile(archive+".sig", sig, 0644)
+
+	tests := []struct {
+		name      string
+		integrity Integrity
+		errors    bool
+	}{
+		{name: "no checks", integrity: Integrity{}},
+		{name: "sha256", integrity: Integrity{Sha256: hex.EncodeToString(digest[:])}},
+		{name: "upper case sha256", integrity: Integrity{Sha256: strings.ToUpper(hex.EncodeToString(digest[:])) + "\n"}},
+		{name: "wrong sha256", integrity: Integrity{Sha256: hex.EncodeToString(make([]byte, 32))}, errors: true},
+		{name: "raw signature", integrity: Integrity{PublicKey: pub, Signature: rawSig}},
+		{name: "base64 signature", integrity: Integrity{PublicKey: pub, Signature: b64Sig}},
+		{name: "default signature path", integrity: Integrity{PublicKey: pub}},
+		{name: "signature by another key", integrity: Integrity{PublicKey: pub, Signature: badSig}, errors: true},
+		{name: "malformed signature", integrity: Integrity{PublicKey: pub, Signature: notSig}, errors: true},
+		{name: "missing signature", integrity: Integrity{PublicKey: pub, Signature: filepath.Join(tmpDir, "missing.sig")}, errors: true},
+		{name: "sha256 and signature", integrity: Integrity{Sha256: hex.EncodeToString(digest[:]), PublicKey: pub, Signature: rawSig}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.integrity.verify(archive)
+			if test.errors && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !test.errors && err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestLoadPublicKey_notEd25519(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "key.pem")
+	ioutil.WriteFile(path, []byte("-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"), 0644)
+	if _, err := LoadPublicKey(path); err == nil {
+		t.Error("Expected error loading invalid key, got nil")
+	}
+	if _, err := LoadPublicKey(filepath.Join(tmpDir, "missing.pem")); err == nil {
+		t.Error("Expected error loading missing key, got nil")
+	}
+}
+
+func TestSignatureUrl(t *testing.T) {
+	archiveUrl, _ := url.Parse("https://example.com/releases/docs.tar.gz?token=abc")
+	tests := []struct {
+		signature string
+		exp       string
+	}{
+		{signature: "", exp: "https://example.com/releases/docs.tar.gz.sig?token=abc"},
+		{signature: "https://example.com/other.sig", exp: "https://example.com/other.sig"},
+		{signature: "/etc/vaultsmith/docs.sig", exp: ""},
+	}
+	for _, test := range tests {
+		u, err := signatureUrl(archiveUrl, test.signature)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", test.signature, err)
+			continue
+		}
+		var r string
+		if u != nil {
+			r = u.String()
+		}
+		if r != test.exp {
+			t.Errorf("Expected %q for %q, got %q", test.exp, test.signature, r)
+		}
+	}
+}
+
+func TestHttpTarball_GetSigned(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive := createTestArchive(t, tmpDir, formatGzip)
+	content, _ := ioutil.ReadFile(archive)
+	keyPath, priv := createTestKey(t, tmpDir)
+	pub, _ := LoadPublicKey(keyPath)
+	sig := ed25519.Sign(priv, content)
+	tampered := append([]byte{}, sig...)
+	tampered[0] ^= 0xff
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/docs.tar.gz", func(w http.ResponseWriter, r *http.Request) { w.Write(content) })
+	mux.HandleFunc("/docs.tar.gz.sig", func(w http.ResponseWriter, r *http.Request) { w.Write(sig) })
+	mux.HandleFunc("/tampered.sig", func(w http.ResponseWriter, r *http.Request) { w.Write(tampered) })
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		signature string
+		errors    bool
+	}{
+		{name: "signature next to archive"},
+		{name: "tampered signature", signature: ts.URL + "/tampered.sig", errors: true},
+		{name: "missing signature", signature: ts.URL + "/missing.sig", errors: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workDir, err := ioutil.TempDir(tmpDir, "work-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(ts.URL + "/docs.tar.gz")
+			sigUrl, _ := signatureUrl(u, test.signature)
+			h := HttpTarball{
+				LocalTarball: LocalTarball{WorkDir: workDir, Integrity: Integrity{PublicKey: pub}},
+				Url:          u,
+				SignatureUrl: sigUrl,
+			}
+			err = h.Get(context.Background())
+			if test.errors {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				if _, err := os.Stat(h.extractPath()); !os.IsNotExist(err) {
+					t.Errorf("Expected nothing to be extracted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error calling Get: %s", err)
+			}
+			if _, err := os.Stat(filepath.Join(h.extractPath(), "docs", "sys", "policy", "read.json")); err != nil {
+				t.Errorf("Expected extracted file: %s", err)
+			}
+		})
+	}
+}
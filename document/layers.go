@@ -0,0 +1,253 @@
+package document
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+)
+
+// Name of the marker file, within an overlay, listing paths to remove from the layers beneath it
+const DeleteMarker = "_delete"
+
+// Layers merges document sets in order: a base, then overlays. A file in a later layer replaces
+// the file at the same relative path in earlier layers, ignoring its extension, and a _delete file
+// in an overlay lists paths, relative to its directory, to remove.
+// Implements document.Set, document.Versioned and document.Downloaded
+type Layers struct {
+	WorkDir   string
+	Sets      []Set    // base first
+	layerDirs []string // work dirs created for layers, removed on CleanUp
+}
+
+// Return the document set for config.DocumentPath, with config.Overlays layered on top. If base
+// is not nil, it is used instead of config.DocumentPath. Each layer is fetched into its own
+// directory under workDir.
+func GetLayeredSet(workDir string, base Set, config config.VaultsmithConfig) (Set, error) {
+	if len(config.Overlays) == 0 {
+		if base != nil {
+			return base, nil
+		}
+		return GetSet(workDir, config)
+	}
+
+	layers := &Layers{WorkDir: workDir}
+	paths := config.Overlays
+	if base == nil {
+		paths = append([]string{config.DocumentPath}, paths...)
+	} else {
+		layers.Sets = append(layers.Sets, base)
+	}
+	for _, p := range paths {
+		// sets assume they own their work dir, so each layer has its own
+		layerDir := filepath.Join(workDir, fmt.Sprintf("layer-%d", len(layers.Sets)))
+		if err := os.MkdirAll(layerDir, 0700); err != nil {
+			return nil, err
+		}
+		layers.layerDirs = append(layers.layerDirs, layerDir)
+		layerConfig := config
+		layerConfig.DocumentPath = p
+		if len(layers.Sets) > 0 {
+			// these describe the base archive. Overlays are still verified if a signature key is
+			// set, using the signature next to each archive.
+			layerConfig.TarDir = ""
+			layerConfig.DocumentSha256 = ""
+			layerConfig.DocumentSignature = ""
+			if layerConfig.DocumentSignatureKey != "" && !isArchivePath(p) {
+				log.Warnf("Overlay %q is not an archive, so is not verified", p)
+				layerConfig.DocumentSignatureKey = ""
+			}
+		}
+		s, err := GetSet(layerDir, layerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %s", p, err)
+		}
+		layers.Sets = append(layers.Sets, s)
+	}
+	return layers, nil
+}
+
+// Whether a document path refers to an archive, which can be signed, rather than to a git
+// repository or local directory
+func isArchivePath(p string) bool {
+	if strings.HasPrefix(p, "git+") {
+		return false
+	}
+	f, err := os.Stat(p)
+	return err != nil || !f.IsDir()
+}
+
+// Fetch every layer, then merge them
+func (l *Layers) Get(ctx context.Context) (err error) {
+	for i, s := range l.Sets {
+		if err := s.Get(ctx); err != nil {
+			return fmt.Errorf("error fetching layer %d: %s", i, err)
+		}
+	}
+	return l.merge(ctx)
+}
+
+// Return the path to the merged documents
+func (l *Layers) Path() (path string, err error) {
+	return l.mergePath(), nil
+}
+
+func (l *Layers) CleanUp() {
+	for _, s := range l.Sets {
+		s.CleanUp()
+	}
+	for _, dir := range append(l.layerDirs, l.mergePath()) {
+		log.Infof("Removing %s", dir)
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// Return the versions of the layers which have one, in order
+func (l *Layers) Version() string {
+	var versions []string
+	for _, s := range l.Sets {
+		if v, ok := s.(Versioned); ok && v.Version() != "" {
+			versions = append(versions, v.Version())
+		}
+	}
+	return strings.Join(versions, ", ")
+}
+
+// Return the total bytes downloaded by all layers
+func (l *Layers) BytesDownloaded() (n int64) {
+	for _, s := range l.Sets {
+		if d, ok := s.(Downloaded); ok {
+			n += d.BytesDownloaded()
+		}
+	}
+	return n
+}
+
+func (l *Layers) mergePath() string {
+	return filepath.Join(l.WorkDir, "merged")
+}
+
+func (l *Layers) merge(ctx context.Context) error {
+	dest := l.mergePath()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	for i, s := range l.Sets {
+		src, err := s.Path()
+		if err != nil {
+			return err
+		}
+		log.Debugf("Merging layer %d from %s", i, src)
+		copied := map[string]bool{} // files from this layer, which do not override each other
+		err = filepath.Walk(src, func(p string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dest, rel)
+			switch {
+			case f.IsDir():
+				return os.MkdirAll(target, 0755)
+			case f.Name() == DeleteMarker:
+				if i == 0 {
+					log.Warnf("Ignoring %s in the base layer", p)
+					return nil
+				}
+				return applyDeletions(p, dest, filepath.Dir(rel))
+			case f.Mode().IsRegular():
+				overridden, err := sameDocument(target)
+				if err != nil {
+					return err
+				}
+				for _, o := range overridden {
+					if copied[o] {
+						continue
+					}
+					log.Debugf("Layer %d overrides %s", i, rel)
+					if err := os.Remove(o); err != nil {
+						return err
+					}
+				}
+				copied[target] = true
+				return copyFile(p, target)
+			default:
+				log.Debugf("Skipping %q, not a regular file", p)
+				return nil
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("error merging layer %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Return the files in the directory of path which declare the same document, i.e. which have the
+// same name without its extension, such as a.json and a.yaml
+func sameDocument(path string) ([]string, error) {
+	dir, name := filepath.Split(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	var files []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())) == stem {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// Remove the paths listed in the marker file from root. Paths are relative to dir, the directory
+// of the marker relative to root, unless they start with /. Blank lines, and lines starting with #,
+// are ignored.
+func applyDeletions(marker string, root string, dir string) error {
+	f, err := os.Open(marker)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		// entries starting with / are relative to the root of the document tree
+		base := dir
+		if strings.HasPrefix(entry, "/") {
+			base = "."
+		}
+		rel, err := entryPath(filepath.ToSlash(filepath.Join(base, entry)))
+		if err != nil || rel == "." {
+			return fmt.Errorf("%s:%d: can not delete %q, it is outside the document tree", marker, line, entry)
+		}
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			log.Warnf("%s:%d: %q does not exist in earlier layers", marker, line, entry)
+			continue
+		}
+		log.Infof("Deleting %s, as listed in %s", rel, marker)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,241 @@
+package document
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/config"
+)
+
+// Write files, keyed by slash separated path, under dir
+func writeTestTree(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Return the contents of the files under dir, keyed by slash separated path
+func readTestTree(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(p string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		b, err := ioutil.ReadFile(p)
+		files[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestGetLayeredSet_NoOverlays(t *testing.T) {
+	set, err := GetLayeredSet(t.TempDir(), nil, config.VaultsmithConfig{DocumentPath: "."})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, ok := set.(*LocalFiles); !ok {
+		t.Errorf("Expected *LocalFiles without overlays, got %T", set)
+	}
+}
+
+func TestLayers_Get(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     map[string]string
+		overlays []map[string]string
+		exp      map[string]string
+		errors   bool
+	}{
+		{
+			name: "override",
+			base: map[string]string{"sys/policy/a.json": "base", "sys/policy/b.json": "base"},
+			overlays: []map[string]string{
+				{"sys/policy/a.json": "first", "sys/policy/c.json": "first"},
+				{"sys/policy/a.json": "second"},
+			},
+			exp: map[string]string{
+				"sys/policy/a.json": "second", "sys/policy/b.json": "base", "sys/policy/c.json": "first",
+			},
+		},
+		{
+			name:     "override with another format",
+			base:     map[string]string{"sys/policy/a.json": "base", "sys/policy/a.b.json": "base"},
+			overlays: []map[string]string{{"sys/policy/a.yaml": "first"}},
+			exp:      map[string]string{"sys/policy/a.yaml": "first", "sys/policy/a.b.json": "base"},
+		},
+		{
+			name: "delete",
+			base: map[string]string{
+				"sys/policy/a.json": "base", "sys/policy/b.json": "base",
+				"auth/approle/role/x.json": "base", "auth/approle/role/y.json": "base",
+			},
+			overlays: []map[string]string{
+				{"sys/policy/_delete": "# relative\na.json\n\n/auth/approle\nmissing.json\n"},
+			},
+			exp: map[string]string{"sys/policy/b.json": "base"},
+		},
+		{
+			name:     "delete then re-add in a later layer",
+			base:     map[string]string{"sys/policy/a.json": "base"},
+			overlays: []map[string]string{{"_delete": "sys/policy/a.json"}, {"sys/policy/a.json": "second"}},
+			exp:      map[string]string{"sys/policy/a.json": "second"},
+		},
+		{
+			name:     "delete in base ignored",
+			base:     map[string]string{"sys/policy/a.json": "base", "sys/policy/_delete": "a.json"},
+			overlays: []map[string]string{{"sys/policy/b.json": "first"}},
+			exp:      map[string]string{"sys/policy/a.json": "base", "sys/policy/b.json": "first"},
+		},
+		{
+			name:     "delete outside tree",
+			base:     map[string]string{"sys/policy/a.json": "base"},
+			overlays: []map[string]string{{"sys/_delete": "../../etc"}},
+			errors:   true,
+		},
+		{
+			name:     "delete root",
+			base:     map[string]string{"sys/policy/a.json": "base"},
+			overlays: []map[string]string{{"_delete": "/"}},
+			errors:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			conf := config.VaultsmithConfig{DocumentPath: filepath.Join(dir, "base")}
+			writeTestTree(t, conf.DocumentPath, test.base)
+			for i, o := range test.overlays {
+				p := filepath.Join(dir, "overlay", string(rune('a'+i)))
+				writeTestTree(t, p, o)
+				conf.Overlays = append(conf.Overlays, p)
+			}
+
+			workDir := filepath.Join(dir, "work")
+			set, err := GetLayeredSet(workDir, nil, conf)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			err = set.Get(context.Background())
+			defer set.CleanUp()
+			if test.errors {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error calling Get: %s", err)
+			}
+			path, _ := set.Path()
+			got := readTestTree(t, path)
+			if len(got) != len(test.exp) {
+				t.Errorf("Expected files %v, got %v", keys(test.exp), keys(got))
+			}
+			for k, v := range test.exp {
+				if got[k] != v {
+					t.Errorf("Expected %s to be %q, got %q", k, v, got[k])
+				}
+			}
+		})
+	}
+}
+
+func TestGetLayeredSet_SignatureKey(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, priv := createTestKey(t, dir)
+	base := createTestArchive(t, t.TempDir(), formatGzip)
+	content, err := ioutil.ReadFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(base+".sig", ed25519.Sign(priv, content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overlayDir := filepath.Join(dir, "overlay")
+	writeTestTree(t, overlayDir, map[string]string{"sys/policy/b.json": "overlay"})
+	unsigned := createTestArchive(t, t.TempDir(), formatZip)
+
+	tests := []struct {
+		name    string
+		overlay string
+		errors  bool
+	}{
+		{name: "directory overlay", overlay: overlayDir},
+		{name: "unsigned archive overlay", overlay: unsigned, errors: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := GetLayeredSet(filepath.Join(t.TempDir(), "work"), nil, config.VaultsmithConfig{
+				DocumentPath:         base,
+				Overlays:             []string{test.overlay},
+				DocumentSignatureKey: keyPath,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			err = set.Get(context.Background())
+			defer set.CleanUp()
+			if test.errors && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !test.errors && err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestLayers_CleanUp(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, filepath.Join(dir, "base"), map[string]string{"a.json": "base"})
+	writeTestTree(t, filepath.Join(dir, "overlay"), map[string]string{"b.json": "overlay"})
+	workDir := filepath.Join(dir, "work")
+	set, err := GetLayeredSet(workDir, nil, config.VaultsmithConfig{
+		DocumentPath: filepath.Join(dir, "base"),
+		Overlays:     []string{filepath.Join(dir, "overlay")},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := set.Get(context.Background()); err != nil {
+		t.Fatalf("Error calling Get: %s", err)
+	}
+	set.CleanUp()
+
+	entries, _ := ioutil.ReadDir(workDir)
+	if len(entries) != 0 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("Expected empty work dir after CleanUp, got %s", strings.Join(names, ", "))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "base", "a.json")); err != nil {
+		t.Errorf("Expected local layer to be left alone: %s", err)
+	}
+}
+
+func keys(m map[string]string) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -1,5 +1,7 @@
 package document
 
+import "context"
+
 // LocalFiles effectively serves as a dummy implementation. The rest of vaultsmith is designed
 // to operate on a directory of files, so no special logic is needed.
 
@@ -9,7 +11,7 @@ type LocalFiles struct {
 	Directory string
 }
 
-func (l *LocalFiles) Get() (err error) {
+func (l *LocalFiles) Get(ctx context.Context) (err error) {
 	// nothing to do here, they are already on the file system
 	return nil
 }
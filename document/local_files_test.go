@@ -1,12 +1,13 @@
 package document
 
 import (
+	"context"
 	"testing"
 )
 
 func TestLocalFiles_Get(t *testing.T) {
 	l := LocalFiles{".", "."}
-	if err := l.Get(); err != nil {
+	if err := l.Get(context.Background()); err != nil {
 		t.Errorf("Error running Get: %s", err)
 	}
 }
@@ -1,8 +1,7 @@
 package document
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -11,15 +10,19 @@ import (
 	"path/filepath"
 )
 
+// A local archive of documents: tar, tar.gz, tar.bz2, tar.xz or zip, detected from its content.
 // Implements document.Set
 type LocalTarball struct {
 	WorkDir     string
 	ArchivePath string
-	TarDir      string // directory to look for configuration within the tarball
+	TarDir      string        // directory to look for configuration within the tarball
+	Limits      ArchiveLimits // limits on the size of the archive contents
+	Integrity   Integrity     // checks made before extraction
+	Secret      []byte        // to decrypt the archive, if it was encrypted by Encrypt
 }
 
-func (l *LocalTarball) Get() (err error) {
-	return l.extract()
+func (l *LocalTarball) Get(ctx context.Context) (err error) {
+	return l.extract(ctx)
 }
 
 // Return the path to the extracted files. It does not guarantee that they exist.
@@ -55,57 +58,48 @@ func (l *LocalTarball) CleanUp() {
 	return
 }
 
-func (l *LocalTarball) extract() (err error) {
-	log.Debugf("Extracting %s", l.ArchivePath)
+// Verify and extract the archive, in any format supported by openArchive
+func (l *LocalTarball) extract(ctx context.Context) (err error) {
+	if err := l.Integrity.verify(l.ArchivePath); err != nil {
+		return fmt.Errorf("refusing to extract: %s", err)
+	}
+	archivePath, err := l.decrypt()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Extracting %s", archivePath)
+	return extractArchive(ctx, archivePath, l.extractPath(), l.Limits)
+}
+
+// If the archive is encrypted, decrypt it to the work dir, returning the path of the archive to
+// extract
+func (l *LocalTarball) decrypt() (string, error) {
 	f, err := os.Open(l.ArchivePath)
 	if err != nil {
-		return fmt.Errorf("could not open file %q: %s", l.ArchivePath, err)
+		return "", fmt.Errorf("could not open file %q: %s", l.ArchivePath, err)
+	}
+	header := make([]byte, len(encryptedMagic))
+	n, _ := io.ReadFull(f, header)
+	f.Close()
+	if !IsEncrypted(header[:n]) {
+		return l.ArchivePath, nil
+	}
+	if l.Secret == nil {
+		return "", fmt.Errorf("%s is encrypted, but no backup key file or passphrase was given", l.ArchivePath)
 	}
-	r, err := gzip.NewReader(f)
+	data, err := ioutil.ReadFile(l.ArchivePath)
 	if err != nil {
-		return fmt.Errorf("could not create gzip reader for %q: %s", l.ArchivePath, err)
+		return "", err
 	}
-	tr := tar.NewReader(r)
+	plain, err := Decrypt(data, l.Secret)
 	if err != nil {
-		return fmt.Errorf("could not create tar reader for %q: %s", l.ArchivePath, err)
+		return "", fmt.Errorf("%s: %s", l.ArchivePath, err)
 	}
-
-	destDir := l.extractPath()
-
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("error reading tar archive %q: %s", l.ArchivePath, err)
-		}
-		switch hdr.Typeflag {
-		case tar.TypeDir: // create dir
-			dd := filepath.Join(destDir, hdr.Name)
-			log.Debugf("Creating %q", dd)
-			err := os.MkdirAll(dd, 0777)
-			if err != nil {
-				return fmt.Errorf("error creating directory %q: %s", dd, err)
-			}
-		case tar.TypeReg, tar.TypeRegA:
-			df := filepath.Join(destDir, hdr.Name)
-			log.Infof("Extracting %q", df)
-			w, err := os.Create(df)
-			if err != nil {
-				return fmt.Errorf("error creating file %q: %s", df, err)
-			}
-			_, err = io.Copy(w, tr)
-			if err != nil {
-				return fmt.Errorf("error writing to file %q: %s", df, err)
-			}
-			w.Close()
-		default:
-			log.Debugf("Unhandled tar type: %+v", hdr)
-		}
+	decrypted := l.extractPath() + ".decrypted"
+	if err := ioutil.WriteFile(decrypted, plain, 0600); err != nil {
+		return "", err
 	}
-
-	return
+	return decrypted, nil
 }
 
 func (l *LocalTarball) extractPath() (path string) {
@@ -1,6 +1,7 @@
 package document
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
@@ -51,7 +52,7 @@ func TestLocalTarball_extract(t *testing.T) {
 		WorkDir:     tmpDir,
 		ArchivePath: filepath.Join(examplePath(), "/example.tar.gz"),
 	}
-	err = l.extract()
+	err = l.extract(context.Background())
 	defer l.CleanUp()
 	if err != nil {
 		t.Errorf("Error calling extract: %s", err)
@@ -0,0 +1,159 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// S3Object is an archive of documents in S3, or S3 compatible storage, given a document path such
+// as s3://bucket/releases/docs.tar.gz?versionId=abc
+// Credentials are taken from the standard AWS chain: environment, shared config, then instance
+// role.
+// Implements document.Set, document.Versioned and document.Downloaded
+type S3Object struct {
+	LocalTarball
+	Bucket    string
+	Key       string
+	VersionId string // object version to fetch. Defaults to the latest
+	// endpoint of S3 compatible storage, e.g. http://localhost:9000. Path style addressing is used
+	// if set. Defaults to AWS.
+	Endpoint string
+	// location of the detached signature, downloaded if LocalTarball.Integrity has a PublicKey
+	SignatureUrl *url.URL
+	version      string // the version fetched, or its ETag if the bucket is not versioned
+	bytes        int64  // bytes downloaded
+}
+
+// Parse an s3:// document path into an S3Object
+func NewS3Object(workDir string, documentPath string, endpoint string) (*S3Object, error) {
+	bucket, key, versionId, err := parseS3Url(documentPath)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Object{
+		LocalTarball: LocalTarball{WorkDir: workDir},
+		Bucket:       bucket,
+		Key:          key,
+		VersionId:    versionId,
+		Endpoint:     endpoint,
+	}, nil
+}
+
+// Split s3://bucket/key?versionId=x into its parts
+func parseS3Url(s3Url string) (bucket string, key string, versionId string, err error) {
+	u, err := url.Parse(s3Url)
+	if err != nil {
+		return "", "", "", fmt.Errorf("could not parse %q: %s", s3Url, err)
+	}
+	if u.Scheme != "s3" || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return "", "", "", fmt.Errorf("%q is not an s3 url, expected s3://bucket/key", s3Url)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), u.Query().Get("versionId"), nil
+}
+
+func (s *S3Object) Get(ctx context.Context) (err error) {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	archivePath := filepath.Join(s.WorkDir, path.Base(s.Key))
+	log.Infof("Downloading s3://%s/%s to %s", s.Bucket, s.Key, archivePath)
+	n, version, err := s.download(ctx, client, s.Bucket, s.Key, s.VersionId, archivePath)
+	s.bytes += n
+	if err != nil {
+		return fmt.Errorf("error downloading s3://%s/%s: %s", s.Bucket, s.Key, err)
+	}
+	s.version = version
+	log.Infof("%v bytes written to %s, version %s", n, archivePath, version)
+	s.LocalTarball.ArchivePath = archivePath
+
+	if s.Integrity.PublicKey != nil && s.SignatureUrl != nil {
+		bucket, key, versionId, err := parseS3Url(s.SignatureUrl.String())
+		if err != nil {
+			return err
+		}
+		sigPath := archivePath + ".sig"
+		log.Infof("Downloading signature from %s to %s", s.SignatureUrl, sigPath)
+		n, _, err := s.download(ctx, client, bucket, key, versionId, sigPath)
+		s.bytes += n
+		if err != nil {
+			return fmt.Errorf("error downloading signature: %s", err)
+		}
+		s.Integrity.Signature = sigPath
+	}
+
+	return s.LocalTarball.extract(ctx)
+}
+
+func (s *S3Object) client() (*s3.S3, error) {
+	conf := aws.NewConfig()
+	if s.Endpoint != "" {
+		conf = conf.WithEndpoint(s.Endpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *conf,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create AWS session: %s", err)
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		// S3 compatible storage often ignores the region, but the SDK requires one
+		sess.Config.Region = aws.String("us-east-1")
+	}
+	return s3.New(sess), nil
+}
+
+// Download an object to the file at dest, returning the bytes written and the object version
+func (s *S3Object) download(ctx context.Context, client *s3.S3, bucket string, key string, versionId string, dest string) (n int64, version string, err error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	out, err := client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return 0, "", err
+	}
+	defer out.Body.Close()
+
+	max := s.Limits.orDefault().MaxBytes
+	if aws.Int64Value(out.ContentLength) > max {
+		return 0, "", fmt.Errorf("object is larger than the limit of %d bytes", max)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	n, err = copyLimited(f, out.Body, max)
+	if err != nil {
+		return n, "", err
+	}
+
+	version = aws.StringValue(out.VersionId)
+	if version == "" || version == "null" {
+		version = strings.Trim(aws.StringValue(out.ETag), `"`)
+	}
+	return n, version, nil
+}
+
+// Return the object version fetched, or its ETag if the bucket is not versioned
+func (s *S3Object) Version() string {
+	return s.version
+}
+
+// Return the number of bytes downloaded by Get
+func (s *S3Object) BytesDownloaded() int64 {
+	return s.bytes
+}
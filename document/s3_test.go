@@ -0,0 +1,186 @@
+package document
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/config"
+)
+
+// A minimal stand-in for S3 compatible storage, serving objects by path style url
+type testS3Server struct {
+	objects  map[string][]byte // keyed by "/bucket/key" or "/bucket/key?versionId"
+	versions map[string]string // latest version of an object
+}
+
+func (s *testS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path
+	if v := r.URL.Query().Get("versionId"); v != "" {
+		key = key + "?" + v
+		w.Header().Set("x-amz-version-id", v)
+	} else if v, ok := s.versions[key]; ok {
+		w.Header().Set("x-amz-version-id", v)
+	}
+	content, ok := s.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`))
+		return
+	}
+	w.Header().Set("ETag", `"abc123"`)
+	w.Write(content)
+}
+
+// Use static credentials from the environment, restoring the original values after the test
+func setTestAwsEnv(t *testing.T) {
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID":         "test",
+		"AWS_SECRET_ACCESS_KEY":     "test",
+		"AWS_REGION":                "eu-west-1",
+		"AWS_EC2_METADATA_DISABLED": "true",
+	}
+	for k, v := range env {
+		orig, set := os.LookupEnv(k)
+		os.Setenv(k, v)
+		k := k
+		t.Cleanup(func() {
+			if set {
+				os.Setenv(k, orig)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestParseS3Url(t *testing.T) {
+	tests := []struct {
+		url       string
+		bucket    string
+		key       string
+		versionId string
+		errors    bool
+	}{
+		{url: "s3://bucket/docs.tar.gz", bucket: "bucket", key: "docs.tar.gz"},
+		{url: "s3://bucket/releases/v1/docs.tar.gz?versionId=abc", bucket: "bucket", key: "releases/v1/docs.tar.gz", versionId: "abc"},
+		{url: "s3://bucket", errors: true},
+		{url: "s3:///docs.tar.gz", errors: true},
+		{url: "https://bucket/docs.tar.gz", errors: true},
+	}
+	for _, test := range tests {
+		bucket, key, versionId, err := parseS3Url(test.url)
+		if test.errors {
+			if err == nil {
+				t.Errorf("Expected error for %q, got nil", test.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", test.url, err)
+		}
+		if bucket != test.bucket || key != test.key || versionId != test.versionId {
+			t.Errorf("Expected %q, %q, %q for %q, got %q, %q, %q",
+				test.bucket, test.key, test.versionId, test.url, bucket, key, versionId)
+		}
+	}
+}
+
+func TestS3Object_Get(t *testing.T) {
+	setTestAwsEnv(t)
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	latest, err := ioutil.ReadFile(createTestArchive(t, tmpDir, formatGzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := ioutil.ReadFile(createTestArchive(t, tmpDir, formatZip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath, priv := createTestKey(t, tmpDir)
+	ts := httptest.NewServer(&testS3Server{
+		objects: map[string][]byte{
+			"/releases/docs.tar.gz":            latest,
+			"/releases/docs.tar.gz?v1":         old,
+			"/releases/docs.tar.gz?v2":         latest,
+			"/releases/docs.tar.gz.sig":        ed25519.Sign(priv, latest),
+			"/releases/unversioned.tar.gz":     latest,
+			"/releases/unversioned.tar.gz.sig": ed25519.Sign(priv, old),
+		},
+		versions: map[string]string{"/releases/docs.tar.gz": "v2"},
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		key     string // signature key
+		version string
+		maxSize int64
+		errors  bool
+	}{
+		{name: "latest", path: "s3://releases/docs.tar.gz", version: "v2"},
+		{name: "pinned version", path: "s3://releases/docs.tar.gz?versionId=v1", version: "v1"},
+		{name: "unversioned bucket", path: "s3://releases/unversioned.tar.gz", version: "abc123"},
+		{name: "signed", path: "s3://releases/docs.tar.gz", key: keyPath, version: "v2"},
+		{name: "bad signature", path: "s3://releases/unversioned.tar.gz", key: keyPath, errors: true},
+		{name: "missing object", path: "s3://releases/missing.tar.gz", errors: true},
+		{name: "over size limit", path: "s3://releases/docs.tar.gz", maxSize: 10, errors: true},
+		{name: "missing version", path: "s3://releases/docs.tar.gz?versionId=v3", errors: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workDir, err := ioutil.TempDir(tmpDir, "work-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			docSet, err := GetSet(workDir, config.VaultsmithConfig{
+				DocumentPath:         test.path,
+				S3Endpoint:           ts.URL,
+				DocumentSignatureKey: test.key,
+				ArchiveMaxSize:       test.maxSize,
+			})
+			if err != nil {
+				t.Fatalf("Error calling GetSet: %s", err)
+			}
+			s, ok := docSet.(*S3Object)
+			if !ok {
+				t.Fatalf("Expected *S3Object, got %T", docSet)
+			}
+			err = s.Get(context.Background())
+			defer s.CleanUp()
+			if test.errors {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error calling Get: %s", err)
+			}
+			if s.Version() != test.version {
+				t.Errorf("Expected version %q, got %q", test.version, s.Version())
+			}
+			if s.BytesDownloaded() == 0 {
+				t.Error("Expected bytes downloaded to be counted")
+			}
+			path, err := s.Path()
+			if err != nil {
+				t.Fatalf("Error calling Path: %s", err)
+			}
+			if _, err := os.Stat(filepath.Join(path, "sys", "policy", "read.json")); err != nil {
+				t.Errorf("Expected extracted file: %s", err)
+			}
+		})
+	}
+}
@@ -1,18 +1,30 @@
 package document
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/config"
 	"net/url"
 	"os"
+	"strings"
 )
 
 // Retrieve the configuration files that we want to apply to Vault
 type Set interface {
-	Path() (string, error) // the path to the configuration documents. Should return nil if not present.
-	Get() error            // fetch the configuration documents
-	CleanUp()              // remove all temporary files
+	Path() (string, error)         // the path to the configuration documents. Should return nil if not present.
+	Get(ctx context.Context) error // fetch the configuration documents
+	CleanUp()                      // remove all temporary files
+}
+
+// Implemented by a Set which can identify the version of the documents it fetched, e.g. a commit
+type Versioned interface {
+	Version() string // only valid after Get
+}
+
+// Implemented by a Set which downloads its documents
+type Downloaded interface {
+	BytesDownloaded() int64 // only valid after Get
 }
 
 // Return the appropriate document.Set for the given path
@@ -22,16 +34,68 @@ func GetSet(workDir string, config config.VaultsmithConfig) (docSet Set, err err
 		log.Error(err)
 	}
 
+	integrity, err := archiveIntegrity(config)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := EncryptionSecret(config)
+	if err != nil {
+		return nil, err
+	}
+
 	switch u.Scheme {
+	case "git+http", "git+https", "git+ssh", "git+file":
+		if integrity.enabled() {
+			return nil, fmt.Errorf("checksums and signatures are only supported for archives")
+		}
+		return NewGitRepository(workDir, config.DocumentPath)
 	case "http", "https":
-		return &HttpTarball{
+		opts, err := httpOptions(config)
+		if err != nil {
+			return nil, err
+		}
+		h := &HttpTarball{
 			LocalTarball: LocalTarball{
-				TarDir:  config.TarDir,
-				WorkDir: workDir,
+				TarDir:    config.TarDir,
+				WorkDir:   workDir,
+				Limits:    archiveLimits(config),
+				Integrity: integrity,
+				Secret:    secret,
 			},
-			Url:       u,
-			AuthToken: config.HttpAuthToken,
-		}, nil
+			HttpOptions: opts,
+			Url:         u,
+			CacheDir:    config.CacheDir,
+			OfflineOk:   config.OfflineOk,
+		}
+		if h.OfflineOk && h.CacheDir == "" {
+			return nil, fmt.Errorf("falling back to a cached archive when offline requires a cache directory")
+		}
+		if integrity.PublicKey != nil {
+			h.SignatureUrl, err = signatureUrl(u, config.DocumentSignature)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return h, nil
+	case "s3":
+		s, err := NewS3Object(workDir, config.DocumentPath, config.S3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		s.TarDir = config.TarDir
+		s.Limits = archiveLimits(config)
+		s.Integrity = integrity
+		s.Secret = secret
+		if integrity.PublicKey != nil {
+			// the signature is a different object, so the archive version does not apply
+			archiveUrl := *u
+			archiveUrl.RawQuery = ""
+			s.SignatureUrl, err = signatureUrl(&archiveUrl, config.DocumentSignature)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
 	case "", "file":
 		// local filesystem, handled below
 	default:
@@ -47,6 +111,9 @@ func GetSet(workDir string, config config.VaultsmithConfig) (docSet Set, err err
 	switch mode := p.Mode(); {
 	case mode.IsDir():
 		// Should be an directory of files
+		if integrity.enabled() {
+			return nil, fmt.Errorf("checksums and signatures are only supported for archives")
+		}
 		return &LocalFiles{
 			WorkDir:   workDir,
 			Directory: config.DocumentPath,
@@ -57,8 +124,75 @@ func GetSet(workDir string, config config.VaultsmithConfig) (docSet Set, err err
 			WorkDir:     workDir,
 			ArchivePath: config.DocumentPath,
 			TarDir:      config.TarDir,
+			Limits:      archiveLimits(config),
+			Integrity:   integrity,
+			Secret:      secret,
 		}, nil
 	default:
 		return nil, fmt.Errorf("don't know what to do with mode %s", mode)
 	}
 }
+
+func archiveLimits(config config.VaultsmithConfig) ArchiveLimits {
+	return ArchiveLimits{MaxBytes: config.ArchiveMaxSize, MaxFiles: config.ArchiveMaxFiles}
+}
+
+func archiveIntegrity(config config.VaultsmithConfig) (integrity Integrity, err error) {
+	integrity.Sha256 = config.DocumentSha256
+	if config.DocumentSignatureKey != "" {
+		integrity.PublicKey, err = LoadPublicKey(config.DocumentSignatureKey)
+		if err != nil {
+			return integrity, err
+		}
+		integrity.Signature = config.DocumentSignature
+	} else if config.DocumentSignature != "" {
+		return integrity, fmt.Errorf("a signature requires a public key to verify it")
+	}
+	return integrity, nil
+}
+
+// Determine the url of the signature for an archive downloaded from archiveUrl. The signature
+// may be given as a url (http, https or s3) or local path, or defaults to the archive url with ".sig" appended.
+// Returns nil if the signature is a local path.
+func signatureUrl(archiveUrl *url.URL, signature string) (*url.URL, error) {
+	if signature == "" {
+		u := *archiveUrl
+		u.Path = u.Path + ".sig"
+		u.RawPath = ""
+		return &u, nil
+	}
+	u, err := url.Parse(signature)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signature location %q: %s", signature, err)
+	}
+	if u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "s3" {
+		return u, nil
+	}
+	return nil, nil
+}
+
+func httpOptions(config config.VaultsmithConfig) (HttpOptions, error) {
+	opts := HttpOptions{
+		AuthToken:  config.HttpAuthToken,
+		AuthScheme: config.HttpAuthScheme,
+		Headers:    map[string]string{},
+		CACert:     config.HttpCACert,
+		ClientCert: config.HttpClientCert,
+		ClientKey:  config.HttpClientKey,
+		Proxy:      config.HttpProxy,
+		Timeout:    config.HttpTimeout,
+		Retries:    config.HttpRetries,
+		MaxBytes:   config.ArchiveMaxSize,
+	}
+	for _, h := range config.HttpHeaders {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return opts, fmt.Errorf("header %q should be of the form \"Name: value\"", h)
+		}
+		opts.Headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if config.HttpClientCert != "" && config.HttpClientKey == "" {
+		return opts, fmt.Errorf("a client certificate requires a client key")
+	}
+	return opts, nil
+}
@@ -24,7 +24,8 @@ type Renderer interface {
 type Template struct {
 	FileName     string
 	Content      string
-	Params       TemplateParams // List of "instances" of the document, mapping the key-values for each one
+	Params       TemplateParams          // List of "instances" of the document, mapping the key-values for each one
+	Functions    map[string]TemplateFunc // Functions callable from the template. Defaults to DefaultFunctions()
 	placeHolders map[string]string
 }
 
@@ -95,7 +96,14 @@ func (t *Template) createRenderedTemplate(name string, params TemplateParams) (r
 		return rt, fmt.Errorf("error finding placeholders: %s", err)
 	}
 
-	content := t.Content
+	functions := t.Functions
+	if functions == nil {
+		functions = DefaultFunctions()
+	}
+	content, err := renderFunctions(t.Content, functions)
+	if err != nil {
+		return rt, fmt.Errorf("error rendering functions in %s: %s", t.FileName, err)
+	}
 	for pk, placeholderText := range placeHolders {
 		if value, ok := params.Variables[pk]; ok {
 			content = strings.Replace(content, placeholderText, value, -1)
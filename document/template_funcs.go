@@ -0,0 +1,93 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/starlingbank/vaultsmith/mask"
+)
+
+// Regexp which defines how to find function calls, e.g. {{ env "AWS_SECRET_ACCESS_KEY" }}
+var funcMatcher = regexp.MustCompile(`{{\s*([a-zA-Z_]+)\s+"([^"]*)"\s*}}`)
+
+// A TemplateFunc resolves the argument of a function call in a template to a value. Values are
+// resolved at render time, so secrets don't need to be committed to the document repository.
+type TemplateFunc func(arg string) (string, error)
+
+// The name of the function which resolves the path of an auth method to its mount accessor, e.g.
+// {{ accessor "kubernetes/" }}. It needs a Vault client, so is not one of DefaultFunctions.
+const AccessorFunction = "accessor"
+
+// Functions whose values are not secret, so are not masked
+var plainFunctions = map[string]bool{AccessorFunction: true}
+
+// The functions available to every template
+func DefaultFunctions() map[string]TemplateFunc {
+	return map[string]TemplateFunc{
+		"env":  envFunc,
+		"file": fileFunc,
+	}
+}
+
+// Read a value from the environment. An unset variable is an error, rather than silently
+// writing an empty value to Vault.
+func envFunc(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q is not set", name)
+	}
+	return value, nil
+}
+
+// Read a value from a file, e.g. a docker or kubernetes secret. Trailing newlines are removed.
+func fileFunc(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read %q: %s", path, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Replace all function calls in text with their resolved values. Resolved values, other than those
+// of plainFunctions, are registered with the mask package so they never appear in logs.
+func renderFunctions(text string, functions map[string]TemplateFunc) (string, error) {
+	var renderErr error
+	rendered := funcMatcher.ReplaceAllStringFunc(text, func(call string) string {
+		if renderErr != nil {
+			return call
+		}
+		m := funcMatcher.FindStringSubmatch(call)
+		name, arg := m[1], m[2]
+		f, ok := functions[name]
+		if !ok {
+			renderErr = fmt.Errorf("unknown template function %q", name)
+			return call
+		}
+		value, err := f(arg)
+		if err != nil {
+			renderErr = fmt.Errorf("%s %q: %s", name, arg, err)
+			return call
+		}
+		escaped := escape(value)
+		if !plainFunctions[name] {
+			mask.Register(value)
+			mask.Register(escaped)
+		}
+		return escaped
+	})
+	return rendered, renderErr
+}
+
+// Escape a value so it can be placed within a quoted json string
+func escape(value string) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	s := string(b)
+	return s[1 : len(s)-1]
+}
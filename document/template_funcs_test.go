@@ -0,0 +1,105 @@
+package document
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/mask"
+)
+
+func TestRenderFunctions_env(t *testing.T) {
+	defer mask.Reset()
+	os.Setenv("VAULTSMITH_TEST_SECRET", "s3cr3t")
+	defer os.Unsetenv("VAULTSMITH_TEST_SECRET")
+
+	r, err := renderFunctions(`{"secret_key": "{{ env "VAULTSMITH_TEST_SECRET" }}"}`, DefaultFunctions())
+	if err != nil {
+		t.Fatalf("Error rendering functions: %s", err)
+	}
+	exp := `{"secret_key": "s3cr3t"}`
+	if r != exp {
+		t.Errorf("Expected %q, got %q", exp, r)
+	}
+	if masked := mask.String(r); strings.Contains(masked, "s3cr3t") {
+		t.Errorf("Expected resolved value to be masked, got %q", masked)
+	}
+}
+
+func TestRenderFunctions_envUnset(t *testing.T) {
+	os.Unsetenv("VAULTSMITH_TEST_UNSET")
+	_, err := renderFunctions(`{{ env "VAULTSMITH_TEST_UNSET" }}`, DefaultFunctions())
+	if err == nil {
+		t.Error("Expected error for unset environment variable, got nil")
+	}
+}
+
+func TestRenderFunctions_file(t *testing.T) {
+	defer mask.Reset()
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	secretFile := filepath.Join(tmpDir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("pass\"word\n"), 0600); err != nil {
+		t.Fatalf("Could not write file: %s", err)
+	}
+
+	r, err := renderFunctions(`"{{ file "`+secretFile+`" }}"`, DefaultFunctions())
+	if err != nil {
+		t.Fatalf("Error rendering functions: %s", err)
+	}
+	// quotes are escaped so the document remains valid json
+	exp := `"pass\"word"`
+	if r != exp {
+		t.Errorf("Expected %q, got %q", exp, r)
+	}
+}
+
+func TestRenderFunctions_accessor(t *testing.T) {
+	defer mask.Reset()
+	functions := map[string]TemplateFunc{
+		AccessorFunction: func(path string) (string, error) { return "auth_kubernetes_1234", nil },
+	}
+	r, err := renderFunctions(`{{identity.entity.aliases.{{ accessor "kubernetes/" }}.name}}`, functions)
+	if err != nil {
+		t.Fatalf("Error rendering functions: %s", err)
+	}
+	exp := `{{identity.entity.aliases.auth_kubernetes_1234.name}}`
+	if r != exp {
+		t.Errorf("Expected %q, got %q", exp, r)
+	}
+	if masked := mask.String(r); masked != exp {
+		t.Errorf("Expected accessors not to be masked, got %q", masked)
+	}
+}
+
+func TestRenderFunctions_unknown(t *testing.T) {
+	_, err := renderFunctions(`{{ nope "foo" }}`, DefaultFunctions())
+	if err == nil {
+		t.Error("Expected error for unknown function, got nil")
+	}
+}
+
+func TestTemplatedDocument_Render_Functions(t *testing.T) {
+	tf := Template{
+		Params: TemplateParams{
+			Variables: map[string]string{"foo": "A"},
+		},
+		Functions: map[string]TemplateFunc{
+			"upper": func(arg string) (string, error) { return strings.ToUpper(arg), nil },
+		},
+		Content: `foo is {{ foo }}, bar is {{ upper "bar" }}`,
+	}
+	renderedTemplates, err := tf.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "foo is A, bar is BAR"
+	if renderedTemplates[0].Content != exp {
+		t.Errorf("Expected %q, got %q", exp, renderedTemplates[0].Content)
+	}
+}
@@ -1,17 +1,17 @@
 package document
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"strings"
 )
 
 // Set of parameters to apply to our Template document
-// Defines the structure of the _vaultsmith.json file
+// Defines the structure of the _vaultsmith.json (or _vaultsmith.yaml) file
 type TemplateParams struct {
 	Instances map[string][]string `json:"instances"`
 	Variables map[string]string   `json:"variables"`
+	WriteOnly map[string][]string `json:"write_only"` // fields never returned by Vault, keyed by path or mount
 }
 
 // Build template configurations from a template file and slice of overrides, for passing to Template
@@ -24,7 +24,7 @@ func GenerateTemplateParams(templateFile string, overrides []string) (tp Templat
 			return tp, fmt.Errorf("could not read template file %s: %s", templateFile, err)
 		}
 
-		if err := json.Unmarshal(file, &templateConfig); err != nil {
+		if err := Unmarshal(templateFile, file, &templateConfig); err != nil {
 			return tp, fmt.Errorf("could not unmarshall %s: %s", templateFile, err)
 		}
 	} else {
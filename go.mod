@@ -1,9 +1,11 @@
 module github.com/starlingbank/vaultsmith
 
+go 1.16
+
 require (
 	github.com/SermoDigital/jose v0.9.1 // indirect
 	github.com/armon/go-radix v0.0.0-20170727155443-1fca145dffbc // indirect
-	github.com/aws/aws-sdk-go v1.15.1 // indirect
+	github.com/aws/aws-sdk-go v1.15.1
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/fullsailor/pkcs7 v0.0.0-20180613152042-8306686428a5 // indirect
 	github.com/golang/protobuf v1.1.0 // indirect
@@ -30,15 +32,18 @@ require (
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/ryanuber/go-glob v0.0.0-20170128012129-256dc444b735 // indirect
+	github.com/santhosh-tekuri/jsonschema/v5 v5.2.0
 	github.com/sirupsen/logrus v1.0.6
 	github.com/spf13/pflag v1.0.1
 	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/stretchr/testify v1.2.2
-	golang.org/x/crypto v0.0.0-20180723164146-c126467f60eb // indirect
+	github.com/ulikunitz/xz v0.5.10
+	golang.org/x/crypto v0.0.0-20180723164146-c126467f60eb
 	golang.org/x/net v0.0.0-20180730214132-a0f8a16cb08c // indirect
 	golang.org/x/sys v0.0.0-20180727230415-bd9dbc187b6e // indirect
 	golang.org/x/text v0.3.0 // indirect
 	golang.org/x/time v0.0.0-20180412165947-fbb02b2291d2 // indirect
 	google.golang.org/genproto v0.0.0-20180731163654-ca9291b70484 // indirect
 	google.golang.org/grpc v1.14.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+// Prefix of the name of each backup, which is followed by the time it was taken
+const backupPrefix = "vaultsmith-backup-"
+
+// Write the current contents of every path the documents manage to a new archive in dir, laid out
+// as a document tree so it can be applied to restore them: auth methods to sys/auth and policies
+// to sys/policy, if the document tree has those directories, and each document written by the
+// generic handler, or which it would remove, to its path. docs are the rendered documents, as
+// returned by Validate. If secret is given, the archive is encrypted with it, see
+// document.Encrypt. Returns the path of the archive.
+func Backup(ctx context.Context, client vault.Vault, config config.VaultsmithConfig, docPath string, registry *path_handlers.Registry, docs []path_handlers.RenderedDocument, dir string, secret []byte) (string, error) {
+	files := map[string]interface{}{}
+	if _, err := os.Stat(filepath.Join(docPath, "sys", "auth")); err == nil {
+		if err := backupAuth(ctx, client, files); err != nil {
+			return "", err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(docPath, "sys", "policy")); err == nil {
+		if err := backupPolicies(ctx, client, files); err != nil {
+			return "", err
+		}
+	}
+	paths, err := genericPaths(ctx, client, config, docPath, registry, docs)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		doc, err := client.Read(ctx, p)
+		if err != nil && strings.Contains(err.Error(), "Code: 403") {
+			log.WithFields(log.Fields{"path": p}).Warn("Not backing up path, permission denied")
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("could not read %s: %s", p, err)
+		}
+		if doc != nil && doc.Data != nil {
+			files[p+".json"] = doc.Data
+		}
+	}
+
+	name := backupPrefix + time.Now().UTC().Format("20060102T150405Z")
+	archive, err := tarball(name, files)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, name+".tar.gz")
+	if secret != nil {
+		if archive, err = document.Encrypt(archive, secret); err != nil {
+			return "", fmt.Errorf("could not encrypt backup: %s", err)
+		}
+		file += ".enc"
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("could not create backup directory: %s", err)
+	}
+	// the backup contains secrets, so is only readable by the owner
+	if err := ioutil.WriteFile(file, archive, 0600); err != nil {
+		return "", fmt.Errorf("could not write backup: %s", err)
+	}
+	return file, nil
+}
+
+// Add each auth method but token, which is always enabled, as sys/auth/<path>.json
+func backupAuth(ctx context.Context, client vault.Vault, files map[string]interface{}) error {
+	mounts, err := client.ListAuth(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list auth methods: %s", err)
+	}
+	for p, mount := range mounts {
+		p = strings.TrimSuffix(p, "/")
+		if p == "token" || mount == nil {
+			continue
+		}
+		files["sys/auth/"+p+".json"] = vault.AuthOptions(mount)
+	}
+	return nil
+}
+
+// Add each policy but root, which can not be written, as sys/policy/<name>.json
+func backupPolicies(ctx context.Context, client vault.Vault, files map[string]interface{}) error {
+	names, err := client.ListPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list policies: %s", err)
+	}
+	for _, name := range names {
+		if name == "root" {
+			continue
+		}
+		policy, err := client.GetPolicy(ctx, name)
+		if err != nil {
+			return fmt.Errorf("could not read policy %s: %s", name, err)
+		}
+		files["sys/policy/"+name+".json"] = map[string]interface{}{"policy": policy}
+	}
+	return nil
+}
+
+// Return the API paths the generic handler may write or remove, sorted: those of the rendered
+// documents outside sys/auth, sys/policy and identity, and those listed in each directory it applies
+func genericPaths(ctx context.Context, client vault.Vault, config config.VaultsmithConfig, docPath string, registry *path_handlers.Registry, docs []path_handlers.RenderedDocument) ([]string, error) {
+	paths := map[string]bool{}
+	identity := false
+	for _, doc := range docs {
+		switch {
+		case strings.HasPrefix(doc.Path, "identity/"):
+			// Vault returns members by id, which the Identity handler can't apply
+			identity = true
+		case !strings.HasPrefix(doc.Path, "sys/auth/") && !strings.HasPrefix(doc.Path, "sys/policy/"):
+			paths[doc.Path] = true
+		}
+	}
+	if identity {
+		log.Warn("Identity entities and groups are not backed up")
+	}
+
+	var dirs []string
+	err := walkOffline(ctx, config, docPath, registry,
+		func(ctx context.Context, reg path_handlers.Registration, handlerConfig path_handlers.PathHandlerConfig, dir string) {
+			if reg.Path != path_handlers.GenericPath {
+				return
+			}
+			_ = filepath.Walk(dir, func(p string, f os.FileInfo, err error) error {
+				if err == nil && f.IsDir() {
+					dirs = append(dirs, p)
+				}
+				return nil
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(docPath, dir)
+		if err != nil {
+			return nil, err
+		}
+		apiPath := filepath.ToSlash(rel)
+		keys, err := listKeys(ctx, client, apiPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			// sub-directories are listed by their own walk, if they are in the document tree
+			if !strings.HasSuffix(key, "/") {
+				paths[path.Join(apiPath, key)] = true
+			}
+		}
+	}
+
+	var sorted []string
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// Return the keys listed at an API path, or none if there is nothing there or it can't be listed
+func listKeys(ctx context.Context, client vault.Vault, apiPath string) ([]string, error) {
+	secret, err := client.List(ctx, apiPath)
+	if err != nil && strings.Contains(err.Error(), "Code: 403") {
+		log.WithFields(log.Fields{"path": apiPath}).Warn("Not backing up path, permission denied")
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not list %s: %s", apiPath, err)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+	values, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("could not list %s: secret data did not contain a list of keys", apiPath)
+	}
+	var keys []string
+	for _, v := range values {
+		if key, ok := v.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Return a gzipped tar archive of files, as JSON, within a single directory named name
+func tarball(name string, files map[string]interface{}) ([]byte, error) {
+	var names []string
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+	for _, n := range names {
+		data, err := json.MarshalIndent(files[n], "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("could not encode %s: %s", n, err)
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     name + "/" + n,
+			Mode:     0600,
+			Size:     int64(len(data)),
+			ModTime:  now,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+// serves fixed auth methods, policies and documents
+type backupClient struct {
+	vault.MockClient
+	mounts   map[string]*vaultApi.AuthMount
+	policies map[string]string
+	docs     map[string]map[string]interface{}
+}
+
+func (c *backupClient) ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error) {
+	return c.mounts, nil
+}
+
+func (c *backupClient) ListPolicies(ctx context.Context) (names []string, err error) {
+	for name := range c.policies {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (c *backupClient) GetPolicy(ctx context.Context, name string) (string, error) {
+	return c.policies[name], nil
+}
+
+func (c *backupClient) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if path == "secret/forbidden" {
+		return nil, fmt.Errorf("Error making API request.\n\nCode: 403. Errors:\n\n* permission denied")
+	}
+	if data, ok := c.docs[path]; ok {
+		return &vaultApi.Secret{Data: data}, nil
+	}
+	return nil, nil
+}
+
+func (c *backupClient) List(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	var keys []interface{}
+	for p := range c.docs {
+		if strings.HasPrefix(p, path+"/") {
+			keys = append(keys, strings.TrimPrefix(p, path+"/"))
+		}
+	}
+	if keys == nil {
+		return nil, nil
+	}
+	return &vaultApi.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+func TestBackup(t *testing.T) {
+	docPath := t.TempDir()
+	files := map[string]string{
+		"sys/auth/approle.json":         `{"type": "approle"}`,
+		"sys/policy/reader.json":        `{"policy": "path \"secret/*\" {}"}`,
+		"auth/approle/role/app.json":    `{"policies": "reader"}`,
+		"secret/declared.json":          `{"value": "new"}`,
+		"secret/forbidden.json":         `{"value": "new"}`,
+		"auth/approle/role/absent.json": `{"policies": "reader"}`,
+	}
+	for name, content := range files {
+		p := filepath.Join(docPath, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &backupClient{
+		mounts: map[string]*vaultApi.AuthMount{
+			"approle/": {Type: "approle", Description: "apps"},
+			"token/":   {Type: "token"},
+		},
+		policies: map[string]string{"reader": "path {}", "old": "path {}", "root": ""},
+		docs: map[string]map[string]interface{}{
+			"auth/approle/role/app": {"policies": []interface{}{"old"}},
+			"secret/declared":       {"value": "old"},
+			"secret/undeclared":     {"value": "removed by the run"},
+			"elsewhere/unmanaged":   {"value": "not backed up"},
+		},
+	}
+	registry := path_handlers.DefaultRegistry()
+	conf := config.VaultsmithConfig{}
+	docs, problems, err := Validate(context.Background(), conf, docPath, registry, nil)
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("Expected valid documents, got %v %v", problems, err)
+	}
+
+	backupDir := filepath.Join(t.TempDir(), "backups")
+	file, err := Backup(context.Background(), client, conf, docPath, registry, docs, backupDir, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Error calling Backup: %s", err)
+	}
+	if !strings.HasPrefix(filepath.Base(file), backupPrefix) || !strings.HasSuffix(file, ".tar.gz.enc") {
+		t.Errorf("Unexpected backup file name %s", file)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the backup to be readable only by the owner, got %v %v", info, err)
+	}
+
+	// the backup is restored by applying it as a document tree
+	archive := document.LocalTarball{WorkDir: t.TempDir(), ArchivePath: file, Secret: []byte("passphrase")}
+	if err := archive.Get(context.Background()); err != nil {
+		t.Fatalf("Error extracting backup: %s", err)
+	}
+	restorePath, err := archive.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, problems, err := Render(context.Background(), config.VaultsmithConfig{}, restorePath, registry)
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("Expected the backup to render, got %v %v", problems, err)
+	}
+	got := map[string]interface{}{}
+	for _, doc := range rendered {
+		got[doc.Path] = doc.Data
+	}
+	exp := map[string]interface{}{
+		"sys/auth/approle":      vault.AuthOptions(client.mounts["approle/"]),
+		"sys/policy/old":        map[string]interface{}{"policy": "path {}"},
+		"sys/policy/reader":     map[string]interface{}{"policy": "path {}"},
+		"auth/approle/role/app": map[string]interface{}{"policies": []interface{}{"old"}},
+		"secret/declared":       map[string]interface{}{"value": "old"},
+		"secret/undeclared":     map[string]interface{}{"value": "removed by the run"},
+	}
+	if len(got) != len(exp) {
+		t.Errorf("Expected documents %v, got %v", exp, got)
+	}
+	for p, data := range exp {
+		if opts, ok := got[p].(vaultApi.EnableAuthOptions); ok {
+			got[p] = &opts
+		}
+		if !reflect.DeepEqual(got[p], data) {
+			t.Errorf("Expected %s to be %#v, got %#v", p, data, got[p])
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
 	"github.com/starlingbank/vaultsmith/path_handlers"
 	"github.com/starlingbank/vaultsmith/vault"
 	"os"
@@ -23,159 +25,234 @@ type ConfigWalker struct {
 	HandlerMap map[string]path_handlers.PathHandler
 	Client     vault.Vault
 	ConfigDir  string
-	Visited    map[string]bool
+	// paths, or patterns, of the document tree mapped to the paths they must be applied after, from
+	// registrations and the _depends_on file
+	DependsOn map[string][]string
+	// maximum number of directories to apply at once. Zero is treated as one
+	Parallelism int
+	newGeneric  func() (path_handlers.PathHandler, error) // if nil, HandlerMap["*"] is shared
 }
 
-// Instantiates a configWalker and the required handlers
-// TODO this mixes configuration and code, could be declared in a better way
-func NewConfigWalker(client vault.Vault, config config.VaultsmithConfig, docPath string) (configWalker ConfigWalker, err error) {
+// Instantiates a configWalker and the handlers declared in the registry. If registry is nil, the
+// default registry is used.
+func NewConfigWalker(ctx context.Context, client vault.Vault, config config.VaultsmithConfig, docPath string, registry *path_handlers.Registry) (configWalker ConfigWalker, err error) {
+	return newConfigWalker(ctx, client, config, docPath, registry,
+		func(reg path_handlers.Registration, handlerConfig path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+			return reg.Factory(ctx, client, handlerConfig)
+		})
+}
+
+// Instantiates a configWalker, creating the handler for each registration with create. If client
+// is nil, templates are rendered with placeholders for values which would be read from Vault.
+func newConfigWalker(ctx context.Context, client vault.Vault, config config.VaultsmithConfig, docPath string, registry *path_handlers.Registry,
+	create func(path_handlers.Registration, path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error)) (configWalker ConfigWalker, err error) {
+	if registry == nil {
+		registry = path_handlers.DefaultRegistry()
+	}
+
 	// Map configuration directories to specific path handlers
 	var handlerMap = map[string]path_handlers.PathHandler{}
+	var newGeneric func() (path_handlers.PathHandler, error)
+
+	dependsOn, err := readDependsOn(docPath)
+	if err != nil {
+		return configWalker, err
+	}
 
-	// Instantiate our path handlers
-	// We handle any unknown directories with this one
-	genericHandler, err := path_handlers.NewGeneric(
-		client,
-		path_handlers.PathHandlerConfig{
+	dry := client == nil || config.Dry
+	for _, reg := range registry.Registrations() {
+		handlerConfig := path_handlers.PathHandlerConfig{
 			DocumentPath:      docPath,
 			TemplateFile:      config.TemplateFile,
 			TemplateOverrides: config.TemplateParams,
-		})
-	if err != nil {
-		return configWalker, fmt.Errorf("could not create genericHandler: %s", err)
-	}
-	handlerMap["*"] = genericHandler
+			ForceWriteOnly:    config.ForceWriteOnly,
+			Functions: map[string]document.TemplateFunc{
+				document.AccessorFunction: path_handlers.AccessorFunc(ctx, client, docPath, dry),
+			},
+			Dry: dry,
+		}
+		if len(reg.DependsOn) > 0 {
+			dependsOn[reg.Path] = append(dependsOn[reg.Path], reg.DependsOn...)
+		}
 
-	// sys directories should never be generic, so apply a dummy at the top level
-	nullHandler, err := path_handlers.NewDummyHandler(client, "", 0)
-	if err != nil {
-		return configWalker, fmt.Errorf("error instantiating null handler: %s", err)
-	}
-	handlerMap["sys"] = nullHandler
-
-	// The two sys path handlers
-	sysAuthDir := filepath.Join(docPath, "sys", "auth")
-	if f, err := os.Stat(sysAuthDir); !os.IsNotExist(err) {
-		if f.Mode().IsDir() {
-			sysAuthHandler, err := path_handlers.NewSysAuthHandler(
-				client,
-				path_handlers.PathHandlerConfig{
-					DocumentPath:      docPath,
-					Order:             10,
-					TemplateFile:      config.TemplateFile,
-					TemplateOverrides: config.TemplateParams,
-				})
+		if reg.Path == path_handlers.GenericPath {
+			// We handle any unknown directories with this one
+			genericHandler, err := create(reg, handlerConfig)
 			if err != nil {
-				return configWalker, fmt.Errorf("could not create sysAuthHandler: %s", err)
+				return configWalker, fmt.Errorf("could not create generic handler: %s", err)
 			}
-			handlerMap["sys/auth"] = sysAuthHandler
+			handlerMap[reg.Path] = genericHandler
+			// directories may be applied in parallel, so each has its own generic handler
+			reg := reg
+			newGeneric = func() (path_handlers.PathHandler, error) {
+				return create(reg, handlerConfig)
+			}
+			continue
 		}
-	}
 
-	sysPolicyDir := filepath.Join(docPath, "sys", "policy")
-	if f, err := os.Stat(sysPolicyDir); !os.IsNotExist(err) {
-		if f.Mode().IsDir() {
-			sysPolicyHandler, err := path_handlers.NewSysPolicyHandler(
-				client,
-				path_handlers.PathHandlerConfig{
-					DocumentPath:      docPath,
-					Order:             20,
-					TemplateFile:      config.TemplateFile,
-					TemplateOverrides: config.TemplateParams,
-				})
+		paths, err := registeredPaths(docPath, reg)
+		if err != nil {
+			return configWalker, err
+		}
+		for _, p := range paths {
+			if reg.Skip {
+				// Dummy handler is a way of marking as "do not process"
+				nullHandler, err := path_handlers.NewDummyHandler(client, "")
+				if err != nil {
+					return configWalker, fmt.Errorf("error instantiating null handler: %s", err)
+				}
+				handlerMap[p] = nullHandler
+				continue
+			}
+			handler, err := create(reg, handlerConfig)
 			if err != nil {
-				return configWalker, fmt.Errorf("could not create sysPolicyHandler: %s", err)
+				return configWalker, fmt.Errorf("could not create handler for %s: %s", p, err)
 			}
-			handlerMap["sys/policy"] = sysPolicyHandler
+			handlerMap[p] = handler
 		}
 	}
 
 	return ConfigWalker{
-		HandlerMap: handlerMap,
-		Client:     client,
-		ConfigDir:  path.Clean(docPath),
-		Visited:    map[string]bool{},
+		HandlerMap:  handlerMap,
+		Client:      client,
+		ConfigDir:   path.Clean(docPath),
+		DependsOn:   dependsOn,
+		Parallelism: config.Parallelism,
+		newGeneric:  newGeneric,
 	}, nil
 }
 
-func (cw ConfigWalker) Run() error {
+// Return the relative paths of the document tree a registration applies to. Handlers are only
+// created for directories which exist, but skipped paths are always marked.
+func registeredPaths(docPath string, reg path_handlers.Registration) (paths []string, err error) {
+	matches, err := filepath.Glob(filepath.Join(docPath, filepath.FromSlash(reg.Path)))
+	if err != nil {
+		return nil, fmt.Errorf("bad handler path %q: %s", reg.Path, err)
+	}
+	for _, m := range matches {
+		f, err := os.Stat(m)
+		if err != nil || !f.Mode().IsDir() {
+			continue
+		}
+		relPath, err := filepath.Rel(docPath, m)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, relPath)
+	}
+	if len(paths) == 0 && reg.Skip && !strings.ContainsAny(reg.Path, "*?[") {
+		paths = append(paths, filepath.FromSlash(reg.Path))
+	}
+	return paths, nil
+}
+
+// Apply the document tree. The directory each handler applies to is found first, then they are
+// applied in order of their dependencies, see dependencyGraph. Directories which do not depend on
+// each other are applied in parallel, up to cw.Parallelism at once.
+func (cw ConfigWalker) Run(ctx context.Context) error {
 	// file will be a dir here unless a trailing slash was added
 	log.Debugf("Starting in directory %s", cw.ConfigDir)
 
-	err := cw.walkConfigDir(cw.ConfigDir, cw.HandlerMap)
+	err := filepath.Walk(cw.ConfigDir, func(p string, f os.FileInfo, err error) error {
+		return cw.walkFile(ctx, p, f, err)
+	})
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-// Return a sorted slice of paths based on the Order() of its handler
-func (cw ConfigWalker) sortedPaths() (paths []string) {
-	for p := range cw.HandlerMap {
-		paths = append(paths, p)
+	graph, err := cw.dependencyGraph()
+	if err != nil {
+		return err
 	}
+	return cw.applyGraph(ctx, graph)
+}
 
-	sort.Slice(paths, func(i, j int) bool {
-		h1 := cw.HandlerMap[paths[i]]
-		h2 := cw.HandlerMap[paths[j]]
-		// zero (default) values always last
-		if h1.Order() == 0 {
-			return false
+// Apply each directory of the graph once those it depends on have been applied. After an error,
+// no more directories are started, and the first error is returned once those running finish.
+func (cw ConfigWalker) applyGraph(ctx context.Context, graph map[string][]string) error {
+	parallelism := cw.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	waiting := map[string]int{}
+	dependents := map[string][]string{}
+	var ready []string
+	for p, deps := range graph {
+		waiting[p] = len(deps)
+		for _, d := range deps {
+			dependents[d] = append(dependents[d], p)
 		}
-		if h2.Order() == 0 {
-			return true
+		if len(deps) == 0 {
+			ready = append(ready, p)
 		}
-		return h1.Order() < h2.Order()
-	})
-
-	return paths
-}
+	}
 
-func (cw ConfigWalker) walkConfigDir(path string, handlerMap map[string]path_handlers.PathHandler) error {
-	// Process according to <handler>.Order()
-	paths := cw.sortedPaths()
-	for _, v := range paths {
-		if v == "*" {
-			// not a real path, just used to store our generic handler
+	type result struct {
+		path string
+		err  error
+	}
+	results := make(chan result)
+	running := 0
+	var firstErr error
+	for {
+		// start in path order, so a run without parallelism is predictable
+		sort.Strings(ready)
+		for firstErr == nil && ctx.Err() == nil && running < parallelism && len(ready) > 0 {
+			p := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- result{p, cw.applyDir(ctx, p)}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
 			continue
 		}
-		logger := log.WithFields(log.Fields{
-			"path": v,
-		})
-
-		handler := cw.HandlerMap[v]
-		p := filepath.Join(path, v)
-		if handler.Name() != "Dummy" {
-			// Dummy handler is a way of marking as "do not process"
-			logger.Infof("Processing with %s handler", handler.Name())
-			err := handler.PutPoliciesFromDir(p)
-			if err != nil {
-				return err
+		for _, d := range dependents[r.path] {
+			waiting[d]--
+			if waiting[d] == 0 {
+				ready = append(ready, d)
 			}
 		}
-		cw.Visited[p] = true
 	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
 
-	// Process other directories with the genericHandler
-	err := filepath.Walk(path, cw.walkFile)
-	return err
+// Apply a directory, given relative to the document root, with its handler
+func (cw ConfigWalker) applyDir(ctx context.Context, relPath string) error {
+	handler := cw.HandlerMap[relPath]
+	log.WithFields(log.Fields{
+		"path": relPath,
+	}).Infof("Processing with %s handler", handler.Name())
+	return handler.PutPoliciesFromDir(ctx, filepath.Join(cw.ConfigDir, relPath))
 }
 
-// determine the handler and pass the root directory to it
-func (cw ConfigWalker) walkFile(path string, f os.FileInfo, err error) error {
+// determine the handler for a directory, recording it in cw.HandlerMap to be applied by Run
+func (cw ConfigWalker) walkFile(ctx context.Context, path string, f os.FileInfo, err error) error {
 	if f == nil {
 		return fmt.Errorf("path %q does not exist", path)
 	}
-	if !f.IsDir() { // only want to operate on directories
-		return nil
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if visited, ok := cw.Visited[path]; ok && visited { // already been here
+	if !f.IsDir() { // only want to operate on directories
 		return nil
 	}
-
 	if strings.HasPrefix(f.Name(), "_") {
-		// Don't process files that start with an underscore; e.g. template json
-		return nil
+		// Don't process files that start with an underscore; e.g. template json, or directories
+		// such as _schemas
+		return filepath.SkipDir
 	}
 
 	relPath, err := filepath.Rel(cw.ConfigDir, path)
@@ -187,9 +264,6 @@ func (cw ConfigWalker) walkFile(path string, f os.FileInfo, err error) error {
 	if pathArray[0] == "." { // just to avoid a "no handler for path ." in log
 		return nil
 	}
-	logger := log.WithFields(log.Fields{
-		"path": relPath,
-	})
 
 	// Is there a handler for a higher level path? If so, we assume that it handles all child
 	// directories and thus we should not process these directories separately.
@@ -199,19 +273,22 @@ func (cw ConfigWalker) walkFile(path string, f os.FileInfo, err error) error {
 		return nil
 	}
 
-	handler, ok := cw.HandlerMap[relPath]
-	if ok {
-		logger.Infof("Processing with %T handler", handler)
-		return handler.PutPoliciesFromDir(path)
+	if _, ok := cw.HandlerMap[relPath]; ok {
+		// a registered handler, which is applied by Run
+		return nil
 	}
 
 	// At this point, we have a directory, which has no handler assigned to itself or any parent
 	// or child. Thus, safe to attach the genericHandler to it
-	logger.Infof("Processing with Generic handler")
 	genericHandler := cw.HandlerMap["*"]
+	if cw.newGeneric != nil {
+		if genericHandler, err = cw.newGeneric(); err != nil {
+			return fmt.Errorf("could not create generic handler for %s: %s", relPath, err)
+		}
+	}
 	// and mark it so recursing into child directories doesn't re-process them
 	cw.HandlerMap[relPath] = genericHandler
-	return genericHandler.PutPoliciesFromDir(path)
+	return nil
 }
 
 // Determine whether this directory is already covered by a parent handler
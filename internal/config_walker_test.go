@@ -1,11 +1,17 @@
 package internal
 
 import (
+	"context"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
 	"github.com/starlingbank/vaultsmith/path_handlers"
 	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -19,7 +25,7 @@ func TestConfigHandlerWalkFile(t *testing.T) {
 	}
 	f := &fakeFileInfo{}
 
-	e := cw.walkFile("auth", f, nil)
+	e := cw.walkFile(context.Background(), "auth", f, nil)
 	if e != nil {
 		log.Fatal(e)
 	}
@@ -109,33 +115,210 @@ func TestConfigWalker_hasChildHandler_self(t *testing.T) {
 	}
 }
 
-func TestSortedPaths(t *testing.T) {
-	fooH, err := path_handlers.NewDummyHandler(&vault.MockClient{}, "", 30)
-	if err != nil {
-		log.Fatal(err)
+func TestDependencyGraph(t *testing.T) {
+	dummy, _ := path_handlers.NewDummyHandler(&vault.MockClient{}, "")
+	cw := ConfigWalker{
+		HandlerMap: map[string]path_handlers.PathHandler{
+			"*":                   dummy,
+			"sys":                 dummy,
+			"sys/auth":            &offlineHandler{},
+			"sys/policy":          &offlineHandler{},
+			"auth/aws":            &offlineHandler{},
+			"auth/approle":        &offlineHandler{},
+			"transit":             &offlineHandler{},
+			"secret/app":          &offlineHandler{},
+			"identity/group":      &offlineHandler{},
+			"pki-int":             &offlineHandler{},
+			"pki-root":            &offlineHandler{},
+			"secret/shared/child": &offlineHandler{},
+		},
+		DependsOn: map[string][]string{
+			"secret/app":    {"auth/approle", "/transit/"},
+			"identity":      {"missing"},
+			"pki-int":       {"pki-root"},
+			"secret/shared": {"secret/shared/child/x"},
+		},
 	}
-	barH, err := path_handlers.NewDummyHandler(&vault.MockClient{}, "", 10)
+
+	graph, err := cw.dependencyGraph()
 	if err != nil {
-		log.Fatal(err)
+		t.Fatalf("Unexpected error: %s", err)
 	}
-	bozH, err := path_handlers.NewDummyHandler(&vault.MockClient{}, "", 20)
-	if err != nil {
-		log.Fatal(err)
+	expected := map[string][]string{
+		"sys/auth":            {},
+		"sys/policy":          {"sys/auth"},
+		"auth/aws":            {"sys/auth", "sys/policy"},
+		"auth/approle":        {"sys/auth", "sys/policy"},
+		"transit":             {"sys/policy"},
+		"secret/app":          {"auth/approle", "sys/policy", "transit"},
+		"identity/group":      {"sys/policy"},
+		"pki-int":             {"pki-root", "sys/policy"},
+		"pki-root":            {"sys/policy"},
+		"secret/shared/child": {"sys/policy"},
+	}
+	for p, deps := range expected {
+		if got := graph[filepath.FromSlash(p)]; !reflect.DeepEqual(got, osPaths(deps)) {
+			t.Errorf("Expected %s to depend on %v, got %v", p, deps, got)
+		}
 	}
+	if len(graph) != len(expected) {
+		t.Errorf("Expected %d directories, got %v", len(expected), graph)
+	}
+}
 
+func TestDependencyGraph_cycle(t *testing.T) {
 	cw := ConfigWalker{
 		HandlerMap: map[string]path_handlers.PathHandler{
-			"foo": fooH,
-			"bar": barH,
-			"boz": bozH,
+			"a": &offlineHandler{},
+			"b": &offlineHandler{},
+			"c": &offlineHandler{},
 		},
+		DependsOn: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
 	}
-	expected := []string{"bar", "boz", "foo"}
-	r := cw.sortedPaths()
-	if !reflect.DeepEqual(r, expected) {
-		t.Errorf("Unexpected slice result (out of order?). Expected %+v; Got: %+v", expected, r)
+	_, err := cw.dependencyGraph()
+	if err == nil || err.Error() != "dependency cycle: a -> b -> c -> a" {
+		t.Errorf("Expected a dependency cycle error, got %v", err)
 	}
+}
+
+// Records the order directories are applied in, and how many are applied at once
+type orderHandler struct {
+	mu      *sync.Mutex
+	applied *[]string
+	running *int
+	maxRun  *int
+	err     error
+}
+
+func (h orderHandler) PutPoliciesFromDir(ctx context.Context, path string) error {
+	h.mu.Lock()
+	*h.running++
+	if *h.running > *h.maxRun {
+		*h.maxRun = *h.running
+	}
+	h.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.running--
+	*h.applied = append(*h.applied, filepath.Base(path))
+	return h.err
+}
+
+func (h orderHandler) Name() string {
+	return "Order"
+}
 
+func TestApplyGraph(t *testing.T) {
+	graph := map[string][]string{
+		"a": {},
+		"b": {},
+		"c": {"a", "b"},
+		"d": {"c"},
+		"e": {"d"},
+	}
+	tests := []struct {
+		name        string
+		parallelism int
+		failing     string
+		applied     []string // in order, or nil if the order is not predictable
+		maxRun      int
+		err         bool
+	}{
+		{name: "sequential", parallelism: 0, applied: []string{"a", "b", "c", "d", "e"}, maxRun: 1},
+		{name: "parallel", parallelism: 4, maxRun: 2},
+		{name: "stops after error", parallelism: 1, failing: "c", applied: []string{"a", "b", "c"}, maxRun: 1, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var applied []string
+			var running, maxRun int
+			h := orderHandler{mu: &sync.Mutex{}, applied: &applied, running: &running, maxRun: &maxRun}
+			cw := ConfigWalker{HandlerMap: map[string]path_handlers.PathHandler{}, Parallelism: test.parallelism}
+			for p := range graph {
+				cw.HandlerMap[p] = h
+			}
+			if test.failing != "" {
+				failing := h
+				failing.err = fmt.Errorf("failed")
+				cw.HandlerMap[test.failing] = failing
+			}
+
+			err := cw.applyGraph(context.Background(), graph)
+			if (err != nil) != test.err {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if test.applied != nil && !reflect.DeepEqual(applied, test.applied) {
+				t.Errorf("Expected %v to be applied, got %v", test.applied, applied)
+			}
+			if test.applied == nil && len(applied) != len(graph) {
+				t.Errorf("Expected every directory to be applied, got %v", applied)
+			}
+			if maxRun != test.maxRun {
+				t.Errorf("Expected at most %d directories to be applied at once, got %d", test.maxRun, maxRun)
+			}
+			// dependencies are always applied first
+			index := map[string]int{}
+			for i, p := range applied {
+				index[p] = i
+			}
+			for p, deps := range graph {
+				for _, d := range deps {
+					if i, ok := index[p]; ok && index[d] > i {
+						t.Errorf("Expected %s to be applied before %s, got %v", d, p, applied)
+					}
+				}
+			}
+		})
+	}
+}
+
+func osPaths(paths []string) []string {
+	for i := range paths {
+		paths[i] = filepath.FromSlash(paths[i])
+	}
+	return paths
+}
+
+func TestNewConfigWalker_registry(t *testing.T) {
+	docPath, err := ioutil.TempDir(os.TempDir(), "test-vaultsmith-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(docPath)
+	for _, d := range []string{"sys/policy", "identity/group", "pki-int", "pki-root"} {
+		if err := os.MkdirAll(filepath.Join(docPath, d), 0755); err != nil {
+			t.Fatalf("Could not create directory: %s", err)
+		}
+	}
+
+	registry := path_handlers.DefaultRegistry()
+	registry.Register(path_handlers.Registration{Path: "identity", Skip: true})
+	registry.Register(path_handlers.Registration{
+		Path:      "pki-*",
+		DependsOn: []string{"sys/policy"},
+		Factory: func(ctx context.Context, c vault.Vault, conf path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+			return path_handlers.NewDummyHandler(c, conf.DocumentPath)
+		},
+	})
+
+	cw, err := NewConfigWalker(context.Background(), &vault.MockClient{}, config.VaultsmithConfig{}, docPath, registry)
+	if err != nil {
+		t.Fatalf("Error creating config walker: %s", err)
+	}
+
+	for _, p := range []string{"*", "sys", "sys/policy", "identity", "pki-int", "pki-root"} {
+		if _, ok := cw.HandlerMap[p]; !ok {
+			t.Errorf("Expected handler for %q, got %+v", p, cw.HandlerMap)
+		}
+	}
+	// sys/auth is not present in the document tree
+	if _, ok := cw.HandlerMap["sys/auth"]; ok {
+		t.Errorf("Did not expect handler for sys/auth")
+	}
+	if deps := cw.DependsOn["pki-*"]; !reflect.DeepEqual(deps, []string{"sys/policy"}) {
+		t.Errorf("Expected pki-* to depend on sys/policy, got %v", deps)
+	}
 }
 
 type fakeFileInfo struct {
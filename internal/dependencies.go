@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+)
+
+// Name of the file at the root of the document tree which declares dependencies between paths,
+// with a .json, .yaml or .yml extension
+const DependsOnFile = "_depends_on"
+
+// Read the dependencies declared in the _depends_on file of the document tree, if there is one.
+// It maps paths, or patterns as per path.Match, to the paths they must be applied after, e.g.
+// "secret/app: [auth/approle, transit]".
+func readDependsOn(docPath string) (map[string][]string, error) {
+	dependsOn := map[string][]string{}
+	for _, ext := range []string{".json", ".yaml", ".yml"} {
+		name := DependsOnFile + ext
+		data, err := ioutil.ReadFile(filepath.Join(docPath, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := document.Unmarshal(name, data, &dependsOn); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %s", name, err)
+		}
+		return dependsOn, nil
+	}
+	return dependsOn, nil
+}
+
+// Return the paths a directory must be applied after, inferred from where its mount is declared:
+// auth methods are enabled by sys/auth, and secrets engines by sys/mounts. Anything outside sys
+// may also grant policies, so is applied after sys/policy, and policies may refer to the accessors
+// of auth methods, so are applied after sys/auth.
+func inferredDependencies(p string) []string {
+	mount := strings.Split(p, "/")[0]
+	switch {
+	case p == "sys/policy":
+		return []string{"sys/auth"}
+	case mount == "sys":
+		return nil
+	case mount == "auth":
+		return []string{"sys/policy", "sys/auth"}
+	default:
+		return []string{"sys/policy", "sys/mounts"}
+	}
+}
+
+// Whether one slash separated path is the same as, or within, the other
+func related(a string, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// Return the dependencies of each directory to be applied, keyed by its path relative to the
+// document root, as found by walkFile. A directory depends on those which contain, or are within,
+// the paths it depends on. Returns an error if the dependencies form a cycle.
+func (cw ConfigWalker) dependencyGraph() (map[string][]string, error) {
+	var dirs []string
+	for p, handler := range cw.HandlerMap {
+		// Dummy handler is a way of marking as "do not process"
+		if p != path_handlers.GenericPath && handler.Name() != "Dummy" {
+			dirs = append(dirs, filepath.ToSlash(p))
+		}
+	}
+	sort.Strings(dirs)
+
+	// resolve paths to the directories which apply them
+	resolve := func(dir string, target string, hinted bool) (deps []string) {
+		for _, d := range dirs {
+			if d != dir && related(d, target) {
+				deps = append(deps, d)
+			}
+		}
+		if hinted && len(deps) == 0 {
+			if related(dir, target) {
+				log.Warnf("%s can not be ordered after %s, they are applied by the same handler", dir, target)
+			} else {
+				log.Warnf("%s depends on %s, which is not in the document tree", dir, target)
+			}
+		}
+		return deps
+	}
+
+	graph := map[string][]string{}
+	for _, dir := range dirs {
+		deps := map[string]bool{}
+		for _, target := range inferredDependencies(dir) {
+			for _, d := range resolve(dir, target, false) {
+				deps[d] = true
+			}
+		}
+		for pattern, targets := range cw.DependsOn {
+			if match, _ := path.Match(pattern, dir); !match && !related(dir, pattern) {
+				continue
+			}
+			for _, target := range targets {
+				for _, d := range resolve(dir, strings.Trim(target, "/"), true) {
+					deps[d] = true
+				}
+			}
+		}
+		graph[dir] = []string{}
+		for d := range deps {
+			graph[dir] = append(graph[dir], d)
+		}
+		sort.Strings(graph[dir])
+	}
+
+	if cycle := findCycle(graph); cycle != nil {
+		return nil, fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	// back to the keys of HandlerMap
+	osGraph := map[string][]string{}
+	for dir, deps := range graph {
+		for i := range deps {
+			deps[i] = filepath.FromSlash(deps[i])
+		}
+		osGraph[filepath.FromSlash(dir)] = deps
+	}
+	return osGraph, nil
+}
+
+// Return a cycle in the graph, starting and ending with the same path, or nil if there is none
+func findCycle(graph map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(p string) []string
+	visit = func(p string) []string {
+		state[p] = visiting
+		stack = append(stack, p)
+		for _, d := range graph[p] {
+			switch state[d] {
+			case visiting:
+				for i := range stack {
+					if stack[i] == d {
+						return append(append([]string{}, stack[i:]...), d)
+					}
+				}
+			case unvisited:
+				if cycle := visit(d); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[p] = done
+		return nil
+	}
+
+	var paths []string
+	for p := range graph {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if state[p] == unvisited {
+			if cycle := visit(p); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
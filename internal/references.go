@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+)
+
+// Fields of a role which name the policies it grants
+var policyFields = []string{"policies", "token_policies"}
+
+// Policies which exist in every Vault, and are never deleted by the sys/policy handler
+var builtinPolicies = []string{"default", "root"}
+
+// Check that every role (a document at auth/*/role/*) only references policies which will exist
+// once the documents are applied. If the document tree has a sys/policy directory, every other
+// policy is deleted from Vault, so only those declared there are known. Otherwise the policies
+// already in Vault are known, or, if livePolicies is nil, the references cannot be checked.
+// Also returns the declared policies which no role references. These are not problems, as they
+// may be used elsewhere, e.g. by tokens or identity groups.
+func checkPolicyReferences(docPath string, docs []path_handlers.RenderedDocument, livePolicies []string) (problems []error, unused []string) {
+	known := map[string]bool{}
+	for _, p := range builtinPolicies {
+		known[p] = true
+	}
+	declared := map[string]bool{}
+	if _, err := os.Stat(filepath.Join(docPath, "sys", "policy")); err == nil {
+		for _, doc := range docs {
+			if name := strings.TrimPrefix(doc.Path, "sys/policy/"); name != doc.Path {
+				known[name] = true
+				declared[name] = true
+			}
+		}
+	} else if livePolicies != nil {
+		for _, p := range livePolicies {
+			known[p] = true
+		}
+	} else {
+		log.Debug("Not checking policy references, as there is no sys/policy directory or Vault to list them from")
+		return nil, nil
+	}
+
+	referenced := map[string]bool{}
+	for _, doc := range docs {
+		if match, _ := path.Match("auth/*/role/*", doc.Path); !match {
+			continue
+		}
+		for _, name := range referencedPolicies(doc.Data) {
+			referenced[name] = true
+			if !known[name] {
+				problems = append(problems, fmt.Errorf("%s: references unknown policy %q", doc.Path, name))
+			}
+		}
+	}
+
+	for name := range declared {
+		if !referenced[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return problems, unused
+}
+
+// Return the policy names in a rendered role, which may be given as a list or a comma separated
+// string. Vault lower cases policy names, so they are returned lower cased.
+func referencedPolicies(data interface{}) (names []string) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for _, field := range policyFields {
+		var values []string
+		switch v := m[field].(type) {
+		case string:
+			values = strings.Split(v, ",")
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+		for _, value := range values {
+			if name := strings.ToLower(strings.TrimSpace(value)); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
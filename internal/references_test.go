@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/path_handlers"
+)
+
+func TestCheckPolicyReferences(t *testing.T) {
+	withPolicyDir := t.TempDir()
+	os.MkdirAll(filepath.Join(withPolicyDir, "sys", "policy"), 0755)
+	withoutPolicyDir := t.TempDir()
+
+	docs := []path_handlers.RenderedDocument{
+		{Path: "sys/policy/reader", Data: map[string]interface{}{"policy": "path {}"}},
+		{Path: "sys/policy/writer", Data: map[string]interface{}{"policy": "path {}"}},
+		{Path: "sys/policy/unused", Data: map[string]interface{}{"policy": "path {}"}},
+		{Path: "auth/approle/role/a", Data: map[string]interface{}{"policies": "reader, default"}},
+		{Path: "auth/aws/role/b", Data: map[string]interface{}{"token_policies": []interface{}{"Writer", "raeder"}}},
+		{Path: "auth/approle/role/c/secret-id", Data: map[string]interface{}{"policies": "other"}},
+		{Path: "secret/app", Data: map[string]interface{}{"policies": "other"}},
+	}
+
+	tests := []struct {
+		name         string
+		docPath      string
+		livePolicies []string
+		problems     []string
+		unused       []string
+	}{
+		{
+			name:     "declared policies",
+			docPath:  withPolicyDir,
+			problems: []string{`auth/aws/role/b: references unknown policy "raeder"`},
+			unused:   []string{"unused"},
+		},
+		{
+			name:         "declared policies replace those in Vault",
+			docPath:      withPolicyDir,
+			livePolicies: []string{"raeder"},
+			problems:     []string{`auth/aws/role/b: references unknown policy "raeder"`},
+			unused:       []string{"unused"},
+		},
+		{
+			name:         "policies in Vault",
+			docPath:      withoutPolicyDir,
+			livePolicies: []string{"reader", "raeder"},
+			problems:     []string{`auth/aws/role/b: references unknown policy "writer"`},
+		},
+		{
+			name:    "no policies known",
+			docPath: withoutPolicyDir,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems, unused := checkPolicyReferences(test.docPath, docs, test.livePolicies)
+			var got []string
+			for _, p := range problems {
+				got = append(got, p.Error())
+			}
+			if !reflect.DeepEqual(got, test.problems) {
+				t.Errorf("Expected problems %q, got %q", test.problems, got)
+			}
+			if !reflect.DeepEqual(unused, test.unused) {
+				t.Errorf("Expected unused policies %q, got %q", test.unused, unused)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"context"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+)
+
+// Renders the documents as they would be sent to Vault, using the DirRenderer of each
+// registration, sorted by path. No Vault client is needed. Problems are returned as for Validate,
+// along with the documents which could be rendered.
+func Render(ctx context.Context, config config.VaultsmithConfig, docPath string, registry *path_handlers.Registry) (docs []path_handlers.RenderedDocument, problems []error, err error) {
+	_, err = document.GenerateTemplateParams(config.TemplateFile, config.TemplateParams)
+	if err != nil {
+		return nil, []error{err}, nil
+	}
+
+	err = walkOffline(ctx, config, docPath, registry,
+		func(ctx context.Context, reg path_handlers.Registration, handlerConfig path_handlers.PathHandlerConfig, path string) {
+			if reg.Render == nil {
+				log.Warnf("Not rendering %s, the handler for %q has no renderer", path, reg.Path)
+				return
+			}
+			rendered, errs := reg.Render(ctx, handlerConfig, path)
+			docs = append(docs, rendered...)
+			problems = append(problems, errs...)
+		})
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Path < docs[j].Path })
+	return docs, problems, err
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+	"github.com/starlingbank/vaultsmith/schema"
+)
+
+// Called by an offlineHandler for each directory it is given
+type offlineFunc func(ctx context.Context, reg path_handlers.Registration, config path_handlers.PathHandlerConfig, path string)
+
+// A PathHandler which calls fn for each directory it is given, rather than applying it, so the
+// document tree can be walked without Vault
+type offlineHandler struct {
+	reg    path_handlers.Registration
+	config path_handlers.PathHandlerConfig
+	fn     offlineFunc
+}
+
+func (h *offlineHandler) PutPoliciesFromDir(ctx context.Context, path string) error {
+	h.fn(ctx, h.reg, h.config, path)
+	return ctx.Err()
+}
+
+func (h *offlineHandler) Name() string {
+	return "Offline"
+}
+
+// Walk the document tree as ConfigWalker.Run does, calling fn for each directory instead of
+// applying it. No Vault client is needed.
+func walkOffline(ctx context.Context, config config.VaultsmithConfig, docPath string, registry *path_handlers.Registry, fn offlineFunc) error {
+	cw, err := newConfigWalker(ctx, nil, config, docPath, registry,
+		func(reg path_handlers.Registration, handlerConfig path_handlers.PathHandlerConfig) (path_handlers.PathHandler, error) {
+			return &offlineHandler{reg: reg, config: handlerConfig, fn: fn}, nil
+		})
+	if err != nil {
+		return err
+	}
+	// fn need not be safe to call concurrently, and problems are reported in a predictable order
+	cw.Parallelism = 1
+	return cw.Run(ctx)
+}
+
+// Walks the document tree as ConfigWalker.Run does, but checks each directory with its
+// registration's DirRenderer, or DirValidator if it has none, instead of applying it. Documents are
+// also checked against their schemas, see the schema package, and roles against the policies they
+// reference, see checkPolicyReferences. livePolicies are those already in Vault, or nil if not
+// known; no Vault client is needed. Returns every problem found, or an error if the tree could not
+// be walked at all, along with the documents which were rendered.
+func Validate(ctx context.Context, config config.VaultsmithConfig, docPath string, registry *path_handlers.Registry, livePolicies []string) (docs []path_handlers.RenderedDocument, problems []error, err error) {
+	// every document depends on the template file, so report a problem with it only once
+	_, err = document.GenerateTemplateParams(config.TemplateFile, config.TemplateParams)
+	if err != nil {
+		return nil, []error{err}, nil
+	}
+
+	schemas, err := schema.Load(docPath)
+	if err != nil {
+		return nil, []error{err}, nil
+	}
+
+	err = walkOffline(ctx, config, docPath, registry,
+		func(ctx context.Context, reg path_handlers.Registration, handlerConfig path_handlers.PathHandlerConfig, path string) {
+			handlerConfig.Schemas = schemas
+			switch {
+			case reg.Render != nil:
+				// rendering checks the documents, and gives the roles and policies to cross-reference
+				rendered, errs := reg.Render(ctx, handlerConfig, path)
+				docs = append(docs, rendered...)
+				problems = append(problems, errs...)
+			case reg.Validate != nil:
+				problems = append(problems, reg.Validate(ctx, handlerConfig, path)...)
+			default:
+				log.Warnf("Not validating %s, the handler for %q has no validator", path, reg.Path)
+			}
+		})
+	if err != nil || len(problems) > 0 {
+		// a policy which could not be rendered would also be reported as unknown by its roles
+		return docs, problems, err
+	}
+	problems, unused := checkPolicyReferences(docPath, docs, livePolicies)
+	for _, name := range unused {
+		log.WithFields(log.Fields{"policy": name}).Warn("Policy is not referenced by any role")
+	}
+	return docs, problems, nil
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/config"
+	"github.com/starlingbank/vaultsmith/path_handlers"
+)
+
+func TestValidate(t *testing.T) {
+	docPath := t.TempDir()
+	files := map[string]string{
+		"sys/auth/approle.json":                `{"type": "approle"}`,
+		"sys/auth/missing_type.json":           `{"description": "no type"}`,
+		"sys/auth/bad_ttl.yaml":                "type: aws\nconfig:\n  default_lease_ttl: 1 hour\n",
+		"sys/policy/reader.json":               `{"policy": "path \"secret/*\" {}"}`,
+		"sys/policy/empty.json":                `{"policy": ""}`,
+		"auth/approle/role/good.json":          `{"policies": "reader"}`,
+		"auth/approle/role/malformed.json":     "{\n  \"policies\": \"reader\",\n}",
+		"auth/approle/role/{{ service }}.json": `{"policies": "reader"}`,
+		"custom/ignored.json":                  "not validated",
+	}
+	for name, content := range files {
+		p := filepath.Join(docPath, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry := path_handlers.DefaultRegistry()
+	// a handler without a validator is skipped
+	registry.Register(path_handlers.Registration{Path: "custom"})
+
+	_, problems, err := Validate(context.Background(), config.VaultsmithConfig{}, docPath, registry, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, strings.SplitN(p.Error(), ":", 2)[0])
+	}
+	sort.Strings(got)
+	exp := []string{
+		"auth/approle/role/malformed.json",
+		"auth/approle/role/{{ service }}.json",
+		"sys/auth/bad_ttl.yaml",
+		"sys/auth/missing_type.json",
+		"sys/policy/empty.json",
+	}
+	if strings.Join(got, ",") != strings.Join(exp, ",") {
+		t.Errorf("Expected problems in %v, got %v", exp, problems)
+	}
+}
+
+func TestValidate_templateFile(t *testing.T) {
+	docPath := t.TempDir()
+	templateFile := filepath.Join(docPath, "_vaultsmith.json")
+	ioutil.WriteFile(templateFile, []byte("{"), 0644)
+	os.MkdirAll(filepath.Join(docPath, "auth", "approle"), 0755)
+	ioutil.WriteFile(filepath.Join(docPath, "auth", "approle", "a.json"), []byte("{}"), 0644)
+	ioutil.WriteFile(filepath.Join(docPath, "auth", "approle", "b.json"), []byte("{}"), 0644)
+
+	_, problems, err := Validate(context.Background(), config.VaultsmithConfig{TemplateFile: templateFile}, docPath, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(problems) != 1 {
+		t.Errorf("Expected a single problem with the template file, got %v", problems)
+	}
+}
+
+func TestValidate_policyReferences(t *testing.T) {
+	docPath := t.TempDir()
+	files := map[string]string{
+		"sys/policy/reader.json":      `{"policy": "path \"secret/*\" {}"}`,
+		"auth/approle/role/good.json": `{"policies": "reader"}`,
+		"auth/approle/role/typo.json": `{"token_policies": ["raeder"]}`,
+	}
+	for name, content := range files {
+		p := filepath.Join(docPath, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, problems, err := Validate(context.Background(), config.VaultsmithConfig{}, docPath, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(problems) != 1 || problems[0].Error() != `auth/approle/role/typo: references unknown policy "raeder"` {
+		t.Errorf("Expected a problem with the reference to raeder, got %v", problems)
+	}
+}
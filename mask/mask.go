@@ -0,0 +1,106 @@
+package mask
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Replacement is the text substituted for any registered secret value
+const Replacement = "********"
+
+// Secret values resolved at render time (e.g. from the environment or files). Anything registered
+// here is replaced before being written to logs or diff output.
+var (
+	mu      sync.RWMutex
+	secrets = map[string]bool{}
+)
+
+// Register a value as secret, so it is masked from now on. Empty values are ignored, as masking
+// them would mangle every string.
+func Register(value string) {
+	if value == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	secrets[value] = true
+}
+
+// Reset forgets all registered secrets
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	secrets = map[string]bool{}
+}
+
+// String returns s with every registered secret replaced
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(secrets) == 0 {
+		return s
+	}
+	// replace longest values first, so a secret containing another secret is fully masked
+	values := make([]string, 0, len(secrets))
+	for v := range secrets {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		s = strings.Replace(s, v, Replacement, -1)
+	}
+	return s
+}
+
+// Value returns a copy of v with secrets masked in any strings it contains. Maps and slices are
+// copied rather than modified, as the original is likely still to be sent to Vault. Other structured
+// values, such as auth method options, are masked as their JSON representation.
+func Value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return String(t)
+	case map[string]interface{}:
+		return Data(t)
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i := range t {
+			out[i] = Value(t[i])
+		}
+		return out
+	case []string:
+		out := make([]string, len(t))
+		for i := range t {
+			out[i] = String(t[i])
+		}
+		return out
+	default:
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Ptr, reflect.Struct, reflect.Map, reflect.Slice:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return v
+			}
+			var out interface{}
+			if err := json.Unmarshal(b, &out); err != nil {
+				return v
+			}
+			return Value(out)
+		}
+		return v
+	}
+}
+
+// Data returns a masked copy of a Vault document
+func Data(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		out[k] = Value(v)
+	}
+	return out
+}
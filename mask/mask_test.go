@@ -0,0 +1,75 @@
+package mask
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	defer Reset()
+	Register("hunter2")
+	Register("")
+
+	r := String("password is hunter2")
+	exp := "password is " + Replacement
+	if r != exp {
+		t.Errorf("Expected %q, got %q", exp, r)
+	}
+}
+
+func TestString_longestFirst(t *testing.T) {
+	defer Reset()
+	Register("abc")
+	Register("abcdef")
+
+	r := String("abcdef")
+	if r != Replacement {
+		t.Errorf("Expected %q, got %q", Replacement, r)
+	}
+}
+
+func TestData(t *testing.T) {
+	defer Reset()
+	Register("s3cr3t")
+
+	in := map[string]interface{}{
+		"secret_key": "s3cr3t",
+		"nested":     map[string]interface{}{"password": "s3cr3t"},
+		"list":       []interface{}{"s3cr3t", 1},
+		"ttl":        60,
+	}
+	exp := map[string]interface{}{
+		"secret_key": Replacement,
+		"nested":     map[string]interface{}{"password": Replacement},
+		"list":       []interface{}{Replacement, 1},
+		"ttl":        60,
+	}
+	r := Data(in)
+	if !reflect.DeepEqual(r, exp) {
+		t.Errorf("Expected %+v, got %+v", exp, r)
+	}
+	if in["secret_key"] != "s3cr3t" {
+		t.Errorf("Data modified its input: %+v", in)
+	}
+}
+
+func TestValue_struct(t *testing.T) {
+	defer Reset()
+	Register("s3cr3t")
+
+	type options struct {
+		Type    string            `json:"type"`
+		Options map[string]string `json:"options"`
+	}
+	in := &options{Type: "ldap", Options: map[string]string{"bindpass": "s3cr3t"}}
+	exp := map[string]interface{}{
+		"type":    "ldap",
+		"options": map[string]interface{}{"bindpass": Replacement},
+	}
+	if r := Value(in); !reflect.DeepEqual(r, exp) {
+		t.Errorf("Expected %+v, got %+v", exp, r)
+	}
+	if in.Options["bindpass"] != "s3cr3t" {
+		t.Errorf("Value modified its input: %+v", in)
+	}
+}
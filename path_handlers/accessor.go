@@ -0,0 +1,51 @@
+package path_handlers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+// Return the template function resolving the path of an auth method to its mount accessor, e.g.
+// {{ accessor "kubernetes/" }}. Auth mounts are listed on each call, as sys/auth may have enabled
+// one since. Without a client, as when rendering offline, a placeholder is returned. If dry, see
+// accessorOf.
+func AccessorFunc(ctx context.Context, client vault.Vault, documentPath string, dry bool) document.TemplateFunc {
+	return func(path string) (string, error) {
+		if client == nil {
+			return accessorPlaceholder(path), nil
+		}
+		mounts, err := client.ListAuth(ctx)
+		if err != nil {
+			return "", fmt.Errorf("could not list auth mounts: %s", err)
+		}
+		return accessorOf(mounts, documentPath, path, dry)
+	}
+}
+
+// Return the accessor of the auth method mounted at path. If dry, nothing is written to Vault, so
+// an auth method declared in sys/auth may not be enabled yet, and a placeholder is returned.
+func accessorOf(mounts map[string]*vaultApi.AuthMount, documentPath string, path string, dry bool) (string, error) {
+	path = strings.Trim(path, "/")
+	if mount, ok := mounts[path+"/"]; ok && mount != nil {
+		return mount.Accessor, nil
+	}
+	declared, _ := filepath.Glob(filepath.Join(documentPath, "sys", "auth", filepath.FromSlash(path)+".*"))
+	if len(declared) > 0 && dry {
+		return accessorPlaceholder(path), nil
+	}
+	if len(declared) > 0 {
+		// sys/auth is applied first by default, but may not be with a custom registry or dependencies
+		return "", fmt.Errorf("no auth method is enabled at %s, although it is declared in sys/auth", path)
+	}
+	return "", fmt.Errorf("no auth method is enabled at %s", path)
+}
+
+func accessorPlaceholder(path string) string {
+	return fmt.Sprintf("<accessor of %s>", strings.Trim(path, "/"))
+}
@@ -0,0 +1,48 @@
+package path_handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+func TestAccessorFunc(t *testing.T) {
+	docPath := writeDocuments(t, map[string]string{
+		"sys/auth/kubernetes.json": `{"type": "kubernetes"}`,
+	})
+	client := &identityClient{}
+	tests := []struct {
+		name   string
+		client vault.Vault
+		path   string
+		dry    bool
+		exp    string
+		expErr string
+	}{
+		{name: "enabled", client: client, path: "github/", exp: "auth_github_123"},
+		{name: "without slash", client: client, path: "github", exp: "auth_github_123"},
+		{name: "declared in sys/auth, dry", client: client, path: "kubernetes/", dry: true, exp: "<accessor of kubernetes>"},
+		{name: "declared in sys/auth", client: client, path: "kubernetes/",
+			expErr: "no auth method is enabled at kubernetes, although it is declared in sys/auth"},
+		{name: "not enabled", client: client, path: "aws/", dry: true, expErr: "no auth method is enabled at aws"},
+		{name: "offline", client: nil, path: "github/", exp: "<accessor of github>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AccessorFunc(context.Background(), tt.client, docPath, tt.dry)(tt.path)
+			if tt.expErr != "" {
+				if err == nil || err.Error() != tt.expErr {
+					t.Errorf("Expected error %q, got %v", tt.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != tt.exp {
+				t.Errorf("Expected %q, got %q", tt.exp, got)
+			}
+		})
+	}
+}
@@ -2,26 +2,44 @@ package path_handlers
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	log "github.com/sirupsen/logrus"
-	"github.com/starlingbank/vaultsmith/vault"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/schema"
+	"github.com/starlingbank/vaultsmith/vault"
 )
 
 type PathHandlerConfig struct {
 	DocumentPath      string // path to the base of the vault documents
-	Order             int    // order to process (lower int is earlier, except 0 is last)
 	TemplateFile      string
 	TemplateOverrides []string
+	ForceWriteOnly    bool        // write documents with write-only fields, even if otherwise applied
+	Schemas           *schema.Set // if set, documents are checked against their schema when read
+	// functions callable from templates, in addition to document.DefaultFunctions()
+	Functions map[string]document.TemplateFunc
+	// nothing is written to Vault, so values which would be read back from it after an earlier
+	// handler has run, such as auth method accessors, may be placeholders
+	Dry bool
+}
+
+// Return the functions callable from templates
+func (c PathHandlerConfig) templateFunctions() map[string]document.TemplateFunc {
+	functions := document.DefaultFunctions()
+	for name, f := range c.Functions {
+		functions[name] = f
+	}
+	return functions
 }
 
 // A PathHandler takes a path and applies the policies within
 type PathHandler interface {
-	PutPoliciesFromDir(path string) error
-	Order() int
+	PutPoliciesFromDir(ctx context.Context, path string) error
 	Name() string
 }
 
@@ -32,20 +50,14 @@ type BaseHandler struct {
 	client   vault.Vault
 	config   PathHandlerConfig
 	rootPath string
-	order    int // order to process. Lower is earlier, with the exception of 0, which is
-	// processed after any others with a positive integer
-	name string
-	log  *log.Entry
+	name     string
+	log      *log.Entry
 }
 
 func (h *BaseHandler) Name() string {
 	return h.name
 }
 
-func (h *BaseHandler) Order() int {
-	return h.order
-}
-
 func (h *BaseHandler) readFile(path string) (string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -1,6 +1,7 @@
 package path_handlers
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/vault"
 )
@@ -12,12 +13,11 @@ type Dummy struct {
 	rootPath string // path to handle
 }
 
-func NewDummyHandler(c vault.Vault, rootPath string, order int) (*Dummy, error) {
+func NewDummyHandler(c vault.Vault, rootPath string) (*Dummy, error) {
 	return &Dummy{
 		BaseHandler: BaseHandler{
 			client:   c,
 			rootPath: rootPath,
-			order:    order,
 			name:     "Dummy",
 			log: log.WithFields(log.Fields{
 				"handler": "generic",
@@ -26,11 +26,7 @@ func NewDummyHandler(c vault.Vault, rootPath string, order int) (*Dummy, error)
 	}, nil
 }
 
-func (h *Dummy) PutPoliciesFromDir(path string) error {
+func (h *Dummy) PutPoliciesFromDir(ctx context.Context, path string) error {
 	h.log.Debugf("Dummy handler got path: %s", path)
 	return nil
 }
-
-func (h *Dummy) Order() int {
-	return h.order
-}
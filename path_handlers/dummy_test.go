@@ -7,7 +7,7 @@ import (
 
 func TestNewDummyHandler(t *testing.T) {
 	c := &vault.MockClient{}
-	_, err := NewDummyHandler(c, "example", 0)
+	_, err := NewDummyHandler(c, "example")
 	if err != nil {
 		t.Errorf("Failed to create dummy handler: %s", err.Error())
 	}
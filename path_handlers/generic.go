@@ -1,19 +1,17 @@
 package path_handlers
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/mask"
 	"github.com/starlingbank/vaultsmith/vault"
 	"os"
 	"path/filepath"
-	"reflect"
-	"sort"
-	"strconv"
 	"strings"
-	"time"
 )
 
 // Required information to write a document to vault
@@ -21,6 +19,7 @@ type vaultDocument struct {
 	path       string
 	data       map[string]interface{}
 	sourceFile string
+	writeOnly  []string // fields that are never returned by Vault, so can't be compared
 }
 
 // The generic handler simply writes the files to the path they are stored in
@@ -28,11 +27,14 @@ type Generic struct {
 	BaseHandler
 	configuredDocMap map[string]vaultDocument
 	removedDocMap    map[string]interface{}
+	authMounts       map[string]*vaultApi.AuthMount   // live auth mounts, to determine backend types
+	secretMounts     map[string]*vaultApi.MountOutput // live secrets engine mounts, likewise
 }
 
 func NewGeneric(client vault.Vault, config PathHandlerConfig) (*Generic, error) {
 	return &Generic{
 		BaseHandler: BaseHandler{
+			name:   "Generic",
 			client: client,
 			config: config,
 			log: log.WithFields(log.Fields{
@@ -44,7 +46,7 @@ func NewGeneric(client vault.Vault, config PathHandlerConfig) (*Generic, error)
 	}, nil
 }
 
-func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
+func (gh *Generic) walkFile(ctx context.Context, path string, f os.FileInfo, err error) error {
 	logger := gh.log.WithFields(log.Fields{
 		"path":  path,
 		"error": err,
@@ -57,79 +59,102 @@ func (gh *Generic) walkFile(path string, f os.FileInfo, err error) error {
 	if err != nil {
 		return fmt.Errorf("error finding %s: %s", path, err)
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// not doing anything with dirs
 	if f.IsDir() {
 		return nil
 	}
 
-	tp, err := document.GenerateTemplateParams(gh.config.TemplateFile, gh.config.TemplateOverrides)
+	docs, err := readDocuments(gh.config, path, f.Name())
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for _, doc := range docs {
+		err := gh.ensureDoc(ctx, doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Render and parse the documents in a file, without reference to Vault. Errors do not include the
+// path, so the caller can choose how to report it.
+func readDocuments(config PathHandlerConfig, path string, name string) (docs []vaultDocument, err error) {
+	tp, err := document.GenerateTemplateParams(config.TemplateFile, config.TemplateOverrides)
 	if err != nil {
-		return fmt.Errorf("could not generate template parameters: %s", err)
+		return nil, fmt.Errorf("could not generate template parameters: %s", err)
 	}
 
 	content, err := document.Read(path)
 	if err != nil {
-		return fmt.Errorf("error reading %q: %s", path, err)
+		return nil, fmt.Errorf("error reading: %s", err)
 	}
 	td := &document.Template{
-		FileName: f.Name(),
-		Content:  content,
-		Params:   tp,
+		FileName:  name,
+		Content:   content,
+		Params:    tp,
+		Functions: config.templateFunctions(),
 	}
 
 	templatedDocs, err := td.Render()
 	if err != nil {
-		return fmt.Errorf("failed to render document %q: %s", path, err)
+		return nil, fmt.Errorf("failed to render document: %s", err)
 	}
 
 	// figure out where to write to
-	apiDir, err := apiDir(gh.config.DocumentPath, path)
+	apiDir, err := apiDir(config.DocumentPath, path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, td := range templatedDocs {
-		// parse our document data as json
+		// parse our document data as json or yaml
 		var data map[string]interface{}
-		err = json.Unmarshal([]byte(td.Content), &data)
+		err = document.Unmarshal(name, []byte(td.Content), &data)
 		if err != nil {
-			log.Debugf("Content:\n%s", data)
-			return fmt.Errorf("failed to parse json from file %q: %s", path, err)
+			log.Debugf("Content:\n%s", mask.String(td.Content))
+			return nil, fmt.Errorf("failed to parse document: %s", err)
 		}
 
-		doc := vaultDocument{
-			path:       filepath.Join(apiDir, td.Name),
-			data:       data,
-			sourceFile: f.Name(),
-		}
-		err := gh.ensureDoc(doc)
-		if err != nil {
-			return err
+		docPath := filepath.Join(apiDir, td.Name)
+		if err := config.Schemas.Validate(docPath, data); err != nil {
+			return nil, err
 		}
+		docs = append(docs, vaultDocument{
+			path:       docPath,
+			data:       data,
+			sourceFile: name,
+			writeOnly:  writeOnlyFields(tp.WriteOnly, docPath),
+		})
 	}
-
-	return nil
+	return docs, nil
 }
 
-func (gh *Generic) PutPoliciesFromDir(path string) error {
+func (gh *Generic) PutPoliciesFromDir(ctx context.Context, path string) error {
 	// path must be a real file system path here, not the relative path to the document root
-	err := filepath.Walk(path, gh.walkFile)
+	err := filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		return gh.walkFile(ctx, p, f, err)
+	})
 	if err != nil {
 		return err
 	}
 
-	return gh.removeUndeclaredDocuments(path)
+	return gh.removeUndeclaredDocuments(ctx, path)
 }
 
 // Ensure the document is present and consistent
-func (gh *Generic) ensureDoc(doc vaultDocument) error {
+func (gh *Generic) ensureDoc(ctx context.Context, doc vaultDocument) error {
 	logger := gh.log.WithFields(log.Fields{
 		"path":       doc.path,
 		"sourceFile": doc.sourceFile,
 	})
 	gh.configuredDocMap[doc.path] = doc
 
-	if applied, err := gh.isDocApplied(doc); err != nil {
+	if applied, err := gh.isDocApplied(ctx, doc); err != nil {
 		if strings.Contains(err.Error(), "permission denied") {
 			// Continue with a warning on 403. The user might not have permission to read all
 			// documents, and in this case we want to continue updating others, without attempting
@@ -144,14 +169,23 @@ func (gh *Generic) ensureDoc(doc vaultDocument) error {
 	}
 
 	logger.Infof("Applying document")
-	_, err := gh.client.Write(doc.path, doc.data)
+	_, err := gh.client.Write(ctx, doc.path, doc.data)
 	return err
 }
 
 // true if the document is on the server and matches the one configured
-func (gh *Generic) isDocApplied(doc vaultDocument) (bool, error) {
-	secret, err := gh.client.Read(doc.path)
+func (gh *Generic) isDocApplied(ctx context.Context, doc vaultDocument) (bool, error) {
+	if gh.config.ForceWriteOnly && hasAnyField(doc.data, doc.writeOnly) {
+		gh.log.WithFields(log.Fields{"path": doc.path}).Debug(
+			"Document has write-only fields and force-write-only is set")
+		return false, nil
+	}
+
+	secret, err := gh.client.Read(ctx, doc.path)
 	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
 		if strings.Contains(err.Error(), "Code: 403") {
 			gh.log.Debug(err.Error())
 			return false, errors.New("permission denied (code 403)")
@@ -165,33 +199,69 @@ func (gh *Generic) isDocApplied(doc vaultDocument) (bool, error) {
 		return false, nil
 	}
 
-	return gh.areKeysApplied(doc.data, secret.Data), nil
+	return gh.areKeysApplied(gh.backendType(ctx, doc.path), withoutFields(doc.data, doc.writeOnly), secret.Data), nil
+}
+
+// Determine the type of backend a document is written to, so its fields can be compared with
+// the appropriate normalisers. If the mount is not found, or the mounts can't be listed, it is
+// assumed to be mounted at the path of its type, e.g. pki/.
+func (gh *Generic) backendType(ctx context.Context, docPath string) string {
+	parts := strings.Split(docPath, "/")
+	if parts[0] != "auth" || len(parts) < 2 {
+		return gh.secretsEngineType(ctx, docPath)
+	}
+	if gh.authMounts == nil {
+		// looked up lazily, as the SysAuth handler may have changed them
+		mounts, err := gh.client.ListAuth(ctx)
+		if err != nil {
+			// e.g. the token can't read sys/auth, which need not stop the document being applied
+			gh.log.Debugf("Could not list auth mounts, assuming types from paths: %s", err)
+			mounts = map[string]*vaultApi.AuthMount{}
+		}
+		gh.authMounts = mounts
+	}
+	if mount, ok := gh.authMounts[parts[1]+"/"]; ok && mount != nil {
+		return mount.Type
+	}
+	return parts[1]
+}
+
+// Return the type of the secrets engine with the longest mount path containing docPath
+func (gh *Generic) secretsEngineType(ctx context.Context, docPath string) string {
+	if gh.secretMounts == nil {
+		// looked up lazily, as sys/mounts may have changed them
+		mounts, err := gh.client.ListMounts(ctx)
+		if err != nil {
+			gh.log.Debugf("Could not list secrets engine mounts, assuming types from paths: %s", err)
+			mounts = map[string]*vaultApi.MountOutput{}
+		}
+		gh.secretMounts = mounts
+	}
+	backend, longest := strings.Split(docPath, "/")[0], 0
+	for p, mount := range gh.secretMounts {
+		if mount != nil && strings.HasPrefix(docPath+"/", p) && len(p) > longest {
+			backend, longest = mount.Type, len(p)
+		}
+	}
+	return backend
 }
 
 // Ensure all key/value pairs in mapA are present and consistent in mapB
 // extra keys in remoteMap are ignored
-func (gh *Generic) areKeysApplied(mapA map[string]interface{}, mapB map[string]interface{}) bool {
+func (gh *Generic) areKeysApplied(backend string, mapA map[string]interface{}, mapB map[string]interface{}) bool {
 	for key := range mapA {
 		if _, ok := mapB[key]; !ok {
 			return false // not present at all
 		}
-		if reflect.DeepEqual(mapA[key], mapB[key]) {
-			continue // value the same, skip further checks for this key
-		}
-
-		// this is a bit more complicated, thanks to ttls and bundling into arrays :(
-		if strings.Contains(key, "ttl") {
-			// check if the ttls are equivalent
-			if isTtlEquivalent(mapA[key], mapB[key]) {
-				continue
-			}
+		equivalent, err := valuesEquivalent(backend, key, mapA[key], mapB[key])
+		if err != nil {
+			gh.log.Warnf("Could not compare field %q: %s", key, mask.String(err.Error()))
 		}
-		// covers cases such as "policy" == ["policy]
-		// logic is a bit scary, see function documentation
-		if isSliceEquivalent(mapA[key], mapB[key]) {
+		if equivalent {
 			continue
 		}
-		gh.log.Debugf("Field %q not equal; %+v (type %T) != %+v (type %T)", key, mapA[key], mapA[key], mapB[key], mapB[key])
+		gh.log.Debugf("Field %q not equal; %+v (type %T) != %+v (type %T)",
+			key, mask.Value(mapA[key]), mapA[key], mask.Value(mapB[key]), mapB[key])
 		return false
 	}
 	return true
@@ -199,12 +269,14 @@ func (gh *Generic) areKeysApplied(mapA map[string]interface{}, mapB map[string]i
 
 // Remove documents that are not declared
 // Note; only the configured path for this handler is affected
-func (gh *Generic) removeUndeclaredDocuments(path string) (err error) {
-	err = filepath.Walk(path, gh.removalWalk)
+func (gh *Generic) removeUndeclaredDocuments(ctx context.Context, path string) (err error) {
+	err = filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		return gh.removalWalk(ctx, p, f, err)
+	})
 	return
 }
 
-func (gh *Generic) removalWalk(path string, f os.FileInfo, err error) error {
+func (gh *Generic) removalWalk(ctx context.Context, path string, f os.FileInfo, err error) error {
 	if !f.IsDir() {
 		return nil
 	}
@@ -213,7 +285,7 @@ func (gh *Generic) removalWalk(path string, f os.FileInfo, err error) error {
 		return err
 	}
 
-	secret, err := gh.client.List(apiPath)
+	secret, err := gh.client.List(ctx, apiPath)
 	if err != nil {
 		return err
 	}
@@ -242,7 +314,7 @@ func (gh *Generic) removalWalk(path string, f os.FileInfo, err error) error {
 		logger := gh.log.WithFields(log.Fields{"docPath": docPath})
 
 		logger.Info("Removing document")
-		_, err := gh.client.Delete(docPath)
+		_, err := gh.client.Delete(ctx, docPath)
 		if err != nil {
 			return err
 		}
@@ -251,201 +323,3 @@ func (gh *Generic) removalWalk(path string, f os.FileInfo, err error) error {
 
 	return nil
 }
-
-func (gh *Generic) Order() int {
-	return gh.order
-}
-
-// Determine whether an array is logically equivalent as far as Vault is concerned.
-// examples:
-//		[policy] == policy
-// 		[] == ""
-//		["foo", "bar"] == ["bar", "foo"]
-func isSliceEquivalent(a interface{}, b interface{}) (equivalent bool) {
-	if reflect.TypeOf(a).Kind() == reflect.TypeOf(b).Kind() {
-		// just compare directly if type is the same
-		if reflect.DeepEqual(a, b) {
-			return true
-		}
-
-		var aString []string
-		var bString []string
-		var err error
-
-		xif, aok := a.([]interface{})
-		if aok {
-			// recast as []string
-			aString, err = interfaceSliceToStringSlice(xif)
-			if err != nil {
-				log.Debugf("Could not cast %+v as string slice", a)
-				return false
-			}
-		}
-
-		yif, bok := b.([]interface{})
-		if bok {
-			// recast as []string
-			bString, err = interfaceSliceToStringSlice(yif)
-			if err != nil {
-				log.Debugf("Could not cast %+v as string slice", a)
-				return false
-			}
-		}
-
-		// try to cast as string
-		xs, aok := a.([]string)
-		if aok {
-			aString = xs
-		}
-		ys, bok := b.([]string)
-		if bok {
-			bString = ys
-		}
-
-		// try to cast as int
-		xi, aok := a.([]int)
-		if aok {
-			aString, err = intSliceToStringSlice(xi)
-		}
-		yi, bok := b.([]int)
-		if bok {
-			bString, err = intSliceToStringSlice(yi)
-		}
-
-		// if either was successfully cast, test them as sorted arrays
-		if len(aString) != 0 || len(bString) != 0 {
-			sort.Sort(sort.StringSlice(aString))
-			sort.Sort(sort.StringSlice(bString))
-
-			if reflect.DeepEqual(aString, bString) {
-				return true
-			}
-		}
-
-		return false
-	}
-
-	if reflect.TypeOf(a).Kind() == reflect.Slice {
-		// b must not be a slice, compare a[0] to it
-		return firstElementEqual(a, b)
-	}
-
-	if reflect.TypeOf(b).Kind() == reflect.Slice {
-		// a must not be a slice, compare b[0] to it
-		return firstElementEqual(b, a)
-	}
-
-	return false
-}
-
-func intSliceToStringSlice(in []int) (out []string, err error) {
-	for _, v := range in {
-		s := strconv.Itoa(v)
-		out = append(out, s)
-	}
-	return out, err
-}
-
-func interfaceSliceToStringSlice(in []interface{}) (out []string, err error) {
-	for _, v := range in {
-		x, ok := v.(string)
-		if !ok {
-			return out, fmt.Errorf("could not case `%+v` as string", v)
-		}
-		out = append(out, x)
-	}
-	return out, err
-}
-
-// Return true if value is equal to the first item in slice
-func firstElementEqual(slice interface{}, value interface{}) bool {
-	switch t := slice.(type) {
-	case []string:
-		if len(t) == 0 {
-			return value.(string) == ""
-		}
-		if t[0] == value && len(t) == 1 {
-			return true
-		}
-	case []int:
-		if len(t) == 0 {
-			return value.(string) == ""
-		}
-		if t[0] == value && len(t) == 1 {
-			return true
-		}
-	case []interface{}:
-		if len(t) == 0 {
-			return value.(string) == ""
-		}
-		s := reflect.ValueOf(t)
-		var val interface{}
-		for i := 0; i < s.Len(); i++ {
-			if i > 0 { // length > 1, cannot be equivalent
-				return false
-			}
-			if i == 0 {
-				// This is a little scary in a strongly typed context, as we're parsing everything
-				// as a string. But in the context of vault API responses it should be OK...
-				val = fmt.Sprintf("%v", s.Index(i))
-			}
-		}
-		if val == value {
-			return true
-		}
-	default:
-		log.Errorf("Unhandled type %T in firstElementEqual(), needs to be added to the "+
-			"switch statement; please raise a bug", t)
-		return false
-	}
-
-	return false
-}
-
-// Determine whether a string ttl is equal to an int ttl
-func isTtlEquivalent(ttlA interface{}, ttlB interface{}) bool {
-	durA, err := convertToDuration(ttlA)
-	if err != nil {
-		log.Warnf("Could not parse %+v: %s", ttlA, err)
-		return false
-	}
-	durB, err := convertToDuration(ttlB)
-	if err != nil {
-		log.Warnf("Could not convert %+v to duration: %s", ttlA, err)
-		return false
-	}
-
-	if durA == durB {
-		return true
-	}
-
-	return false
-}
-
-// convert x to time.Duration. if x is an integer, we assume it is in seconds
-func convertToDuration(x interface{}) (time.Duration, error) {
-	var duration time.Duration
-	var err error
-
-	switch x.(type) {
-	case string:
-		duration, err = time.ParseDuration(x.(string))
-		if err != nil {
-			return 0, fmt.Errorf("%q can't be parsed as duration", x)
-		}
-	case int64:
-		duration = time.Duration(x.(int64)) * time.Second
-	case int:
-		duration = time.Duration(int64(x.(int))) * time.Second
-	case json.Number:
-		i, err := x.(json.Number).Int64()
-		if err != nil {
-			return 0, fmt.Errorf("could not parse %+v as json number: %s", x, err.Error())
-		}
-		duration = time.Duration(i) * time.Second
-	default:
-		return 0, fmt.Errorf("type of '%+v' not handled", reflect.TypeOf(x))
-	}
-
-	return duration, nil
-}
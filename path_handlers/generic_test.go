@@ -1,7 +1,8 @@
 package path_handlers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/vault"
@@ -25,7 +26,7 @@ func TestGeneric_isDocApplied_true(t *testing.T) {
 		log.Fatal("Failed to create generic handler")
 	}
 
-	result, err := gh.isDocApplied(testDoc)
+	result, err := gh.isDocApplied(context.Background(), testDoc)
 	if err != nil {
 		t.Errorf("Error calling isDocApplied: %s", err)
 	}
@@ -54,7 +55,7 @@ func TestGeneric_isDocApplied_falseValue(t *testing.T) {
 		log.Fatal("Failed to create generic handler")
 	}
 
-	result, err := gh.isDocApplied(testDoc)
+	result, err := gh.isDocApplied(context.Background(), testDoc)
 	if err != nil {
 		t.Errorf("Error calling isDocApplied: %s", err)
 	}
@@ -79,7 +80,7 @@ func TestGeneric_areKeysApplied_true(t *testing.T) {
 	testDataB["testKey"] = "testValue"
 	testDataB["otherKey"] = "otherValue" // extra values are OK, we only care if the defined ones are present
 
-	r := gh.areKeysApplied(testDataA, testDataB)
+	r := gh.areKeysApplied("generic", testDataA, testDataB)
 	if !r {
 		log.Fatal("Expected areKeysApplied to return true")
 	}
@@ -102,7 +103,7 @@ func TestGeneric_areKeysApplied_false(t *testing.T) {
 
 	testDataB["testKey"] = "testValue"
 
-	r := gh.areKeysApplied(testDataA, testDataB)
+	r := gh.areKeysApplied("generic", testDataA, testDataB)
 	if r {
 		log.Fatal("Expected areKeysApplied to return false")
 	}
@@ -146,79 +147,108 @@ func TestConvertAuthConfigConvertsMaxLeaseTTL(t *testing.T) {
 	}
 }
 
-func TestIsTtlEquivalent(t *testing.T) {
-	tests := []struct {
-		name     string
-		ttlA     interface{}
-		ttlB     interface{}
-		expected bool
-	}{
-		{name: "strings", ttlA: "1m", ttlB: "1m", expected: true},
-		{name: "ints", ttlA: 60, ttlB: 60, expected: true},
-		{name: "string + int", ttlA: "1m", ttlB: 60, expected: true},
-
-		{name: "unequal ints", ttlA: 10, ttlB: 20, expected: false},
-		{name: "unequal strings", ttlA: "1m", ttlB: "2m", expected: false},
-		{name: "unequal strings + int", ttlA: "1m", ttlB: 120, expected: false},
-
-		{name: "json.Number + string", ttlA: json.Number("60"), ttlB: "1m", expected: true},
-	}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			rv := isTtlEquivalent(test.ttlA, test.ttlB)
-			if rv != test.expected {
-				t.Errorf("Test case %s failed. Expected %v, got %v. ttlA: %s, ttlB: %s",
-					test.name, test.expected, rv, test.ttlA, test.ttlB)
-			}
+// write-only fields are never returned by vault, so should not be compared
+func TestGeneric_isDocApplied_writeOnly(t *testing.T) {
+	localData := map[string]interface{}{"access_key": "foo", "secret_key": "bar"}
+	remoteData := map[string]interface{}{"access_key": "foo"}
+	testDoc := vaultDocument{
+		path:      "auth/aws/config/client",
+		data:      localData,
+		writeOnly: []string{"secret_key"},
+	}
+	client := &vault.MockClient{
+		ReturnSecret: &vaultApi.Secret{Data: remoteData},
+	}
 
-		})
+	gh, err := NewGeneric(client, PathHandlerConfig{})
+	if err != nil {
+		log.Fatal("Failed to create generic handler")
 	}
+	result, err := gh.isDocApplied(context.Background(), testDoc)
+	if err != nil {
+		t.Errorf("Error calling isDocApplied: %s", err)
+	}
+	if !result {
+		t.Errorf("Got false result, expected true")
+	}
+
+	gh, err = NewGeneric(client, PathHandlerConfig{ForceWriteOnly: true})
+	if err != nil {
+		log.Fatal("Failed to create generic handler")
+	}
+	result, err = gh.isDocApplied(context.Background(), testDoc)
+	if err != nil {
+		t.Errorf("Error calling isDocApplied: %s", err)
+	}
+	if result {
+		t.Errorf("Got true result with ForceWriteOnly, expected false")
+	}
+}
+
+func TestGeneric_backendType(t *testing.T) {
+	gh, err := NewGeneric(&vault.MockClient{}, PathHandlerConfig{})
+	if err != nil {
+		log.Fatal("Failed to create generic handler")
+	}
+	gh.authMounts = map[string]*vaultApi.AuthMount{
+		"aws-prod/": {Type: "aws"},
+	}
+	gh.secretMounts = map[string]*vaultApi.MountOutput{
+		"pki-int/":  {Type: "pki"},
+		"team/":     {Type: "generic"},
+		"team/kv/":  {Type: "kv"},
+		"pki-root/": nil,
+	}
+	tests := map[string]string{
+		"auth/aws-prod/role/foo": "aws",
+		"auth/approle/role/foo":  "approle",
+		"pki/roles/foo":          "pki",
+		"pki-int/roles/foo":      "pki",
+		"team/kv/config":         "kv",
+		"team/other":             "generic",
+		"teams/other":            "teams",
+	}
+	for path, expected := range tests {
+		r := gh.backendType(context.Background(), path)
+		if r != expected {
+			t.Errorf("Expected backend type %q for %q, got %q", expected, path, r)
+		}
+	}
+}
+
+// A client whose token can read documents, but not list mounts
+type forbiddenMountsClient struct {
+	vault.MockClient
+}
+
+func (c *forbiddenMountsClient) ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error) {
+	return nil, errors.New("Error making API request.\n\nCode: 403. Errors:\n\n* permission denied")
 }
 
-func TestIsSliceEquivalent(t *testing.T) {
-	tests := []struct {
-		name     string
-		valueA   interface{}
-		valueB   interface{}
-		expected bool
-	}{
-		{name: "equal str", valueA: "foo", valueB: "foo", expected: true},
-		{name: "equal str arr", valueA: []string{"foo"}, valueB: []string{"foo"}, expected: true},
-		{name: "equal str + array", valueA: "foo", valueB: []string{"foo"}, expected: true},
-
-		{name: "unequal str", valueA: "foo", valueB: "bar", expected: false},
-		{name: "unequal str arr", valueA: []string{"foo"}, valueB: []string{"bar"}, expected: false},
-		{name: "unequal str + str array", valueA: "foo", valueB: []string{"bar"}, expected: false},
-
-		{name: "equal int arr", valueA: []int{99}, valueB: []int{99}, expected: true},
-		{name: "unequal int arr", valueA: []int{99}, valueB: []int{1}, expected: false},
-		{name: "unequal int + int arr", valueA: []int{99}, valueB: 1, expected: false},
-
-		{name: "equal string + interface", valueA: "foo", valueB: []interface{}{"foo"}, expected: true},
-		{name: "unequal string + interface", valueA: "foo", valueB: []interface{}{"bar"}, expected: false},
-
-		{name: "empty interfaces", valueA: []interface{}{}, valueB: []interface{}{}, expected: true},
-		{name: "equal interfaces with values", valueA: []interface{}{"foo"}, valueB: []interface{}{"foo"}, expected: true},
-		{name: "unequal interfaces with values", valueA: []interface{}{"foo"}, valueB: []interface{}{"bar"}, expected: false},
-		{name: "unequal interfaces with str int", valueA: []interface{}{"foo"}, valueB: []interface{}{0}, expected: false},
-
-		{name: "one long slice", valueA: []int{1, 2}, valueB: "1", expected: false},
-		{name: "one long slice reverse", valueA: "1", valueB: []int{1, 2}, expected: false},
-
-		{name: "different sort order int", valueA: []int{1, 2}, valueB: []int{2, 1}, expected: true},
-		{name: "different sort order string", valueA: []string{"1", "2"}, valueB: []string{"2", "1"}, expected: true},
-
-		{name: "unsorted interfaces", valueA: []interface{}{"1", "2"}, valueB: []interface{}{"2", "1"}, expected: true},
-		{name: "empty string and str interface", valueA: "", valueB: []string{}, expected: true},
-		{name: "empty string and int interface", valueA: "", valueB: []int{}, expected: true},
-	}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			log.Printf("A: %+v, B: %+v", test.valueA, test.valueB)
-			rv := isSliceEquivalent(test.valueA, test.valueB)
-			if rv != test.expected {
-				t.Errorf("Test case %q failed. A: %+v, B: %+v. Expected %+v",
-					test.name, test.valueA, test.valueB, test.expected)
+func (c *forbiddenMountsClient) ListMounts(ctx context.Context) (map[string]*vaultApi.MountOutput, error) {
+	return nil, errors.New("Error making API request.\n\nCode: 403. Errors:\n\n* permission denied")
+}
+
+// without permission to list mounts, backend types are taken from the path
+func TestGeneric_isDocApplied_mountsForbidden(t *testing.T) {
+	tests := []vaultDocument{
+		{path: "pki/roles/web", data: map[string]interface{}{"allow_any_name": "true"}},
+		{path: "auth/aws/role/web", data: map[string]interface{}{"bound_region": "eu-west-1"}},
+	}
+	remote := map[string]interface{}{"allow_any_name": true, "bound_region": []interface{}{"eu-west-1"}}
+	for _, doc := range tests {
+		t.Run(doc.path, func(t *testing.T) {
+			client := &forbiddenMountsClient{vault.MockClient{ReturnSecret: &vaultApi.Secret{Data: remote}}}
+			gh, err := NewGeneric(client, PathHandlerConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			applied, err := gh.isDocApplied(context.Background(), doc)
+			if err != nil {
+				t.Fatalf("Error calling isDocApplied: %s", err)
+			}
+			if !applied {
+				t.Errorf("Expected document to be applied")
 			}
 		})
 	}
@@ -0,0 +1,451 @@
+package path_handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+/*
+	Identity handles entities and groups of the identity secrets engine, declared by name at
+	identity/entity/name/<name> and identity/group/name/<name>.
+
+	Vault refers to them by generated ids, which can't be known when the documents are written, so
+	the member_entity_ids and member_group_ids of a group are given as names, and resolved to ids
+	when they are applied. Aliases are declared within the entity or group they belong to, with the
+	path of their auth method as the mount_accessor, which is likewise resolved to its accessor.
+*/
+
+// Metadata set on each entity and group written by the Identity handler. Undeclared groups are
+// only removed if they have it, so groups created by other means are left alone.
+const (
+	ManagedMetadataKey   = "managed_by"
+	ManagedMetadataValue = "vaultsmith"
+)
+
+type Identity struct {
+	BaseHandler
+	mounts map[string]*vaultApi.AuthMount // live auth mounts, to resolve mount accessors
+	ids    map[string]string              // ids of declared entities and groups, keyed by kind/name
+}
+
+// An entity or group, as declared in a document
+type identityDocument struct {
+	kind       string // "entity" or "group"
+	name       string
+	path       string                 // API path, identity/<kind>/name/<name>
+	data       map[string]interface{} // to write to path, without aliases
+	aliases    []identityAlias
+	sourceFile string
+}
+
+type identityAlias struct {
+	Name string `json:"name"`
+	// path of the auth method, e.g. github, in a document; its accessor when written
+	MountAccessor string `json:"mount_accessor"`
+}
+
+func NewIdentityHandler(client vault.Vault, config PathHandlerConfig) (*Identity, error) {
+	return &Identity{
+		BaseHandler: BaseHandler{
+			name:   "Identity",
+			client: client,
+			config: config,
+			log: log.WithFields(log.Fields{
+				"handler": "Identity",
+			}),
+		},
+		ids: map[string]string{},
+	}, nil
+}
+
+// Render and parse the entities and groups in a file, without reference to Vault. Errors do not
+// include the path.
+func readIdentityDocuments(config PathHandlerConfig, path string, name string) (docs []identityDocument, err error) {
+	read, err := readDocuments(config, path, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range read {
+		parts := strings.Split(filepath.ToSlash(d.path), "/")
+		if len(parts) != 4 || parts[0] != "identity" || parts[2] != "name" ||
+			(parts[1] != "entity" && parts[1] != "group") {
+			return nil, fmt.Errorf("%s is not an entity or group, expected identity/entity/name/<name> "+
+				"or identity/group/name/<name>", d.path)
+		}
+		doc := identityDocument{
+			kind:       parts[1],
+			name:       parts[3],
+			path:       filepath.ToSlash(d.path),
+			data:       map[string]interface{}{},
+			sourceFile: d.sourceFile,
+		}
+		for k, v := range d.data {
+			doc.data[k] = v
+		}
+		if doc.aliases, err = readAliases(doc); err != nil {
+			return nil, err
+		}
+		if doc.data["metadata"], err = managedMetadata(doc.data["metadata"]); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// Remove the aliases from a document, returning them: entities may have a list of "aliases", and
+// external groups one "alias"
+func readAliases(doc identityDocument) (aliases []identityAlias, err error) {
+	field, value := "aliases", doc.data["aliases"]
+	if doc.kind == "group" {
+		field, value = "alias", doc.data["alias"]
+		if value != nil {
+			value = []interface{}{value}
+		}
+		if value != nil && doc.data["type"] != "external" {
+			return nil, fmt.Errorf("only external groups may have an alias")
+		}
+	}
+	if value == nil {
+		return nil, nil
+	}
+	delete(doc.data, field)
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(b, &aliases)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %s", field, err)
+	}
+	mounts := map[string]bool{}
+	for _, a := range aliases {
+		if a.Name == "" || a.MountAccessor == "" {
+			return nil, fmt.Errorf("%q must have a name and mount_accessor", field)
+		}
+		mount := strings.Trim(a.MountAccessor, "/")
+		if mounts[mount] {
+			return nil, fmt.Errorf("an entity may only have one alias for each auth method, "+
+				"but has several for %s", mount)
+		}
+		mounts[mount] = true
+	}
+	return aliases, nil
+}
+
+// Return metadata with the marker of entities and groups written by vaultsmith added
+func managedMetadata(metadata interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	switch m := metadata.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range m {
+			out[k] = v
+		}
+	default:
+		return nil, fmt.Errorf("metadata must be a map, not %T", metadata)
+	}
+	out[ManagedMetadataKey] = ManagedMetadataValue
+	return out, nil
+}
+
+func (ih *Identity) PutPoliciesFromDir(ctx context.Context, path string) error {
+	var entities, groups []identityDocument
+	err := filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error finding %s: %s", p, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+		docs, err := readIdentityDocuments(ih.config, p, f.Name())
+		if err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		for _, doc := range docs {
+			if doc.kind == "entity" {
+				entities = append(entities, doc)
+			} else {
+				groups = append(groups, doc)
+			}
+			// known, so may be referenced before it is written
+			ih.ids[doc.kind+"/"+doc.name] = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entities, func(i, j int) bool { return entities[i].name < entities[j].name })
+	for _, doc := range entities {
+		if err := ih.ensure(ctx, doc); err != nil {
+			return err
+		}
+	}
+	groups, err = orderGroups(groups)
+	if err != nil {
+		return err
+	}
+	for _, doc := range groups {
+		if err := ih.ensure(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return ih.removeUndeclaredGroups(ctx, groups)
+}
+
+// Order groups so that each is after the declared groups it has as members, which must exist
+// before their ids are known
+func orderGroups(groups []identityDocument) ([]identityDocument, error) {
+	byName := map[string]identityDocument{}
+	for _, g := range groups {
+		byName[g.name] = g
+	}
+	var names []string
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ordered []identityDocument
+	state := map[string]int{} // 1 while visiting its members, 2 once ordered
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("groups are members of each other: %s", strings.Join(append(chain, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		members, err := NormaliseStringList(byName[name].data["member_group_ids"])
+		if err != nil {
+			return fmt.Errorf("%s: could not parse \"member_group_ids\": %s", byName[name].path, err)
+		}
+		for _, m := range members.([]string) {
+			if _, ok := byName[m]; ok {
+				if err := visit(m, append(append([]string{}, chain...), name)); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = 2
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Ensure an entity or group, and its aliases, are present and consistent
+func (ih *Identity) ensure(ctx context.Context, doc identityDocument) error {
+	logger := ih.log.WithFields(log.Fields{
+		"path":       doc.path,
+		"sourceFile": doc.sourceFile,
+	})
+	data, err := ih.resolveMembers(ctx, doc.data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", doc.path, err)
+	}
+
+	current, err := ih.client.Read(ctx, doc.path)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %s", doc.path, err)
+	}
+	if current != nil && current.Data != nil && identityApplied(data, current.Data) {
+		logger.Debugf("Document already applied")
+	} else {
+		logger.Infof("Applying document")
+		written, err := ih.client.Write(ctx, doc.path, data)
+		if err != nil {
+			return fmt.Errorf("could not write %s: %s", doc.path, err)
+		}
+		if written != nil && written.Data != nil && written.Data["id"] != nil {
+			current = written
+		} else if current, err = ih.client.Read(ctx, doc.path); err != nil {
+			return fmt.Errorf("could not read %s: %s", doc.path, err)
+		}
+	}
+
+	id := ""
+	if current != nil && current.Data != nil {
+		id, _ = current.Data["id"].(string)
+	}
+	if id == "" {
+		// only in dry mode, where nothing is written
+		id = fmt.Sprintf("<id of %s %s>", doc.kind, doc.name)
+	}
+	ih.ids[doc.kind+"/"+doc.name] = id
+
+	var existing []interface{}
+	if current != nil && current.Data != nil {
+		if doc.kind == "entity" {
+			existing, _ = current.Data["aliases"].([]interface{})
+		} else if alias, ok := current.Data["alias"].(map[string]interface{}); ok {
+			existing = []interface{}{alias}
+		}
+	}
+	for _, alias := range doc.aliases {
+		if err := ih.ensureAlias(ctx, doc, id, alias, existing); err != nil {
+			return fmt.Errorf("%s: %s", doc.path, err)
+		}
+	}
+	return nil
+}
+
+// Return a copy of a group with the names in member_entity_ids and member_group_ids replaced by
+// their ids
+func (ih *Identity) resolveMembers(ctx context.Context, data map[string]interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for k, v := range data {
+		out[k] = v
+	}
+	for field, kind := range map[string]string{"member_entity_ids": "entity", "member_group_ids": "group"} {
+		if data[field] == nil {
+			continue
+		}
+		names, err := NormaliseStringList(data[field])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %q: %s", field, err)
+		}
+		ids := []interface{}{}
+		for _, name := range names.([]string) {
+			id, err := ih.resolveID(ctx, kind, name)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		out[field] = ids
+	}
+	return out, nil
+}
+
+// Return the id of an entity or group by name, from those already applied or from Vault
+func (ih *Identity) resolveID(ctx context.Context, kind string, name string) (string, error) {
+	if id := ih.ids[kind+"/"+name]; id != "" {
+		return id, nil
+	}
+	secret, err := ih.client.Read(ctx, "identity/"+kind+"/name/"+name)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s %s: %s", kind, name, err)
+	}
+	if secret == nil || secret.Data == nil || secret.Data["id"] == nil {
+		return "", fmt.Errorf("there is no %s named %q", kind, name)
+	}
+	return fmt.Sprint(secret.Data["id"]), nil
+}
+
+// Return the accessor of the auth method mounted at path, see accessorOf
+func (ih *Identity) resolveAccessor(ctx context.Context, path string) (string, error) {
+	if ih.mounts == nil {
+		mounts, err := ih.client.ListAuth(ctx)
+		if err != nil {
+			return "", fmt.Errorf("could not list auth mounts: %s", err)
+		}
+		ih.mounts = mounts
+	}
+	return accessorOf(ih.mounts, ih.config.DocumentPath, path, ih.config.Dry)
+}
+
+// Ensure an alias of an entity or group exists, unless one with the same name and auth method is
+// among its existing aliases. An existing alias is updated in place if it is for the same auth
+// method, or belongs to a group, as an entity has one alias for each auth method and a group only
+// one alias. Aliases which are no longer declared are left alone.
+func (ih *Identity) ensureAlias(ctx context.Context, doc identityDocument, id string, alias identityAlias, existing []interface{}) error {
+	accessor, err := ih.resolveAccessor(ctx, alias.MountAccessor)
+	if err != nil {
+		return err
+	}
+	aliasPath := "identity/" + doc.kind + "-alias"
+	for _, e := range existing {
+		m, _ := e.(map[string]interface{})
+		if m["name"] == alias.Name && m["mount_accessor"] == accessor {
+			return nil
+		}
+		aliasID, _ := m["id"].(string)
+		if aliasID != "" && (doc.kind == "group" || m["mount_accessor"] == accessor) {
+			aliasPath = fmt.Sprintf("identity/%s-alias/id/%s", doc.kind, aliasID)
+		}
+	}
+	ih.log.WithFields(log.Fields{"path": doc.path, "alias": alias.Name}).Info("Applying alias")
+	_, err = ih.client.Write(ctx, aliasPath, map[string]interface{}{
+		"name":           alias.Name,
+		"mount_accessor": accessor,
+		"canonical_id":   id,
+	})
+	if err != nil {
+		return fmt.Errorf("could not write alias %s: %s", alias.Name, err)
+	}
+	return nil
+}
+
+// true if every declared field is present and equivalent in the current entity or group
+func identityApplied(declared map[string]interface{}, current map[string]interface{}) bool {
+	for k, v := range declared {
+		if equivalent, _ := valuesEquivalent("identity", k, v, current[k]); !equivalent {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove the groups written by vaultsmith which are no longer declared, if the document tree
+// declares groups at all. Groups without the managed metadata are left alone.
+func (ih *Identity) removeUndeclaredGroups(ctx context.Context, declared []identityDocument) error {
+	if _, err := os.Stat(filepath.Join(ih.config.DocumentPath, "identity", "group", "name")); err != nil {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, doc := range declared {
+		names[doc.name] = true
+	}
+	secret, err := ih.client.List(ctx, "identity/group/name")
+	if err != nil {
+		return fmt.Errorf("could not list groups: %s", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		name, _ := k.(string)
+		if name == "" || names[name] {
+			continue
+		}
+		path := "identity/group/name/" + name
+		group, err := ih.client.Read(ctx, path)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %s", path, err)
+		}
+		if group == nil || group.Data == nil {
+			continue
+		}
+		logger := ih.log.WithFields(log.Fields{"path": path})
+		if metadata, _ := group.Data["metadata"].(map[string]interface{}); metadata[ManagedMetadataKey] != ManagedMetadataValue {
+			logger.Debug("Not removing group, it was not created by vaultsmith")
+			continue
+		}
+		logger.Info("Removing group")
+		if _, err := ih.client.Delete(ctx, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
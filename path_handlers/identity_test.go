@@ -0,0 +1,273 @@
+package path_handlers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+// An in-memory identity secrets engine, which generates ids as "<kind>-<name>"
+type identityClient struct {
+	vault.MockClient
+	docs   map[string]map[string]interface{}
+	writes []string
+}
+
+func (c *identityClient) ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error) {
+	return map[string]*vaultApi.AuthMount{"github/": {Type: "github", Accessor: "auth_github_123"}}, nil
+}
+
+func (c *identityClient) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if d, ok := c.docs[path]; ok {
+		return &vaultApi.Secret{Data: d}, nil
+	}
+	return nil, nil
+}
+
+func (c *identityClient) List(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	var keys []interface{}
+	for p := range c.docs {
+		if strings.HasPrefix(p, path+"/") {
+			keys = append(keys, strings.TrimPrefix(p, path+"/"))
+		}
+	}
+	return &vaultApi.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+func (c *identityClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	c.writes = append(c.writes, path)
+	parts := strings.Split(path, "/")
+	if strings.HasSuffix(parts[1], "-alias") && len(parts) == 4 {
+		// update an alias by id
+		for _, d := range c.docs {
+			aliases, _ := d["aliases"].([]interface{})
+			if a, ok := d["alias"]; ok {
+				aliases = append(aliases, a)
+			}
+			for _, a := range aliases {
+				if a := a.(map[string]interface{}); a["id"] == parts[3] {
+					for k, v := range data {
+						a[k] = v
+					}
+				}
+			}
+		}
+		return nil, nil
+	}
+	if strings.HasSuffix(parts[1], "-alias") {
+		// add the alias to its entity or group
+		kind := strings.TrimSuffix(parts[1], "-alias")
+		data["id"] = "alias-" + fmt.Sprint(data["name"])
+		for _, d := range c.docs {
+			if d["id"] == data["canonical_id"] {
+				if kind == "entity" {
+					aliases, _ := d["aliases"].([]interface{})
+					d["aliases"] = append(aliases, data)
+				} else {
+					d["alias"] = data
+				}
+			}
+		}
+		return nil, nil
+	}
+	doc := map[string]interface{}{"id": parts[1] + "-" + parts[3]}
+	if existing, ok := c.docs[path]; ok {
+		doc = existing
+	}
+	for k, v := range data {
+		doc[k] = v
+	}
+	c.docs[path] = doc
+	return nil, nil
+}
+
+func (c *identityClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	c.writes = append(c.writes, "delete "+path)
+	delete(c.docs, path)
+	return nil, nil
+}
+
+func writeDocuments(t *testing.T, files map[string]string) string {
+	docPath := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(docPath, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return docPath
+}
+
+func TestIdentity_PutPoliciesFromDir(t *testing.T) {
+	docPath := writeDocuments(t, map[string]string{
+		"identity/entity/name/alice.json": `{"policies": ["reader"], "aliases": [{"name": "alice", "mount_accessor": "github"}]}`,
+		"identity/group/name/devs.json":   `{"policies": "writer", "member_entity_ids": ["alice"], "member_group_ids": ["leads"]}`,
+		"identity/group/name/leads.json":  `{"member_entity_ids": "alice,bob"}`,
+	})
+	client := &identityClient{docs: map[string]map[string]interface{}{
+		"identity/entity/name/bob": {"id": "entity-bob"},
+		"identity/group/name/old": {"id": "group-old",
+			"metadata": map[string]interface{}{ManagedMetadataKey: ManagedMetadataValue}},
+		"identity/group/name/manual": {"id": "group-manual"},
+	}}
+	apply := func() {
+		h, _ := NewIdentityHandler(client, PathHandlerConfig{DocumentPath: docPath})
+		if err := h.PutPoliciesFromDir(context.Background(), filepath.Join(docPath, "identity")); err != nil {
+			t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+		}
+	}
+	apply()
+
+	expWrites := []string{
+		"identity/entity/name/alice",
+		"identity/entity-alias",
+		"identity/group/name/leads",
+		"identity/group/name/devs",
+		"delete identity/group/name/old",
+	}
+	if !reflect.DeepEqual(client.writes, expWrites) {
+		t.Errorf("Expected writes %v, got %v", expWrites, client.writes)
+	}
+	devs := client.docs["identity/group/name/devs"]
+	if !reflect.DeepEqual(devs["member_entity_ids"], []interface{}{"entity-alice"}) ||
+		!reflect.DeepEqual(devs["member_group_ids"], []interface{}{"group-leads"}) {
+		t.Errorf("Expected member names to be resolved to ids, got %+v", devs)
+	}
+	leads := client.docs["identity/group/name/leads"]
+	if !reflect.DeepEqual(leads["member_entity_ids"], []interface{}{"entity-alice", "entity-bob"}) {
+		t.Errorf("Expected members already in Vault to be resolved, got %+v", leads)
+	}
+	alias := client.docs["identity/entity/name/alice"]["aliases"].([]interface{})[0].(map[string]interface{})
+	if alias["mount_accessor"] != "auth_github_123" || alias["canonical_id"] != "entity-alice" {
+		t.Errorf("Expected the alias to refer to the accessor and entity, got %+v", alias)
+	}
+	if _, ok := client.docs["identity/group/name/manual"]; !ok {
+		t.Errorf("Expected a group not created by vaultsmith to be kept")
+	}
+
+	// already applied
+	client.writes = nil
+	apply()
+	if len(client.writes) != 0 {
+		t.Errorf("Expected no writes once applied, got %v", client.writes)
+	}
+}
+
+func TestIdentity_updateAlias(t *testing.T) {
+	docPath := writeDocuments(t, map[string]string{
+		"identity/entity/name/carol.json": `{"aliases": [{"name": "carol", "mount_accessor": "github"}]}`,
+	})
+	client := &identityClient{docs: map[string]map[string]interface{}{
+		"identity/entity/name/carol": {
+			"id":       "entity-carol",
+			"metadata": map[string]interface{}{ManagedMetadataKey: ManagedMetadataValue},
+			"aliases": []interface{}{
+				map[string]interface{}{"id": "alias-old", "name": "carol-old", "mount_accessor": "auth_github_123"},
+				map[string]interface{}{"id": "alias-ldap", "name": "carol", "mount_accessor": "auth_ldap_456"},
+			},
+		},
+	}}
+	h, _ := NewIdentityHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	if err := h.PutPoliciesFromDir(context.Background(), filepath.Join(docPath, "identity")); err != nil {
+		t.Fatalf("Error calling PutPoliciesFromDir: %s", err)
+	}
+	if exp := []string{"identity/entity-alias/id/alias-old"}; !reflect.DeepEqual(client.writes, exp) {
+		t.Errorf("Expected writes %v, got %v", exp, client.writes)
+	}
+	aliases := client.docs["identity/entity/name/carol"]["aliases"].([]interface{})
+	if len(aliases) != 2 || aliases[0].(map[string]interface{})["name"] != "carol" {
+		t.Errorf("Expected the alias for the same auth method to be renamed, got %+v", aliases)
+	}
+}
+
+func TestIdentity_unknownMember(t *testing.T) {
+	docPath := writeDocuments(t, map[string]string{
+		"identity/group/name/devs.json": `{"member_entity_ids": ["nobody"]}`,
+	})
+	client := &identityClient{docs: map[string]map[string]interface{}{}}
+	h, _ := NewIdentityHandler(client, PathHandlerConfig{DocumentPath: docPath})
+	err := h.PutPoliciesFromDir(context.Background(), filepath.Join(docPath, "identity"))
+	if err == nil || !strings.Contains(err.Error(), `no entity named "nobody"`) {
+		t.Errorf("Expected an error for an unknown member, got %v", err)
+	}
+}
+
+func TestOrderGroups(t *testing.T) {
+	group := func(name string, members ...string) identityDocument {
+		return identityDocument{kind: "group", name: name, path: "identity/group/name/" + name,
+			data: map[string]interface{}{"member_group_ids": members}}
+	}
+	ordered, err := orderGroups([]identityDocument{group("a", "b", "external"), group("b", "c"), group("c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, g := range ordered {
+		names = append(names, g.name)
+	}
+	if !reflect.DeepEqual(names, []string{"c", "b", "a"}) {
+		t.Errorf("Expected members first, got %v", names)
+	}
+
+	_, err = orderGroups([]identityDocument{group("a", "b"), group("b", "a")})
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("Expected a cycle error, got %v", err)
+	}
+}
+
+func TestRenderIdentity(t *testing.T) {
+	docPath := writeDocuments(t, map[string]string{
+		"identity/entity/name/alice.json":    `{"aliases": [{"name": "alice", "mount_accessor": "github"}]}`,
+		"identity/group/name/ldap.json":      `{"type": "external", "alias": {"name": "cn=devs", "mount_accessor": "ldap"}}`,
+		"identity/group/name/bad.json":       `{"alias": {"name": "devs", "mount_accessor": "ldap"}}`,
+		"identity/group/name/meta.json":      `{"metadata": "team=devs"}`,
+		"identity/group/name/devs/x.json":    `{}`,
+		"identity/entity/name/nameless.json": `{"aliases": [{"name": "x"}]}`,
+		"identity/entity/name/twice.json":    `{"aliases": [{"name": "a", "mount_accessor": "github"}, {"name": "b", "mount_accessor": "github/"}]}`,
+	})
+	config := PathHandlerConfig{DocumentPath: docPath}
+	docs, errs := renderIdentity(context.Background(), config, filepath.Join(docPath, "identity"))
+
+	var paths []string
+	for _, d := range docs {
+		paths = append(paths, d.Path)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, []string{"identity/entity/name/alice", "identity/group/name/ldap"}) {
+		t.Errorf("Unexpected documents %v", paths)
+	}
+	alice := docs[0].Data.(map[string]interface{})
+	if docs[0].Path != "identity/entity/name/alice" {
+		alice = docs[1].Data.(map[string]interface{})
+	}
+	if alice["aliases"] != nil || alice["metadata"].(map[string]interface{})[ManagedMetadataKey] != ManagedMetadataValue {
+		t.Errorf("Expected aliases to be removed and the managed metadata added, got %+v", alice)
+	}
+
+	var problems []string
+	for _, e := range errs {
+		problems = append(problems, e.Error())
+	}
+	sort.Strings(problems)
+	exp := []string{
+		"identity/entity/name/nameless.json: \"aliases\" must have a name and mount_accessor",
+		"identity/entity/name/twice.json: an entity may only have one alias for each auth method, but has several for github",
+		"identity/group/name/bad.json: only external groups may have an alias",
+		"identity/group/name/devs/x.json: identity/group/name/devs/x is not an entity or group, " +
+			"expected identity/entity/name/<name> or identity/group/name/<name>",
+		"identity/group/name/meta.json: metadata must be a map, not string",
+	}
+	if !reflect.DeepEqual(problems, exp) {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(exp, "\n"), strings.Join(problems, "\n"))
+	}
+}
@@ -0,0 +1,363 @@
+package path_handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Normaliser converts a value into a canonical form, so that values Vault considers equivalent
+// compare as equal. For example, a ttl of "1h" in a document is returned by Vault as 3600.
+type Normaliser func(value interface{}) (interface{}, error)
+
+// Normalisers keyed by backend type, then field name. The "*" backend applies to every backend,
+// unless overridden by a backend specific entry. Fields without a normaliser use NormaliseDuration
+// if their name ends in _ttl, otherwise NormaliseDefault.
+var normalisers = map[string]map[string]Normaliser{
+	"*": {
+		"default_lease_ttl":       NormaliseDuration,
+		"explicit_max_ttl":        NormaliseDuration,
+		"max_lease_ttl":           NormaliseDuration,
+		"max_ttl":                 NormaliseDuration,
+		"metadata":                NormaliseMap,
+		"period":                  NormaliseDuration,
+		"policies":                NormaliseStringList,
+		"secret_id_ttl":           NormaliseDuration,
+		"token_bound_cidrs":       NormaliseCIDRList,
+		"token_explicit_max_ttl":  NormaliseDuration,
+		"token_max_ttl":           NormaliseDuration,
+		"token_no_default_policy": NormaliseBool,
+		"token_period":            NormaliseDuration,
+		"token_policies":          NormaliseStringList,
+		"token_ttl":               NormaliseDuration,
+		"ttl":                     NormaliseDuration,
+	},
+	"approle": {
+		"bind_secret_id":        NormaliseBool,
+		"bound_cidr_list":       NormaliseCIDRList,
+		"secret_id_bound_cidrs": NormaliseCIDRList,
+	},
+	"aws": {
+		"allow_instance_migration":       NormaliseBool,
+		"bound_account_id":               NormaliseStringList,
+		"bound_ami_id":                   NormaliseStringList,
+		"bound_ec2_instance_id":          NormaliseStringList,
+		"bound_iam_instance_profile_arn": NormaliseStringList,
+		"bound_iam_principal_arn":        NormaliseStringList,
+		"bound_iam_role_arn":             NormaliseStringList,
+		"bound_region":                   NormaliseStringList,
+		"bound_subnet_id":                NormaliseStringList,
+		"bound_vpc_id":                   NormaliseStringList,
+		"disallow_reauthentication":      NormaliseBool,
+		"resolve_aws_unique_ids":         NormaliseBool,
+	},
+	"identity": {
+		"disabled":          NormaliseBool,
+		"member_entity_ids": NormaliseStringList,
+		"member_group_ids":  NormaliseStringList,
+	},
+	"kubernetes": {
+		"bound_service_account_names":      NormaliseStringList,
+		"bound_service_account_namespaces": NormaliseStringList,
+	},
+	"pki": {
+		"allow_any_name":        NormaliseBool,
+		"allow_bare_domains":    NormaliseBool,
+		"allow_glob_domains":    NormaliseBool,
+		"allow_ip_sans":         NormaliseBool,
+		"allow_localhost":       NormaliseBool,
+		"allow_subdomains":      NormaliseBool,
+		"allowed_domains":       NormaliseStringList,
+		"allowed_other_sans":    NormaliseStringList,
+		"allowed_uri_sans":      NormaliseStringList,
+		"client_flag":           NormaliseBool,
+		"code_signing_flag":     NormaliseBool,
+		"country":               NormaliseStringList,
+		"email_protection_flag": NormaliseBool,
+		"enforce_hostnames":     NormaliseBool,
+		"ext_key_usage":         NormaliseStringList,
+		"generate_lease":        NormaliseBool,
+		"key_usage":             NormaliseStringList,
+		"locality":              NormaliseStringList,
+		"no_store":              NormaliseBool,
+		"organization":          NormaliseStringList,
+		"ou":                    NormaliseStringList,
+		"postal_code":           NormaliseStringList,
+		"province":              NormaliseStringList,
+		"require_cn":            NormaliseBool,
+		"server_flag":           NormaliseBool,
+		"street_address":        NormaliseStringList,
+		"use_csr_common_name":   NormaliseBool,
+		"use_csr_sans":          NormaliseBool,
+	},
+}
+
+// RegisterNormaliser declares how a field of a backend type is compared. Use "*" as the backend
+// to apply to all backends. Not safe to call concurrently with a run.
+func RegisterNormaliser(backend string, field string, n Normaliser) {
+	if _, ok := normalisers[backend]; !ok {
+		normalisers[backend] = map[string]Normaliser{}
+	}
+	normalisers[backend][field] = n
+}
+
+// Return the normaliser for the field of the given backend type
+func normaliserFor(backend string, field string) Normaliser {
+	if n, ok := normalisers[backend][field]; ok {
+		return n
+	}
+	if n, ok := normalisers["*"][field]; ok {
+		return n
+	}
+	if strings.HasSuffix(field, "_ttl") {
+		return NormaliseDuration
+	}
+	return NormaliseDefault
+}
+
+// Determine whether two values of a field are equivalent, as far as Vault is concerned
+func valuesEquivalent(backend string, field string, a interface{}, b interface{}) (bool, error) {
+	if reflect.DeepEqual(a, b) {
+		return true, nil
+	}
+	n := normaliserFor(backend, field)
+	na, err := n(a)
+	if err != nil {
+		return false, fmt.Errorf("could not normalise %q value %+v: %s", field, a, err)
+	}
+	nb, err := n(b)
+	if err != nil {
+		return false, fmt.Errorf("could not normalise %q value %+v: %s", field, b, err)
+	}
+	if reflect.DeepEqual(na, nb) {
+		return true, nil
+	}
+	return listEquivalent(na, nb), nil
+}
+
+// Vault returns many fields as lists, whether or not they were written as one, and in no
+// particular order. So where either value is a list they are compared as unordered lists, with a
+// scalar as a list of one element and an empty string as an empty list, e.g. "a" is equivalent to
+// ["a"], and "" to [].
+func listEquivalent(a interface{}, b interface{}) bool {
+	la, aIsList := asList(a)
+	lb, bIsList := asList(b)
+	if !aIsList && !bIsList {
+		return false
+	}
+	return reflect.DeepEqual(sortedByString(la), sortedByString(lb))
+}
+
+func asList(value interface{}) (list []interface{}, isList bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case string:
+		if v == "" {
+			return []interface{}{}, false
+		}
+	}
+	return []interface{}{value}, false
+}
+
+// Return a sorted copy of a list, ordered by the string form of its elements
+func sortedByString(list []interface{}) []interface{} {
+	out := append([]interface{}{}, list...)
+	sort.SliceStable(out, func(i, j int) bool { return fmt.Sprint(out[i]) < fmt.Sprint(out[j]) })
+	return out
+}
+
+// NormaliseDefault converts numbers to a common representation, as documents are parsed as
+// float64 and Vault responses as json.Number. Slices and maps are normalised element-wise.
+func NormaliseDefault(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			n, err := NormaliseDefault(v[i])
+			if err != nil {
+				return nil, err
+			}
+			out[i] = n
+		}
+		return out, nil
+	case []string:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = v[i]
+		}
+		return out, nil
+	case []int:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = strconv.Itoa(v[i])
+		}
+		return out, nil
+	case map[string]interface{}:
+		return NormaliseMap(v)
+	default:
+		return value, nil
+	}
+}
+
+// NormaliseDuration converts durations to seconds. Strings such as "1h" are parsed as durations,
+// and numbers are assumed to be in seconds.
+func NormaliseDuration(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return int64(0), nil
+		}
+		if d, err := time.ParseDuration(v); err == nil {
+			return int64(d / time.Second), nil
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q can't be parsed as duration", v)
+		}
+		return i, nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %+v as json number: %s", v, err)
+		}
+		return i, nil
+	case float64:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case nil:
+		return int64(0), nil
+	default:
+		return nil, fmt.Errorf("type %T not handled", value)
+	}
+}
+
+// NormaliseStringList converts a comma separated string, or a list, to a sorted list of strings.
+// Vault accepts either form for list fields, and the order is not significant.
+func NormaliseStringList(value interface{}) (interface{}, error) {
+	out := []string{}
+	switch v := value.(type) {
+	case nil:
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	case []string:
+		out = append(out, v...)
+	case []int:
+		for _, i := range v {
+			out = append(out, strconv.Itoa(i))
+		}
+	case []interface{}:
+		for _, i := range v {
+			n, err := NormaliseDefault(i)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := n.(string)
+			if !ok {
+				return nil, fmt.Errorf("list element %+v (type %T) is not a string", i, i)
+			}
+			out = append(out, s)
+		}
+	default:
+		return nil, fmt.Errorf("type %T not handled", value)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// NormaliseBool converts booleans, and their string representations, to a bool
+func NormaliseBool(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q can't be parsed as bool", v)
+		}
+		return b, nil
+	case nil:
+		return false, nil
+	default:
+		return nil, fmt.Errorf("type %T not handled", value)
+	}
+}
+
+// NormaliseCIDRList converts a list of CIDR blocks to a sorted list in canonical form. Single
+// addresses are treated as a /32 (or /128 for IPv6).
+func NormaliseCIDRList(value interface{}) (interface{}, error) {
+	list, err := NormaliseStringList(value)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, s := range list.([]string) {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR block", s)
+			}
+			if ip.To4() != nil {
+				s = s + "/32"
+			} else {
+				s = s + "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ipNet.String())
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// NormaliseMap normalises the values of a map. A nil or empty string value is an empty map.
+func NormaliseMap(value interface{}) (interface{}, error) {
+	out := map[string]interface{}{}
+	switch v := value.(type) {
+	case nil:
+	case string:
+		if v != "" {
+			return nil, fmt.Errorf("%q is not a map", v)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			n, err := NormaliseDefault(v[k])
+			if err != nil {
+				return nil, err
+			}
+			out[k] = n
+		}
+	case map[string]string:
+		for k := range v {
+			out[k] = v[k]
+		}
+	default:
+		return nil, fmt.Errorf("type %T not handled", value)
+	}
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package path_handlers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValuesEquivalent(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  string
+		field    string
+		valueA   interface{}
+		valueB   interface{}
+		expected bool
+	}{
+		{name: "equal str", field: "foo", valueA: "foo", valueB: "foo", expected: true},
+		{name: "unequal str", field: "foo", valueA: "foo", valueB: "bar", expected: false},
+		{name: "float + json.Number", field: "num_uses", valueA: float64(10), valueB: json.Number("10"), expected: true},
+		{name: "unequal float + json.Number", field: "num_uses", valueA: float64(10), valueB: json.Number("1"), expected: false},
+		{name: "nested map", field: "foo", valueA: map[string]interface{}{"a": float64(1)}, valueB: map[string]interface{}{"a": json.Number("1")}, expected: true},
+
+		{name: "ttl strings", field: "ttl", valueA: "1m", valueB: "1m", expected: true},
+		{name: "ttl ints", field: "ttl", valueA: 60, valueB: 60, expected: true},
+		{name: "ttl string + int", field: "ttl", valueA: "1m", valueB: 60, expected: true},
+		{name: "ttl unequal ints", field: "ttl", valueA: 10, valueB: 20, expected: false},
+		{name: "ttl unequal strings", field: "ttl", valueA: "1m", valueB: "2m", expected: false},
+		{name: "ttl unequal string + int", field: "ttl", valueA: "1m", valueB: 120, expected: false},
+		{name: "ttl json.Number + string", field: "ttl", valueA: json.Number("60"), valueB: "1m", expected: true},
+		{name: "ttl seconds string + json.Number", field: "max_ttl", valueA: "0", valueB: json.Number("0"), expected: true},
+		{name: "ttl suffix", field: "default_ttl", valueA: "1h", valueB: json.Number("3600"), expected: true},
+		{name: "ttl suffix sts", field: "max_sts_ttl", valueA: "1h", valueB: 3600, expected: true},
+		{name: "ttl suffix unequal", field: "default_sts_ttl", valueA: "1h", valueB: 60, expected: false},
+		{name: "ttl-like field without suffix", field: "ttl_unit", valueA: "1m", valueB: 60, expected: false},
+
+		{name: "list str + array", field: "policies", valueA: "foo", valueB: []string{"foo"}, expected: true},
+		{name: "list str + interface", field: "policies", valueA: "foo", valueB: []interface{}{"foo"}, expected: true},
+		{name: "list unequal str + interface", field: "policies", valueA: "foo", valueB: []interface{}{"bar"}, expected: false},
+		{name: "list comma separated", field: "policies", valueA: "foo, bar", valueB: []interface{}{"bar", "foo"}, expected: true},
+		{name: "list unsorted interfaces", field: "policies", valueA: []interface{}{"1", "2"}, valueB: []interface{}{"2", "1"}, expected: true},
+		{name: "list different sort order int", field: "policies", valueA: []int{1, 2}, valueB: []int{2, 1}, expected: true},
+		{name: "list one long slice", field: "policies", valueA: []int{1, 2}, valueB: "1", expected: false},
+		{name: "list empty string", field: "policies", valueA: "", valueB: []interface{}{}, expected: true},
+		{name: "undeclared str + single element list", field: "allowed_roles", valueA: "foo", valueB: []interface{}{"foo"}, expected: true},
+		{name: "undeclared str + str array", field: "allowed_roles", valueA: "foo", valueB: []string{"foo"}, expected: true},
+		{name: "undeclared unequal str + str array", field: "allowed_roles", valueA: "foo", valueB: []string{"bar"}, expected: false},
+		{name: "undeclared number + single element list", field: "ports", valueA: float64(1), valueB: []interface{}{json.Number("1")}, expected: true},
+		{name: "undeclared str + longer list", field: "allowed_roles", valueA: "foo", valueB: []interface{}{"foo", "bar"}, expected: false},
+		{name: "undeclared longer list + str", field: "allowed_roles", valueA: "1", valueB: []int{1, 2}, expected: false},
+		{name: "undeclared equal int arrays", field: "ports", valueA: []int{99}, valueB: []int{99}, expected: true},
+		{name: "undeclared unequal int + int array", field: "ports", valueA: []int{99}, valueB: 1, expected: false},
+		{name: "undeclared unequal interfaces with str int", field: "allowed_roles", valueA: []interface{}{"foo"}, valueB: []interface{}{0}, expected: false},
+		{name: "undeclared empty interfaces", field: "allowed_roles", valueA: []interface{}{}, valueB: []interface{}{}, expected: true},
+		{name: "undeclared different sort order int", field: "ports", valueA: []int{1, 2}, valueB: []int{2, 1}, expected: true},
+		{name: "undeclared different sort order string", field: "allowed_roles", valueA: []string{"1", "2"}, valueB: []string{"2", "1"}, expected: true},
+		{name: "undeclared unsorted interfaces", field: "allowed_roles", valueA: []interface{}{"1", "2"}, valueB: []interface{}{"2", "1"}, expected: true},
+		{name: "undeclared empty string + str array", field: "allowed_roles", valueA: "", valueB: []string{}, expected: true},
+		{name: "undeclared empty string + int array", field: "ports", valueA: "", valueB: []int{}, expected: true},
+		{name: "undeclared empty string + str", field: "allowed_roles", valueA: "", valueB: "foo", expected: false},
+		{name: "list str int interfaces", field: "policies", valueA: []interface{}{"0"}, valueB: []interface{}{json.Number("0")}, expected: true},
+
+		{name: "bool string", backend: "approle", field: "bind_secret_id", valueA: "true", valueB: true, expected: true},
+		{name: "bool unequal", backend: "approle", field: "bind_secret_id", valueA: "false", valueB: true, expected: false},
+		{name: "bool other backend", backend: "aws", field: "bind_secret_id", valueA: "true", valueB: true, expected: false},
+
+		{name: "cidr single address", field: "token_bound_cidrs", valueA: "10.0.0.1", valueB: []interface{}{"10.0.0.1/32"}, expected: true},
+		{name: "cidr canonical form", field: "token_bound_cidrs", valueA: "10.0.0.1/8,192.168.0.0/16", valueB: []interface{}{"192.168.0.0/16", "10.0.0.0/8"}, expected: true},
+		{name: "cidr unequal", field: "token_bound_cidrs", valueA: "10.0.0.0/16", valueB: []interface{}{"10.0.0.0/8"}, expected: false},
+
+		{name: "map empty", field: "metadata", valueA: map[string]interface{}{}, valueB: nil, expected: true},
+		{name: "map values", field: "metadata", valueA: map[string]interface{}{"team": "foo"}, valueB: map[string]interface{}{"team": "foo"}, expected: true},
+
+		{name: "kubernetes list", backend: "kubernetes", field: "bound_service_account_names", valueA: "a,b", valueB: []interface{}{"b", "a"}, expected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rv, _ := valuesEquivalent(test.backend, test.field, test.valueA, test.valueB)
+			if rv != test.expected {
+				t.Errorf("Test case %q failed. A: %+v, B: %+v. Expected %+v",
+					test.name, test.valueA, test.valueB, test.expected)
+			}
+		})
+	}
+}
+
+func TestValuesEquivalent_error(t *testing.T) {
+	_, err := valuesEquivalent("", "ttl", "forever", json.Number("60"))
+	if err == nil {
+		t.Error("Expected error for unparseable duration, got nil")
+	}
+}
+
+func TestRegisterNormaliser(t *testing.T) {
+	RegisterNormaliser("test_backend", "test_field", NormaliseBool)
+	defer delete(normalisers, "test_backend")
+
+	rv, err := valuesEquivalent("test_backend", "test_field", "true", true)
+	if err != nil {
+		t.Errorf("Error comparing values: %s", err)
+	}
+	if !rv {
+		t.Error("Expected registered normaliser to be used")
+	}
+}
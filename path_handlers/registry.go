@@ -0,0 +1,92 @@
+package path_handlers
+
+import (
+	"context"
+
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+// A HandlerFactory creates the PathHandler for a directory of the document tree
+type HandlerFactory func(ctx context.Context, client vault.Vault, config PathHandlerConfig) (PathHandler, error)
+
+// A Registration maps a directory of the document tree to the handler which processes it
+type Registration struct {
+	Path    string         // relative to the document root. May be a pattern, as per path.Match
+	Factory HandlerFactory // creates the handler. Ignored if Skip is set
+	Skip    bool           // do not process this path, or any path beneath it
+	// paths of the document tree to apply before this one, in addition to those inferred from
+	// mount paths. See ConfigWalker in the internal package
+	DependsOn []string
+	// renders documents without Vault, for vaultsmith render. Also used to check them for
+	// vaultsmith validate. If nil, the path is not rendered
+	Render DirRenderer
+	// checks documents without Vault, for vaultsmith validate, if there is no Render. If both are
+	// nil, the path is not validated
+	Validate DirValidator
+}
+
+// The fallback registration path, for directories without a more specific handler
+const GenericPath = "*"
+
+// A Registry declares which handler processes each part of the document tree
+type Registry struct {
+	registrations []Registration
+}
+
+func NewRegistry(registrations ...Registration) *Registry {
+	r := &Registry{}
+	for _, reg := range registrations {
+		r.Register(reg)
+	}
+	return r
+}
+
+// Register a handler for a path, replacing any existing registration for the same path
+func (r *Registry) Register(reg Registration) {
+	for i := range r.registrations {
+		if r.registrations[i].Path == reg.Path {
+			r.registrations[i] = reg
+			return
+		}
+	}
+	r.registrations = append(r.registrations, reg)
+}
+
+// Return all registrations, in the order they were registered
+func (r *Registry) Registrations() []Registration {
+	return append([]Registration{}, r.registrations...)
+}
+
+// Return a copy of the registry, which can be modified without affecting the original
+func (r *Registry) Copy() *Registry {
+	return NewRegistry(r.registrations...)
+}
+
+// DefaultRegistry returns the handlers built in to vaultsmith
+func DefaultRegistry() *Registry {
+	return NewRegistry(
+		Registration{Path: GenericPath, Factory: genericFactory, Render: renderGeneric},
+		// sys directories should never be generic, so skip at the top level
+		Registration{Path: "sys", Skip: true},
+		Registration{Path: "sys/auth", Factory: sysAuthFactory, Render: renderSysAuth},
+		Registration{Path: "sys/policy", Factory: sysPolicyFactory, Render: renderSysPolicy},
+		// aliases refer to auth methods by their accessors
+		Registration{Path: "identity", Factory: identityFactory, Render: renderIdentity, DependsOn: []string{"sys/auth"}},
+	)
+}
+
+func genericFactory(ctx context.Context, client vault.Vault, config PathHandlerConfig) (PathHandler, error) {
+	return NewGeneric(client, config)
+}
+
+func sysAuthFactory(ctx context.Context, client vault.Vault, config PathHandlerConfig) (PathHandler, error) {
+	return NewSysAuthHandler(ctx, client, config)
+}
+
+func sysPolicyFactory(ctx context.Context, client vault.Vault, config PathHandlerConfig) (PathHandler, error) {
+	return NewSysPolicyHandler(ctx, client, config)
+}
+
+func identityFactory(ctx context.Context, client vault.Vault, config PathHandlerConfig) (PathHandler, error) {
+	return NewIdentityHandler(client, config)
+}
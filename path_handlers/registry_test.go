@@ -0,0 +1,51 @@
+package path_handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/starlingbank/vaultsmith/vault"
+)
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry(
+		Registration{Path: "foo", DependsOn: []string{"sys/auth"}},
+		Registration{Path: "bar", Skip: true},
+	)
+	r.Register(Registration{Path: "foo", DependsOn: []string{"sys/mounts"}})
+
+	regs := r.Registrations()
+	if len(regs) != 2 {
+		t.Fatalf("Expected 2 registrations, got %d", len(regs))
+	}
+	if regs[0].Path != "foo" || regs[0].DependsOn[0] != "sys/mounts" {
+		t.Errorf("Expected registration for foo to be replaced, got %+v", regs[0])
+	}
+}
+
+func TestRegistry_Copy(t *testing.T) {
+	r := DefaultRegistry()
+	c := r.Copy()
+	c.Register(Registration{Path: "pki", Skip: true})
+
+	if len(c.Registrations()) != len(r.Registrations())+1 {
+		t.Errorf("Expected copy to have an extra registration")
+	}
+}
+
+func TestDefaultRegistry_factories(t *testing.T) {
+	client := &vault.MockClient{}
+	for _, reg := range DefaultRegistry().Registrations() {
+		if reg.Skip {
+			continue
+		}
+		h, err := reg.Factory(context.Background(), client, PathHandlerConfig{})
+		if err != nil {
+			t.Errorf("Error creating handler for %q: %s", reg.Path, err)
+			continue
+		}
+		if h.Name() == "" {
+			t.Errorf("Expected handler for %q to have a name", reg.Path)
+		}
+	}
+}
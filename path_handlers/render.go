@@ -0,0 +1,74 @@
+package path_handlers
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+// A document as it would be sent to Vault
+type RenderedDocument struct {
+	Path string      // Vault API path, e.g. auth/approle/role/foo
+	Data interface{} // the request body
+}
+
+// Renders the documents in a directory of the document tree as they would be sent to Vault,
+// without Vault. Problems are returned as for DirValidator, and the documents which could be
+// rendered are still returned.
+type DirRenderer func(ctx context.Context, config PathHandlerConfig, path string) ([]RenderedDocument, []error)
+
+func renderGeneric(ctx context.Context, config PathHandlerConfig, path string) (docs []RenderedDocument, errs []error) {
+	errs = validateFiles(ctx, config, path, func(p string, name string) error {
+		read, err := readDocuments(config, p, name)
+		for _, d := range read {
+			docs = append(docs, RenderedDocument{Path: filepath.ToSlash(d.path), Data: d.data})
+		}
+		return err
+	})
+	return docs, errs
+}
+
+func renderSysPolicy(ctx context.Context, config PathHandlerConfig, path string) (docs []RenderedDocument, errs []error) {
+	errs = validateFiles(ctx, config, path, func(p string, name string) error {
+		policies, err := readPolicies(config, p, name)
+		for _, policy := range policies {
+			docs = append(docs, RenderedDocument{
+				Path: "sys/policy/" + policy.Name,
+				Data: map[string]interface{}{"policy": policy.Policy},
+			})
+		}
+		return err
+	})
+	return docs, errs
+}
+
+func renderSysAuth(ctx context.Context, config PathHandlerConfig, path string) (docs []RenderedDocument, errs []error) {
+	errs = validateFiles(ctx, config, path, func(p string, name string) error {
+		sysAuthPath, enableOpts, err := readAuth(config, p)
+		if err != nil {
+			return err
+		}
+		if _, err = ConvertAuthConfig(enableOpts.Config); err != nil {
+			return err
+		}
+		docs = append(docs, RenderedDocument{
+			Path: "sys/auth/" + strings.TrimSuffix(sysAuthPath, "/"),
+			Data: enableOpts,
+		})
+		return nil
+	})
+	return docs, errs
+}
+
+// Renders entities and groups as they are declared, as their member names, aliases and mount
+// accessors are only resolved by Vault
+func renderIdentity(ctx context.Context, config PathHandlerConfig, path string) (docs []RenderedDocument, errs []error) {
+	errs = validateFiles(ctx, config, path, func(p string, name string) error {
+		read, err := readIdentityDocuments(config, p, name)
+		for _, d := range read {
+			docs = append(docs, RenderedDocument{Path: d.path, Data: d.data})
+		}
+		return err
+	})
+	return docs, errs
+}
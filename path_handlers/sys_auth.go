@@ -1,10 +1,11 @@
 package path_handlers
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/document"
 	"github.com/starlingbank/vaultsmith/vault"
 	"os"
 	"path/filepath"
@@ -26,9 +27,9 @@ type SysAuth struct {
 	configuredAuthMap map[string]*vaultApi.AuthMount
 }
 
-func NewSysAuthHandler(client vault.Vault, config PathHandlerConfig) (*SysAuth, error) {
+func NewSysAuthHandler(ctx context.Context, client vault.Vault, config PathHandlerConfig) (*SysAuth, error) {
 	// Build a map of currently active auth methods, so walkFile() can reference it
-	liveAuthMap, err := client.ListAuth()
+	liveAuthMap, err := client.ListAuth(ctx)
 	if err != nil {
 		return &SysAuth{}, err
 	}
@@ -51,7 +52,7 @@ func NewSysAuthHandler(client vault.Vault, config PathHandlerConfig) (*SysAuth,
 	}, nil
 }
 
-func (sh *SysAuth) walkFile(path string, f os.FileInfo, err error) error {
+func (sh *SysAuth) walkFile(ctx context.Context, path string, f os.FileInfo, err error) error {
 	if f == nil {
 		logger := sh.log.WithFields(log.Fields{"path": path, "error": err})
 		logger.Debug("Path does not exist, skipping")
@@ -60,49 +61,68 @@ func (sh *SysAuth) walkFile(path string, f os.FileInfo, err error) error {
 	if err != nil {
 		return fmt.Errorf("error reading %s: %s", path, err)
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// not doing anything with dirs
 	if f.IsDir() {
 		return nil
 	}
 
-	policyPath, err := apiPath(sh.config.DocumentPath, path)
+	sysAuthPath, enableOpts, err := readAuth(sh.config, path)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %s", path, err)
 	}
-	if !strings.HasPrefix(policyPath, "sys/auth") {
-		return fmt.Errorf("found file without sys/auth prefix: %s", policyPath)
+	err = sh.ensureAuth(ctx, sysAuthPath, enableOpts)
+	if err != nil {
+		return fmt.Errorf("error while ensuring auth for path %s: %s", path, err)
 	}
 
-	fileContents, err := sh.readFile(path)
+	return nil
+}
+
+// Parse the auth method in a file, returning its mount path, without reference to Vault. Errors do
+// not include the path.
+func readAuth(config PathHandlerConfig, path string) (sysAuthPath string, enableOpts vaultApi.EnableAuthOptions, err error) {
+	policyPath, err := apiPath(config.DocumentPath, path)
 	if err != nil {
-		return err
+		return "", enableOpts, err
+	}
+	if !strings.HasPrefix(policyPath, "sys/auth") {
+		return "", enableOpts, fmt.Errorf("found file without sys/auth prefix: %s", policyPath)
 	}
 
-	var enableOpts vaultApi.EnableAuthOptions
-	err = json.Unmarshal([]byte(fileContents), &enableOpts)
+	fileContents, err := document.Read(path)
 	if err != nil {
-		return fmt.Errorf("could not parse json from file %s: %s", path, err)
+		return "", enableOpts, fmt.Errorf("error reading: %s", err)
 	}
 
-	sysAuthPath := strings.TrimPrefix(policyPath, "sys/auth/") + "/"
-	err = sh.ensureAuth(sysAuthPath, enableOpts)
+	err = document.Unmarshal(path, []byte(fileContents), &enableOpts)
 	if err != nil {
-		return fmt.Errorf("error while ensuring auth for path %s: %s", path, err)
+		return "", enableOpts, fmt.Errorf("could not parse auth method: %s", err)
+	}
+	if err := checkSchema(config, path, policyPath, fileContents); err != nil {
+		return "", enableOpts, err
+	}
+	if enableOpts.Type == "" {
+		return "", enableOpts, fmt.Errorf("auth method has no \"type\" field")
 	}
 
-	return nil
+	return strings.TrimPrefix(policyPath, "sys/auth/") + "/", enableOpts, nil
 }
 
-func (sh *SysAuth) PutPoliciesFromDir(path string) error {
-	err := filepath.Walk(path, sh.walkFile)
+func (sh *SysAuth) PutPoliciesFromDir(ctx context.Context, path string) error {
+	err := filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		return sh.walkFile(ctx, p, f, err)
+	})
 	if err != nil {
 		return err
 	}
-	return sh.DisableUnconfiguredAuths()
+	return sh.DisableUnconfiguredAuths(ctx)
 }
 
 // Ensure that this auth type is enabled and has the correct configuration
-func (sh *SysAuth) ensureAuth(path string, enableOpts vaultApi.EnableAuthOptions) error {
+func (sh *SysAuth) ensureAuth(ctx context.Context, path string, enableOpts vaultApi.EnableAuthOptions) error {
 	// we need to convert to AuthConfigOutput in order to compare with existing config
 	var enableOptsAuthConfigOutput vaultApi.AuthConfigOutput
 	enableOptsAuthConfigOutput, err := ConvertAuthConfig(enableOpts.Config)
@@ -135,14 +155,14 @@ func (sh *SysAuth) ensureAuth(path string, enableOpts vaultApi.EnableAuthOptions
 		}
 	}
 	logger.Infof("Applying auth mount")
-	err = sh.client.EnableAuth(path, &enableOpts)
+	err = sh.client.EnableAuth(ctx, path, &enableOpts)
 	if err != nil {
 		return fmt.Errorf("could not enable auth %s: %s", path, err)
 	}
 	return nil
 }
 
-func (sh *SysAuth) DisableUnconfiguredAuths() error {
+func (sh *SysAuth) DisableUnconfiguredAuths(ctx context.Context) error {
 	// delete entries not in configured list
 	for path, authMount := range sh.liveAuthMap {
 		logger := log.WithFields(log.Fields{"authMount.Type": authMount.Type, "path": path})
@@ -153,7 +173,7 @@ func (sh *SysAuth) DisableUnconfiguredAuths() error {
 			continue // cannot be disabled, would give http 400 if attempted
 		} else {
 			logger.Infof("Disabling auth mount")
-			err := sh.client.DisableAuth(path)
+			err := sh.client.DisableAuth(ctx, path)
 			if err != nil {
 				return fmt.Errorf("failed to disable authMount at %s: %s", path, err)
 			}
@@ -177,10 +197,6 @@ func (sh *SysAuth) isConfigApplied(localConfig vaultApi.AuthConfigInput, remoteC
 	}
 }
 
-func (sh *SysAuth) Order() int {
-	return sh.order
-}
-
 // convert AuthConfigInput type to AuthConfigOutput type
 // A potential problem with this is that the transformation doesn't use the same code that Vault
 // uses internally, so bugs are possible; but ParseDuration is pretty standard (and vault
@@ -1,6 +1,7 @@
 package path_handlers
 
 import (
+	"context"
 	vaultApi "github.com/hashicorp/vault/api"
 	"github.com/starlingbank/vaultsmith/vault"
 	"os"
@@ -21,13 +22,13 @@ func examplePath() string {
 func TestSysAuth_EnsureAuth(t *testing.T) {
 	// Not terribly testable as it doesn't return anything we can assert against
 	client := &vault.MockClient{}
-	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	sh, err := NewSysAuthHandler(context.Background(), client, PathHandlerConfig{})
 	if err != nil {
 		t.Errorf("Failed to create SysAuth: %s", err)
 	}
 
 	enableOpts := vaultApi.EnableAuthOptions{}
-	err = sh.ensureAuth("foo", enableOpts)
+	err = sh.ensureAuth(context.Background(), "foo", enableOpts)
 	if err != nil {
 		t.Errorf("Error calling ensureAuth: %s", err)
 	}
@@ -36,11 +37,11 @@ func TestSysAuth_EnsureAuth(t *testing.T) {
 func TestSysAuth_PutPoliciesFromDir_Empty(t *testing.T) {
 	// Should do nothing without error
 	client := &vault.MockClient{}
-	sh, err := NewSysAuthHandler(client, PathHandlerConfig{})
+	sh, err := NewSysAuthHandler(context.Background(), client, PathHandlerConfig{})
 	if err != nil {
 		t.Errorf("Failed to create SysAuth: %s", err)
 	}
-	err = sh.PutPoliciesFromDir("")
+	err = sh.PutPoliciesFromDir(context.Background(), "")
 	if err != nil {
 		t.Errorf("Expected nil, got error %s", err.Error())
 	}
@@ -48,7 +49,7 @@ func TestSysAuth_PutPoliciesFromDir_Empty(t *testing.T) {
 
 func TestSysAuth_PutPoliciesFromDir_Example(t *testing.T) {
 	client := &vault.MockClient{}
-	sh, err := NewSysAuthHandler(client, PathHandlerConfig{
+	sh, err := NewSysAuthHandler(context.Background(), client, PathHandlerConfig{
 		DocumentPath: examplePath(),
 	})
 	if err != nil {
@@ -56,7 +57,7 @@ func TestSysAuth_PutPoliciesFromDir_Example(t *testing.T) {
 	}
 
 	sysPath := filepath.Join(examplePath(), "sys/auth")
-	err = sh.PutPoliciesFromDir(sysPath)
+	err = sh.PutPoliciesFromDir(context.Background(), sysPath)
 
 	if err != nil {
 		t.Errorf("Expected no error, got %q", err)
@@ -65,7 +66,7 @@ func TestSysAuth_PutPoliciesFromDir_Example(t *testing.T) {
 
 func TestSysAuth_WalkFile(t *testing.T) {
 	//client := &vaultClient.MockClient{}
-	//sh, err := NewSysAuthHandler(client, "")
+	//sh, err := NewSysAuthHandler(context.Background(), client, "")
 	//if err != nil {
 	//	t.Errorf("Failed to create SysAuth: %s", err)
 	//}
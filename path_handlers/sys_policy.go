@@ -1,10 +1,11 @@
 package path_handlers
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/document"
+	"github.com/starlingbank/vaultsmith/mask"
 	"github.com/starlingbank/vaultsmith/vault"
 	"os"
 	"path/filepath"
@@ -37,9 +38,9 @@ type policy struct {
 	SourceFile string // only for logging
 }
 
-func NewSysPolicyHandler(client vault.Vault, config PathHandlerConfig) (*SysPolicy, error) {
+func NewSysPolicyHandler(ctx context.Context, client vault.Vault, config PathHandlerConfig) (*SysPolicy, error) {
 	// Build a map of currently active auth methods, so walkFile() can reference it
-	livePolicyList, err := client.ListPolicies()
+	livePolicyList, err := client.ListPolicies(ctx)
 	if err != nil {
 		return &SysPolicy{}, fmt.Errorf("error listing policies: %s", err)
 	}
@@ -58,7 +59,7 @@ func NewSysPolicyHandler(client vault.Vault, config PathHandlerConfig) (*SysPoli
 	}, nil
 }
 
-func (sh *SysPolicy) walkFile(path string, f os.FileInfo, err error) error {
+func (sh *SysPolicy) walkFile(ctx context.Context, path string, f os.FileInfo, err error) error {
 	if f == nil {
 		sh.log.Infof("%q does not exist, skipping handler. Error was %q", path, err.Error())
 		return nil
@@ -66,74 +67,99 @@ func (sh *SysPolicy) walkFile(path string, f os.FileInfo, err error) error {
 	if err != nil {
 		return fmt.Errorf("error finding %s: %s", path, err)
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// not doing anything with dirs
 	if f.IsDir() {
 		return nil
 	}
 
-	tp, err := document.GenerateTemplateParams(sh.config.TemplateFile, sh.config.TemplateOverrides)
+	policies, err := readPolicies(sh.config, path, f.Name())
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for _, policy := range policies {
+		err = sh.EnsurePolicy(ctx, policy)
+		if err != nil {
+			return fmt.Errorf("failed to apply policy %s from %s: %s", policy.Name, path, err)
+		}
+	}
+
+	return nil
+}
+
+// Render and parse the policies in a file, without reference to Vault. Errors do not include the
+// path.
+func readPolicies(config PathHandlerConfig, path string, name string) (policies []policy, err error) {
+	tp, err := document.GenerateTemplateParams(config.TemplateFile, config.TemplateOverrides)
 	if err != nil {
-		return fmt.Errorf("could not generate template parameters: %s", err)
+		return nil, fmt.Errorf("could not generate template parameters: %s", err)
 	}
 
 	content, err := document.Read(path)
 	if err != nil {
-		return fmt.Errorf("error reading %s: %s", path, err)
+		return nil, fmt.Errorf("error reading: %s", err)
 	}
 	td := &document.Template{
-		FileName: strings.TrimSuffix(f.Name(), filepath.Ext(f.Name())),
-		Content:  content,
-		Params:   tp,
+		FileName:  strings.TrimSuffix(name, filepath.Ext(name)),
+		Content:   content,
+		Params:    tp,
+		Functions: config.templateFunctions(),
 	}
 
 	templatedDocs, err := td.Render()
 	if err != nil {
-		return fmt.Errorf("failed to render document %q: %s", path, err)
+		return nil, fmt.Errorf("failed to render document: %s", err)
 	}
 
-	apiPath, err := apiPath(sh.config.DocumentPath, path)
+	apiPath, err := apiPath(config.DocumentPath, path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !strings.HasPrefix(apiPath, "sys/policy") {
-		return fmt.Errorf("found file without sys/policy prefix: %s", apiPath)
+		return nil, fmt.Errorf("found file without sys/policy prefix: %s", apiPath)
 	}
 	for _, td := range templatedDocs {
 		policy := policy{
 			Name:       td.Name,
-			SourceFile: f.Name(),
+			SourceFile: name,
 		}
-		err = json.Unmarshal([]byte(td.Content), &policy)
+		err = document.Unmarshal(path, []byte(td.Content), &policy)
 		if err != nil {
-			return fmt.Errorf("failed to parse json from %s: %s", path, err)
+			return nil, fmt.Errorf("failed to parse policy: %s", err)
 		}
-
-		err = sh.EnsurePolicy(policy)
+		err = checkSchema(config, name, filepath.Join(filepath.Dir(apiPath), td.Name), td.Content)
 		if err != nil {
-			return fmt.Errorf("failed to apply policy at %s: %s", apiPath, err)
+			return nil, err
+		}
+		if strings.TrimSpace(policy.Policy) == "" {
+			return nil, fmt.Errorf("policy %s has no \"policy\" field", td.Name)
 		}
+		policies = append(policies, policy)
 	}
-
-	return nil
+	return policies, nil
 }
 
-func (sh *SysPolicy) PutPoliciesFromDir(path string) error {
-	err := filepath.Walk(path, sh.walkFile)
+func (sh *SysPolicy) PutPoliciesFromDir(ctx context.Context, path string) error {
+	err := filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		return sh.walkFile(ctx, p, f, err)
+	})
 	if err != nil {
 		return err
 	}
-	_, err = sh.RemoveUndeclaredPolicies()
+	_, err = sh.RemoveUndeclaredPolicies(ctx)
 	return err
 }
 
-func (sh *SysPolicy) EnsurePolicy(policy policy) error {
+func (sh *SysPolicy) EnsurePolicy(ctx context.Context, policy policy) error {
 	logger := sh.log.WithFields(log.Fields{
 		"name":       policy.Name,
 		"sourceFile": policy.SourceFile,
 	})
 
 	sh.configuredPolicyList = append(sh.configuredPolicyList, policy.Name)
-	applied, err := sh.isPolicyApplied(policy)
+	applied, err := sh.isPolicyApplied(ctx, policy)
 	if err != nil {
 		return err
 	}
@@ -142,10 +168,10 @@ func (sh *SysPolicy) EnsurePolicy(policy policy) error {
 		return nil
 	}
 	logger.Info("Applying policy")
-	return sh.client.PutPolicy(policy.Name, policy.Policy)
+	return sh.client.PutPolicy(ctx, policy.Name, policy.Policy)
 }
 
-func (sh *SysPolicy) RemoveUndeclaredPolicies() (deleted []string, err error) {
+func (sh *SysPolicy) RemoveUndeclaredPolicies(ctx context.Context) (deleted []string, err error) {
 	// only real reason to track the deleted policies is for testing as logs inform user
 	for _, liveName := range sh.livePolicyList {
 		if fixedPolicies[liveName] {
@@ -165,7 +191,9 @@ func (sh *SysPolicy) RemoveUndeclaredPolicies() (deleted []string, err error) {
 		if !found {
 			// not declared, delete
 			sh.log.WithFields(log.Fields{"policy": liveName}).Infof("Deleting policy")
-			sh.client.DeletePolicy(liveName)
+			if err := sh.client.DeletePolicy(ctx, liveName); err != nil {
+				return deleted, fmt.Errorf("failed to delete policy %s: %s", liveName, err)
+			}
 			deleted = append(deleted, liveName)
 		}
 	}
@@ -185,25 +213,22 @@ func (sh *SysPolicy) policyExists(policy policy) bool {
 }
 
 // true if the policy is applied on the server
-func (sh *SysPolicy) isPolicyApplied(policy policy) (bool, error) {
+func (sh *SysPolicy) isPolicyApplied(ctx context.Context, policy policy) (bool, error) {
 	if !sh.policyExists(policy) {
 		return false, nil
 	}
 
-	remotePolicy, err := sh.client.GetPolicy(policy.Name)
+	remotePolicy, err := sh.client.GetPolicy(ctx, policy.Name)
 	if err != nil {
-		return false, nil
+		return false, ctx.Err()
 	}
 
 	// TODO Need a proper HCL parser here, testing strings is error prone
 	if reflect.DeepEqual(policy.Policy, remotePolicy) {
 		return true, nil
 	} else {
-		log.Debugf("Policy not equal (local != remote): \n%+v\n!=\n%+v\n", policy.Policy, remotePolicy)
+		log.Debugf("Policy not equal (local != remote): \n%+v\n!=\n%+v\n",
+			mask.String(policy.Policy), mask.String(remotePolicy))
 		return false, nil
 	}
 }
-
-func (sh *SysPolicy) Order() int {
-	return sh.order
-}
@@ -1,6 +1,7 @@
 package path_handlers
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/vault"
 	"reflect"
@@ -10,7 +11,7 @@ import (
 func TestSysPolicyHandler_PolicyExists(t *testing.T) {
 	// Not terribly testable as it doesn't return anything we can assert against
 	client := &vault.MockClient{}
-	sph, err := NewSysPolicyHandler(client, PathHandlerConfig{})
+	sph, err := NewSysPolicyHandler(context.Background(), client, PathHandlerConfig{})
 	if err != nil {
 		t.Errorf("Failed to create SysAuth: %s", err)
 	}
@@ -31,7 +32,7 @@ func TestSysPolicyHandler_PolicyExists(t *testing.T) {
 
 func TestSysPolicyHandler_PolicyExistsFalse(t *testing.T) {
 	client := &vault.MockClient{}
-	sph, err := NewSysPolicyHandler(client, PathHandlerConfig{})
+	sph, err := NewSysPolicyHandler(context.Background(), client, PathHandlerConfig{})
 	if err != nil {
 		t.Errorf("Failed to create SysAuth: %s", err)
 	}
@@ -54,7 +55,7 @@ func TestSysPolicyHandler_PolicyExistsFalse(t *testing.T) {
 func TestSysPolicyHandler_IsPolicyApplied(t *testing.T) {
 	client := &vault.MockClient{}
 	client.ReturnString = "testPolicy"
-	sph, err := NewSysPolicyHandler(client, PathHandlerConfig{})
+	sph, err := NewSysPolicyHandler(context.Background(), client, PathHandlerConfig{})
 	if err != nil {
 		t.Errorf("Failed to create SysAuth: %s", err)
 	}
@@ -64,7 +65,7 @@ func TestSysPolicyHandler_IsPolicyApplied(t *testing.T) {
 		Policy: "testPolicy",
 	}
 	sph.livePolicyList = []string{"testName"}
-	rv, err := sph.isPolicyApplied(p)
+	rv, err := sph.isPolicyApplied(context.Background(), p)
 	if err != nil {
 		t.Errorf("Error calling isPolicyApplied: %s", err)
 	}
@@ -78,7 +79,7 @@ func TestSysPolicyHandler_IsPolicyApplied_PresentButDifferent(t *testing.T) {
 	client := &vault.MockClient{}
 	client.ReturnString = "testPolicy"
 
-	sph, err := NewSysPolicyHandler(client, PathHandlerConfig{})
+	sph, err := NewSysPolicyHandler(context.Background(), client, PathHandlerConfig{})
 	if err != nil {
 		t.Errorf("Failed to create SysAuth: %s", err)
 	}
@@ -88,7 +89,7 @@ func TestSysPolicyHandler_IsPolicyApplied_PresentButDifferent(t *testing.T) {
 		Policy: "this content is different",
 	}
 	sph.livePolicyList = []string{"testName"}
-	rv, err := sph.isPolicyApplied(p)
+	rv, err := sph.isPolicyApplied(context.Background(), p)
 	if err != nil {
 		t.Errorf("Error calling isPolicyApplied: %s", err)
 	}
@@ -98,7 +99,7 @@ func TestSysPolicyHandler_IsPolicyApplied_PresentButDifferent(t *testing.T) {
 }
 
 func TestSysPolicyHandler_RemoveUndeclaredPolicies(t *testing.T) {
-	sph, err := NewSysPolicyHandler(&vault.MockClient{}, PathHandlerConfig{})
+	sph, err := NewSysPolicyHandler(context.Background(), &vault.MockClient{}, PathHandlerConfig{})
 	if err != nil {
 		t.Errorf("Failed to create SysAuth: %s", err)
 	}
@@ -107,7 +108,7 @@ func TestSysPolicyHandler_RemoveUndeclaredPolicies(t *testing.T) {
 	sph.configuredPolicyList = []string{"baz", "foo", "bar"}
 
 	expected := []string{"qux", "quux"}
-	deleted, err := sph.RemoveUndeclaredPolicies()
+	deleted, err := sph.RemoveUndeclaredPolicies(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
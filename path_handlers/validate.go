@@ -0,0 +1,69 @@
+package path_handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/starlingbank/vaultsmith/document"
+)
+
+// Checks the documents in a directory of the document tree without Vault, returning every problem
+// found rather than stopping at the first. path is the directory on disk, as for
+// PathHandler.PutPoliciesFromDir.
+type DirValidator func(ctx context.Context, config PathHandlerConfig, path string) []error
+
+// A problem with a document, found by a DirValidator
+type DocumentError struct {
+	File string // relative to the document root
+	Err  error
+}
+
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("%s: %s", e.File, e.Err)
+}
+
+// Call check for each file beneath path, collecting the errors as DocumentErrors
+func validateFiles(ctx context.Context, config PathHandlerConfig, path string, check func(path string, name string) error) (errs []error) {
+	err := filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			errs = append(errs, documentError(config, p, err))
+			return nil
+		}
+		if f.IsDir() {
+			return nil
+		}
+		if err := check(p, f.Name()); err != nil {
+			errs = append(errs, documentError(config, p, err))
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func documentError(config PathHandlerConfig, path string, err error) *DocumentError {
+	rel, relErr := filepath.Rel(config.DocumentPath, path)
+	if relErr != nil {
+		rel = path
+	}
+	return &DocumentError{File: filepath.ToSlash(rel), Err: err}
+}
+
+// Check rendered content against the schema for docPath, if config has schemas
+func checkSchema(config PathHandlerConfig, name string, docPath string, content string) error {
+	if config.Schemas == nil {
+		return nil
+	}
+	var data interface{}
+	if err := document.Unmarshal(name, []byte(content), &data); err != nil {
+		return err
+	}
+	return config.Schemas.Validate(docPath, data)
+}
@@ -0,0 +1,67 @@
+package path_handlers
+
+import (
+	"path"
+	"strings"
+)
+
+// Fields which Vault accepts on write but never returns on read. Comparing them would always
+// fail, so they are left out when determining whether a document is applied.
+// Keys are document paths, mount/directory prefixes or path.Match patterns. Further fields can be
+// declared under "write_only" in the template file.
+var defaultWriteOnlyFields = map[string][]string{
+	"auth/aws/config/client": {"secret_key"},
+	"auth/ldap/config":       {"bindpass"},
+	"database/config":        {"password"},
+}
+
+// Return the write-only fields for the document at docPath, given declarations keyed by path
+func writeOnlyFields(declared map[string][]string, docPath string) (fields []string) {
+	seen := map[string]bool{}
+	for _, decl := range []map[string][]string{defaultWriteOnlyFields, declared} {
+		for pattern, f := range decl {
+			if !writeOnlyPathMatches(pattern, docPath) {
+				continue
+			}
+			for _, field := range f {
+				if !seen[field] {
+					seen[field] = true
+					fields = append(fields, field)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// true if pattern refers to docPath, or to a mount or directory containing it
+func writeOnlyPathMatches(pattern string, docPath string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if pattern == docPath || strings.HasPrefix(docPath, pattern+"/") {
+		return true
+	}
+	matched, err := path.Match(pattern, docPath)
+	return err == nil && matched
+}
+
+// Return a copy of data without the given fields
+func withoutFields(data map[string]interface{}, fields []string) map[string]interface{} {
+	out := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		out[k] = v
+	}
+	for _, f := range fields {
+		delete(out, f)
+	}
+	return out
+}
+
+// true if data contains any of the given fields
+func hasAnyField(data map[string]interface{}, fields []string) bool {
+	for _, f := range fields {
+		if _, ok := data[f]; ok {
+			return true
+		}
+	}
+	return false
+}
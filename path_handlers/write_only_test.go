@@ -0,0 +1,52 @@
+package path_handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWriteOnlyFields(t *testing.T) {
+	declared := map[string][]string{
+		"auth/aws-prod/config/client": {"secret_key"},
+		"database":                    {"root_password"},
+		"auth/ldap-*/config":          {"bindpass"},
+	}
+	tests := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{name: "default", path: "auth/aws/config/client", expected: []string{"secret_key"}},
+		{name: "declared path", path: "auth/aws-prod/config/client", expected: []string{"secret_key"}},
+		{name: "declared mount", path: "database/config/postgres", expected: []string{"password", "root_password"}},
+		{name: "declared pattern", path: "auth/ldap-corp/config", expected: []string{"bindpass"}},
+		{name: "none", path: "auth/aws/role/foo", expected: nil},
+		{name: "prefix is not a mount", path: "databases/config/foo", expected: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := writeOnlyFields(declared, test.path)
+			if len(r) > 1 {
+				// map iteration order is random
+				if r[0] > r[1] {
+					r[0], r[1] = r[1], r[0]
+				}
+			}
+			if !reflect.DeepEqual(r, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, r)
+			}
+		})
+	}
+}
+
+func TestWithoutFields(t *testing.T) {
+	in := map[string]interface{}{"access_key": "foo", "secret_key": "bar"}
+	r := withoutFields(in, []string{"secret_key"})
+	exp := map[string]interface{}{"access_key": "foo"}
+	if !reflect.DeepEqual(r, exp) {
+		t.Errorf("Expected %+v, got %+v", exp, r)
+	}
+	if _, ok := in["secret_key"]; !ok {
+		t.Error("withoutFields modified its input")
+	}
+}
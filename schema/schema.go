@@ -0,0 +1,182 @@
+// Package schema checks documents against JSON schemas, before they are sent to Vault. Schemas for
+// common document types are bundled, and more can be added to the _schemas directory of the
+// document tree.
+package schema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/starlingbank/vaultsmith/document"
+)
+
+// Directory of the document tree containing additional schemas
+const Dir = "_schemas"
+
+//go:embed builtin
+var builtin embed.FS
+
+// A set of schemas, keyed by the document path, or directory of documents, they apply to. For
+// example, a schema at auth/aws/role applies to every role of an aws auth method. Auth methods are
+// matched by type, so the same schema applies to auth/aws-prod/role if sys/auth/aws-prod is of type
+// aws.
+type Set struct {
+	schemas    map[string]*jsonschema.Schema
+	mountTypes map[string]string // auth mount path to its type, from the sys/auth documents
+}
+
+// Load the bundled schemas, and those in the _schemas directory of the document tree at docPath,
+// which take precedence. A schema at _schemas/<path>.json (or .yaml) applies to the document at
+// <path>, or the documents directly within the directory <path>.
+func Load(docPath string) (*Set, error) {
+	s := &Set{
+		schemas:    map[string]*jsonschema.Schema{},
+		mountTypes: map[string]string{},
+	}
+	compiler := jsonschema.NewCompiler()
+
+	root, _ := fs.Sub(builtin, "builtin")
+	if err := s.addDir(compiler, root, "builtin/"); err != nil {
+		return nil, err
+	}
+	custom := filepath.Join(docPath, Dir)
+	if _, err := os.Stat(custom); err == nil {
+		if err := s.addDir(compiler, os.DirFS(custom), Dir+"/"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.loadMountTypes(filepath.Join(docPath, "sys", "auth")); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Compile the schemas in fsys, replacing any for the same path
+func (s *Set) addDir(compiler *jsonschema.Compiler, fsys fs.FS, prefix string) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		// schemas may be yaml, so normalise to json for the compiler
+		var v interface{}
+		if err := document.Unmarshal(p, b, &v); err != nil {
+			return fmt.Errorf("could not parse schema %s%s: %s", prefix, p, err)
+		}
+		b, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		url := "mem://" + prefix + p
+		if err := compiler.AddResource(url, bytes.NewReader(b)); err != nil {
+			return fmt.Errorf("could not load schema %s%s: %s", prefix, p, err)
+		}
+		compiled, err := compiler.Compile(url)
+		if err != nil {
+			return fmt.Errorf("could not compile schema %s%s: %s", prefix, p, err)
+		}
+		s.schemas[strings.TrimSuffix(p, path.Ext(p))] = compiled
+		return nil
+	})
+}
+
+// Record the type of each auth method declared in dir
+func (s *Set) loadMountTypes(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		var auth struct {
+			Type string `json:"type"`
+		}
+		// a document which can't be parsed is reported when it is validated
+		if document.Unmarshal(f.Name(), b, &auth) == nil && auth.Type != "" {
+			s.mountTypes[strings.Split(f.Name(), ".")[0]] = auth.Type
+		}
+	}
+	return nil
+}
+
+// Check a document, given its Vault path (e.g. auth/aws/role/foo), against its schema. Documents
+// without a schema are not checked.
+func (s *Set) Validate(docPath string, data interface{}) error {
+	if s == nil {
+		return nil
+	}
+	name, schema := s.find(docPath)
+	if schema == nil {
+		return nil
+	}
+	err := schema.Validate(data)
+	if ve, ok := err.(*jsonschema.ValidationError); ok {
+		return fmt.Errorf("does not match schema %s: %s", name, strings.Join(causes(ve), "; "))
+	}
+	return err
+}
+
+// Return the schema for a document, and its name
+func (s *Set) find(docPath string) (string, *jsonschema.Schema) {
+	docPath = filepath.ToSlash(docPath)
+	candidates := []string{docPath}
+	parts := strings.Split(docPath, "/")
+	if len(parts) > 2 && parts[0] == "auth" {
+		// auth methods are mounted at arbitrary paths, so also match by type
+		mountType, ok := s.mountTypes[parts[1]]
+		if !ok {
+			mountType = parts[1]
+		}
+		typed := path.Join(append([]string{"auth", mountType}, parts[2:]...)...)
+		candidates = append(candidates, typed)
+	}
+	for _, c := range append(candidates, dirs(candidates)...) {
+		if schema, ok := s.schemas[c]; ok {
+			return c, schema
+		}
+	}
+	return "", nil
+}
+
+func dirs(paths []string) (d []string) {
+	for _, p := range paths {
+		d = append(d, path.Dir(p))
+	}
+	return d
+}
+
+// Return the messages of the innermost causes of a validation error, which describe the problems
+func causes(ve *jsonschema.ValidationError) (messages []string) {
+	if len(ve.Causes) == 0 {
+		if ve.InstanceLocation == "" {
+			return []string{ve.Message}
+		}
+		return []string{fmt.Sprintf("%s: %s", ve.InstanceLocation, ve.Message)}
+	}
+	for _, c := range ve.Causes {
+		messages = append(messages, causes(c)...)
+	}
+	return messages
+}
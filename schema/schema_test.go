@@ -0,0 +1,99 @@
+package schema
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write files, keyed by slash separated path, under a new temp dir
+func writeTestTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSet_Validate(t *testing.T) {
+	docPath := writeTestTree(t, map[string]string{
+		"sys/auth/aws-prod.json":          `{"type": "aws"}`,
+		"_schemas/secret/app.yaml":        "type: object\nrequired: [owner]\n",
+		"_schemas/auth/approle/role.json": `{"type": "object", "required": ["token_ttl"]}`,
+	})
+	s, err := Load(docPath)
+	if err != nil {
+		t.Fatalf("Error loading schemas: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		data   map[string]interface{}
+		errMsg string // expected substring of the error, if any
+	}{
+		{name: "auth method", path: "sys/auth/aws", data: map[string]interface{}{"type": "aws"}},
+		{name: "auth method without type", path: "sys/auth/aws",
+			data: map[string]interface{}{"description": "x"}, errMsg: "missing properties: 'type'"},
+		{name: "auth method config typo", path: "sys/auth/aws",
+			data:   map[string]interface{}{"type": "aws", "config": map[string]interface{}{"max_lease_tll": "1h"}},
+			errMsg: "/config: additionalProperties 'max_lease_tll' not allowed"},
+		{name: "policy", path: "sys/policy/reader", data: map[string]interface{}{"policy": "path {}"}},
+		{name: "aws role", path: "auth/aws/role/foo",
+			data: map[string]interface{}{"auth_type": "iam", "max_ttl": "1h", "policies": []interface{}{"a"}}},
+		{name: "aws role with string values", path: "auth/aws/role/foo",
+			data: map[string]interface{}{"resolve_aws_unique_ids": "true", "token_num_uses": "3"}},
+		{name: "aws role with bad boolean", path: "auth/aws/role/foo",
+			data: map[string]interface{}{"resolve_aws_unique_ids": "yes"}, errMsg: "/resolve_aws_unique_ids"},
+		{name: "aws role with bad integer", path: "auth/aws/role/foo",
+			data: map[string]interface{}{"token_num_uses": "3x"}, errMsg: "/token_num_uses"},
+		{name: "auth method with string boolean", path: "sys/auth/aws", data: map[string]interface{}{"type": "aws", "local": "false"}},
+		{name: "aws role typo", path: "auth/aws/role/foo",
+			data: map[string]interface{}{"max_tll": "1h"}, errMsg: "schema auth/aws/role: additionalProperties 'max_tll'"},
+		{name: "aws role by mount type", path: "auth/aws-prod/role/foo",
+			data: map[string]interface{}{"max_tll": "1h"}, errMsg: "additionalProperties 'max_tll'"},
+		{name: "aws role of unknown mount", path: "auth/aws-dev/role/foo", data: map[string]interface{}{"max_tll": "1h"}},
+		{name: "aws client config", path: "auth/aws/config/client",
+			data: map[string]interface{}{"secret_kye": "x"}, errMsg: "additionalProperties 'secret_kye'"},
+		{name: "custom schema replaces builtin", path: "auth/approle/role/foo",
+			data: map[string]interface{}{"unknown": true}, errMsg: "missing properties: 'token_ttl'"},
+		{name: "custom yaml schema", path: "secret/app", data: map[string]interface{}{}, errMsg: "schema secret/app"},
+		{name: "no schema", path: "secret/other", data: map[string]interface{}{"anything": 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s.Validate(test.path, test.data)
+			if test.errMsg == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestSet_ValidateNil(t *testing.T) {
+	var s *Set
+	if err := s.Validate("sys/auth/aws", map[string]interface{}{}); err != nil {
+		t.Errorf("Expected nil set to accept everything, got %s", err)
+	}
+}
+
+func TestLoad_invalidSchema(t *testing.T) {
+	docPath := writeTestTree(t, map[string]string{"_schemas/secret/app.json": `{"type": "objekt"}`})
+	if _, err := Load(docPath); err == nil || !strings.Contains(err.Error(), "_schemas/secret/app.json") {
+		t.Errorf("Expected error naming the schema, got %v", err)
+	}
+}
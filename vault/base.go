@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
@@ -25,24 +26,27 @@ put.
 type Vault interface {
 	readMethods
 	writeMethods
-	Authenticate(string) error
+	Authenticate(ctx context.Context, role string) error
 }
 
+// All methods take a context. The underlying api client does not support cancelling a request in
+// flight, so a cancelled context stops the next operation from starting, and its error returned.
 type readMethods interface {
-	GetPolicy(name string) (string, error)
-	List(path string) (*vaultApi.Secret, error)
-	ListAuth() (map[string]*vaultApi.AuthMount, error)
-	ListPolicies() ([]string, error)
-	Read(path string) (*vaultApi.Secret, error)
+	GetPolicy(ctx context.Context, name string) (string, error)
+	List(ctx context.Context, path string) (*vaultApi.Secret, error)
+	ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error)
+	ListMounts(ctx context.Context) (map[string]*vaultApi.MountOutput, error)
+	ListPolicies(ctx context.Context) ([]string, error)
+	Read(ctx context.Context, path string) (*vaultApi.Secret, error)
 }
 
 type writeMethods interface {
-	Delete(path string) (*vaultApi.Secret, error)
-	DeletePolicy(name string) error
-	DisableAuth(string) error
-	EnableAuth(path string, options *vaultApi.EnableAuthOptions) error
-	PutPolicy(string, string) error
-	Write(path string, data map[string]interface{}) (*vaultApi.Secret, error)
+	Delete(ctx context.Context, path string) (*vaultApi.Secret, error)
+	DeletePolicy(ctx context.Context, name string) error
+	DisableAuth(ctx context.Context, path string) error
+	EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error
+	PutPolicy(ctx context.Context, name string, data string) error
+	Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error)
 }
 
 type BaseClient struct {
@@ -95,7 +99,10 @@ func NewVaultClient(readonly bool) (c Vault, err error) {
 
 }
 
-func (c *BaseClient) Authenticate(role string) error {
+func (c *BaseClient) Authenticate(ctx context.Context, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if c.client.Token() != "" {
 		// Already authenticated. Supposedly.
 		c.logger.Debugf("Already authenticated by environment variable")
@@ -123,22 +130,44 @@ func (c *BaseClient) Authenticate(role string) error {
 }
 
 // Only read methods should be in the base client
-func (c *BaseClient) Read(path string) (*vaultApi.Secret, error) {
+func (c *BaseClient) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return c.client.Logical().Read(path)
 }
 
-func (c *BaseClient) List(path string) (*vaultApi.Secret, error) {
+func (c *BaseClient) List(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return c.client.Logical().List(path)
 }
 
-func (c *BaseClient) ListAuth() (map[string]*vaultApi.AuthMount, error) {
+func (c *BaseClient) ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return c.client.Sys().ListAuth()
 }
 
-func (c *BaseClient) GetPolicy(name string) (string, error) {
+func (c *BaseClient) ListMounts(ctx context.Context) (map[string]*vaultApi.MountOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.client.Sys().ListMounts()
+}
+
+func (c *BaseClient) GetPolicy(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	return c.client.Sys().GetPolicy(name)
 }
 
-func (c *BaseClient) ListPolicies() ([]string, error) {
+func (c *BaseClient) ListPolicies(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return c.client.Sys().ListPolicies()
 }
@@ -1,8 +1,11 @@
 package vault
 
 import (
+	"context"
+
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/mask"
 )
 
 type dryClient struct {
@@ -10,16 +13,22 @@ type dryClient struct {
 }
 
 // Override any methods that write, so we can only perform reads
-func (c *dryClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
+func (c *dryClient) EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action":  "EnableAuth",
-		"options": options,
+		"options": mask.Value(options),
 		"path":    path,
 	}).Debug("No Vault API call made")
 	return nil
 }
 
-func (c *dryClient) DisableAuth(path string) error {
+func (c *dryClient) DisableAuth(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "DisableAuth",
 		"path":   path,
@@ -27,16 +36,22 @@ func (c *dryClient) DisableAuth(path string) error {
 	return nil
 }
 
-func (c *dryClient) PutPolicy(name string, data string) error {
+func (c *dryClient) PutPolicy(ctx context.Context, name string, data string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "PutPolicy",
 		"name":   name,
-		"data":   data,
+		"data":   mask.String(data),
 	}).Debug("No Vault API call made")
 	return nil
 }
 
-func (c *dryClient) DeletePolicy(name string) error {
+func (c *dryClient) DeletePolicy(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "DeletePolicy",
 		"name":   name,
@@ -44,16 +59,22 @@ func (c *dryClient) DeletePolicy(name string) error {
 	return nil
 }
 
-func (c *dryClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+func (c *dryClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "Write",
 		"path":   path,
-		"data":   data,
+		"data":   mask.Data(data),
 	}).Debug("No Vault API call made")
 	return &vaultApi.Secret{}, nil
 }
 
-func (c *dryClient) Delete(path string) (*vaultApi.Secret, error) {
+func (c *dryClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "Delete",
 		"path":   path,
@@ -0,0 +1,345 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// An Operation is a write to Vault, as recorded by a Journal to undo a run
+type Operation struct {
+	Action  string                      `json:"action"`            // name of the client method, as for Change
+	Path    string                      `json:"path"`              // API path, mount path or policy name
+	Data    map[string]interface{}      `json:"data,omitempty"`    // for Write
+	Policy  string                      `json:"policy,omitempty"`  // for PutPolicy
+	Options *vaultApi.EnableAuthOptions `json:"options,omitempty"` // for EnableAuth
+	Note    string                      `json:"note,omitempty"`    // what applying it does not restore
+}
+
+// Apply the operation with client
+func (o Operation) Apply(ctx context.Context, client Vault) (err error) {
+	switch o.Action {
+	case "Write":
+		_, err = client.Write(ctx, o.Path, o.Data)
+	case "Delete":
+		_, err = client.Delete(ctx, o.Path)
+	case "PutPolicy":
+		err = client.PutPolicy(ctx, o.Path, o.Policy)
+	case "DeletePolicy":
+		err = client.DeletePolicy(ctx, o.Path)
+	case "EnableAuth":
+		if o.Options == nil {
+			return fmt.Errorf("no options to enable auth %s with", o.Path)
+		}
+		err = client.EnableAuth(ctx, o.Path, o.Options)
+	case "DisableAuth":
+		err = client.DisableAuth(ctx, o.Path)
+	default:
+		return fmt.Errorf("unknown action %q", o.Action)
+	}
+	if err != nil {
+		return fmt.Errorf("could not %s %s: %s", o.Action, o.Path, err)
+	}
+	return nil
+}
+
+// Journal wraps a Vault client, recording how to undo each successful write. The state of a path
+// before it is first written is taken from the client's earlier reads of it, if any, otherwise it
+// is read before the write.
+type Journal struct {
+	Vault
+	mu       sync.Mutex
+	undo     []Operation                    // in the order they are to be applied
+	touched  map[string]bool                // paths already written, so their prior state is known
+	reads    map[string]*vaultApi.Secret    // results of Read, before the path was written
+	policies map[string]string              // results of GetPolicy, before the policy was written
+	mounts   map[string]*vaultApi.AuthMount // result of the first ListAuth
+	disabled map[string]bool                // auth methods disabled, whose contents are not restored
+}
+
+func NewJournal(c Vault) *Journal {
+	return &Journal{
+		Vault:    c,
+		touched:  map[string]bool{},
+		reads:    map[string]*vaultApi.Secret{},
+		policies: map[string]string{},
+		disabled: map[string]bool{},
+	}
+}
+
+// Return the operations which undo the writes made so far, in the order they are to be applied
+func (j *Journal) Operations() []Operation {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Operation{}, j.undo...)
+}
+
+// Record the operations which undo a write, if it succeeded and is the first to key
+func (j *Journal) record(err error, key string, ops ...Operation) {
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.touched[key] {
+		return
+	}
+	j.touched[key] = true
+	// later writes are undone first
+	j.undo = append(append([]Operation{}, ops...), j.undo...)
+}
+
+func (j *Journal) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	secret, err := j.Vault.Read(ctx, path)
+	if err == nil {
+		j.mu.Lock()
+		if _, ok := j.reads[path]; !ok && !j.touched["path:"+path] {
+			j.reads[path] = secret
+		}
+		j.mu.Unlock()
+	}
+	return secret, err
+}
+
+func (j *Journal) GetPolicy(ctx context.Context, name string) (string, error) {
+	policy, err := j.Vault.GetPolicy(ctx, name)
+	if err == nil {
+		j.mu.Lock()
+		if _, ok := j.policies[name]; !ok && !j.touched["policy:"+name] {
+			j.policies[name] = policy
+		}
+		j.mu.Unlock()
+	}
+	return policy, err
+}
+
+func (j *Journal) ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error) {
+	mounts, err := j.Vault.ListAuth(ctx)
+	if err == nil {
+		j.mu.Lock()
+		if j.mounts == nil {
+			j.mounts = mounts
+		}
+		j.mu.Unlock()
+	}
+	return mounts, err
+}
+
+// Return the state of a path before it was written, reading it if it has not been
+func (j *Journal) priorRead(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	j.mu.Lock()
+	secret, ok := j.reads[path]
+	j.mu.Unlock()
+	if ok {
+		return secret, nil
+	}
+	return j.Read(ctx, path)
+}
+
+func (j *Journal) priorPolicy(ctx context.Context, name string) (string, error) {
+	j.mu.Lock()
+	policy, ok := j.policies[name]
+	j.mu.Unlock()
+	if ok {
+		return policy, nil
+	}
+	return j.GetPolicy(ctx, name)
+}
+
+func (j *Journal) priorMount(ctx context.Context, path string) (*vaultApi.AuthMount, error) {
+	j.mu.Lock()
+	mounts := j.mounts
+	j.mu.Unlock()
+	if mounts == nil {
+		var err error
+		if mounts, err = j.ListAuth(ctx); err != nil {
+			return nil, err
+		}
+	}
+	// mounts are listed with a trailing slash
+	return mounts[strings.TrimSuffix(path, "/")+"/"], nil
+}
+
+// Return the operation which restores a path to its prior state
+func restoreWrite(path string, prior *vaultApi.Secret) Operation {
+	if prior == nil || prior.Data == nil {
+		return Operation{Action: "Delete", Path: path}
+	}
+	return Operation{Action: "Write", Path: path, Data: prior.Data}
+}
+
+func (j *Journal) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	prior, err := j.priorRead(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s before writing it: %s", path, err)
+	}
+	secret, err := j.Vault.Write(ctx, path, data)
+	j.record(err, "path:"+path, restoreWrite(path, prior))
+	return secret, err
+}
+
+func (j *Journal) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	prior, err := j.priorRead(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s before deleting it: %s", path, err)
+	}
+	secret, err := j.Vault.Delete(ctx, path)
+	if mount := j.disabledMount(path); mount != "" {
+		// restoring it would fail, as it would be undone before the auth method is enabled again
+		if err == nil {
+			log.WithFields(log.Fields{"path": path}).Warnf(
+				"Deleted within disabled auth method %s, a rollback will not restore it", mount)
+		}
+		return secret, err
+	}
+	if op := restoreWrite(path, prior); op.Action == "Write" {
+		j.record(err, "path:"+path, op)
+	}
+	return secret, err
+}
+
+func (j *Journal) PutPolicy(ctx context.Context, name string, data string) error {
+	prior, err := j.priorPolicy(ctx, name)
+	if err != nil {
+		return fmt.Errorf("could not read policy %s before writing it: %s", name, err)
+	}
+	err = j.Vault.PutPolicy(ctx, name, data)
+	if prior == "" {
+		j.record(err, "policy:"+name, Operation{Action: "DeletePolicy", Path: name})
+	} else {
+		j.record(err, "policy:"+name, Operation{Action: "PutPolicy", Path: name, Policy: prior})
+	}
+	return err
+}
+
+func (j *Journal) DeletePolicy(ctx context.Context, name string) error {
+	prior, err := j.priorPolicy(ctx, name)
+	if err != nil {
+		return fmt.Errorf("could not read policy %s before deleting it: %s", name, err)
+	}
+	err = j.Vault.DeletePolicy(ctx, name)
+	if prior != "" {
+		j.record(err, "policy:"+name, Operation{Action: "PutPolicy", Path: name, Policy: prior})
+	}
+	return err
+}
+
+func (j *Journal) EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error {
+	prior, err := j.priorMount(ctx, path)
+	if err != nil {
+		return fmt.Errorf("could not list auth methods before enabling %s: %s", path, err)
+	}
+	err = j.Vault.EnableAuth(ctx, path, options)
+	if prior == nil {
+		j.record(err, "auth:"+path, Operation{Action: "DisableAuth", Path: path})
+	} else if err == nil {
+		// re-enabling would remove everything configured within the auth method
+		log.WithFields(log.Fields{"path": path}).Warn("Changes to an existing auth method can not be undone")
+	}
+	return err
+}
+
+func (j *Journal) DisableAuth(ctx context.Context, path string) error {
+	prior, err := j.priorMount(ctx, path)
+	if err != nil {
+		return fmt.Errorf("could not list auth methods before disabling %s: %s", path, err)
+	}
+	err = j.Vault.DisableAuth(ctx, path)
+	if prior != nil {
+		j.record(err, "auth:"+path, Operation{Action: "EnableAuth", Path: path, Options: AuthOptions(prior),
+			Note: "The roles and configuration within the auth method are not restored"})
+	}
+	if err == nil {
+		// disabling an auth method removes everything within it, which is not read beforehand
+		j.mu.Lock()
+		j.disabled[strings.Trim(path, "/")] = true
+		j.mu.Unlock()
+		log.WithFields(log.Fields{"path": path}).Warn(
+			"Disabled an auth method, a rollback will enable it again but not restore its contents")
+	}
+	return err
+}
+
+// Return the disabled auth method which path is within, if any
+func (j *Journal) disabledMount(path string) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for mount := range j.disabled {
+		if strings.HasPrefix(path, "auth/"+mount+"/") {
+			return mount
+		}
+	}
+	return ""
+}
+
+// Return the options which enable an auth method as it was listed
+func AuthOptions(mount *vaultApi.AuthMount) *vaultApi.EnableAuthOptions {
+	return &vaultApi.EnableAuthOptions{
+		Type:        mount.Type,
+		Description: mount.Description,
+		Config: vaultApi.AuthConfigInput{
+			DefaultLeaseTTL:           strconv.Itoa(mount.Config.DefaultLeaseTTL) + "s",
+			MaxLeaseTTL:               strconv.Itoa(mount.Config.MaxLeaseTTL) + "s",
+			PluginName:                mount.Config.PluginName,
+			AuditNonHMACRequestKeys:   mount.Config.AuditNonHMACRequestKeys,
+			AuditNonHMACResponseKeys:  mount.Config.AuditNonHMACResponseKeys,
+			ListingVisibility:         mount.Config.ListingVisibility,
+			PassthroughRequestHeaders: mount.Config.PassthroughRequestHeaders,
+		},
+		Local:    mount.Local,
+		SealWrap: mount.SealWrap,
+		Options:  mount.Options,
+	}
+}
+
+// Apply operations in order, stopping at the first error. Returns the operations which were not
+// applied, starting with the one which failed.
+func Undo(ctx context.Context, client Vault, ops []Operation) (remaining []Operation, err error) {
+	for i, op := range ops {
+		if err := op.Apply(ctx, client); err != nil {
+			return ops[i:], err
+		}
+		if op.Note != "" {
+			log.WithFields(log.Fields{"action": op.Action, "path": op.Path}).Warn(op.Note)
+		}
+	}
+	return nil, nil
+}
+
+// The operations which undo a run, as written to a rollback file
+type RollbackFile struct {
+	Created         time.Time   `json:"created"`
+	DocumentVersion string      `json:"document_version,omitempty"` // version of the documents applied, if known
+	Operations      []Operation `json:"operations"`                 // in the order they are to be applied
+}
+
+// Write a rollback file. It contains the prior values of documents, so is only readable by the owner.
+func WriteRollbackFile(path string, f RollbackFile) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("could not write rollback file: %s", err)
+	}
+	return nil
+}
+
+func ReadRollbackFile(path string) (f RollbackFile, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, fmt.Errorf("could not read rollback file: %s", err)
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return f, fmt.Errorf("could not parse rollback file %s: %s", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,187 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+// An in-memory Vault, for checking that a journal restores its state
+type memClient struct {
+	MockClient
+	docs     map[string]map[string]interface{}
+	policies map[string]string
+	mounts   map[string]*vaultApi.AuthMount
+	reads    int
+}
+
+func newMemClient() *memClient {
+	return &memClient{
+		docs:     map[string]map[string]interface{}{"secret/kept": {"a": "1"}, "secret/old": {"b": "2"}},
+		policies: map[string]string{"reader": "path {}", "unused": "path {}"},
+		mounts:   map[string]*vaultApi.AuthMount{"old/": {Type: "approle"}, "token/": {Type: "token"}},
+	}
+}
+
+func (m *memClient) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	m.reads++
+	if d, ok := m.docs[path]; ok {
+		return &vaultApi.Secret{Data: d}, nil
+	}
+	return nil, nil
+}
+
+func (m *memClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	m.docs[path] = data
+	return nil, nil
+}
+
+func (m *memClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	delete(m.docs, path)
+	return nil, nil
+}
+
+func (m *memClient) GetPolicy(ctx context.Context, name string) (string, error) {
+	return m.policies[name], nil
+}
+
+func (m *memClient) PutPolicy(ctx context.Context, name string, data string) error {
+	m.policies[name] = data
+	return nil
+}
+
+func (m *memClient) DeletePolicy(ctx context.Context, name string) error {
+	delete(m.policies, name)
+	return nil
+}
+
+func (m *memClient) ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error) {
+	mounts := map[string]*vaultApi.AuthMount{}
+	for k, v := range m.mounts {
+		mounts[k] = v
+	}
+	return mounts, nil
+}
+
+func (m *memClient) EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error {
+	m.mounts[path+"/"] = &vaultApi.AuthMount{Type: options.Type}
+	return nil
+}
+
+func (m *memClient) DisableAuth(ctx context.Context, path string) error {
+	delete(m.mounts, path+"/")
+	return nil
+}
+
+func TestJournal_undo(t *testing.T) {
+	ctx := context.Background()
+	m := newMemClient()
+	before := newMemClient()
+	j := NewJournal(m)
+
+	j.Read(ctx, "secret/kept")
+	j.Write(ctx, "secret/kept", map[string]interface{}{"a": "changed"})
+	j.Write(ctx, "secret/kept", map[string]interface{}{"a": "changed again"})
+	j.Write(ctx, "secret/new", map[string]interface{}{"c": "3"})
+	j.Delete(ctx, "secret/old")
+	j.Delete(ctx, "secret/missing")
+	j.PutPolicy(ctx, "reader", "path \"secret/*\" {}")
+	j.PutPolicy(ctx, "writer", "path {}")
+	j.DeletePolicy(ctx, "unused")
+	j.EnableAuth(ctx, "aws", &vaultApi.EnableAuthOptions{Type: "aws"})
+	j.DisableAuth(ctx, "old")
+
+	ops := j.Operations()
+	if len(ops) != 8 {
+		t.Errorf("Expected 8 operations to undo the writes, got %+v", ops)
+	}
+	if ops[0].Action != "EnableAuth" || ops[len(ops)-1].Path != "secret/kept" {
+		t.Errorf("Expected the last write to be undone first, got %+v", ops)
+	}
+	if remaining, err := Undo(ctx, m, ops); err != nil || remaining != nil {
+		t.Fatalf("Unexpected error %v, with %+v remaining", err, remaining)
+	}
+	if !reflect.DeepEqual(m.docs, before.docs) {
+		t.Errorf("Expected documents %+v, got %+v", before.docs, m.docs)
+	}
+	if !reflect.DeepEqual(m.policies, before.policies) {
+		t.Errorf("Expected policies %+v, got %+v", before.policies, m.policies)
+	}
+	if len(m.mounts) != 2 || m.mounts["old/"] == nil || m.mounts["aws/"] != nil {
+		t.Errorf("Expected the auth methods to be restored, got %+v", m.mounts)
+	}
+}
+
+func TestJournal_disableAuth(t *testing.T) {
+	ctx := context.Background()
+	m := newMemClient()
+	m.docs["auth/old/role/web"] = map[string]interface{}{"policies": "reader"}
+	j := NewJournal(m)
+
+	j.DisableAuth(ctx, "old")
+	j.Delete(ctx, "auth/old/role/web")
+
+	ops := j.Operations()
+	if len(ops) != 1 || ops[0].Action != "EnableAuth" || ops[0].Note == "" {
+		t.Fatalf("Expected only the auth method to be enabled again, with a note, got %+v", ops)
+	}
+	if remaining, err := Undo(ctx, m, ops); err != nil || remaining != nil {
+		t.Fatalf("Unexpected error %v, with %+v remaining", err, remaining)
+	}
+}
+
+func TestJournal_usesEarlierRead(t *testing.T) {
+	ctx := context.Background()
+	m := newMemClient()
+	j := NewJournal(m)
+	j.Read(ctx, "secret/kept")
+	j.Write(ctx, "secret/kept", map[string]interface{}{"a": "changed"})
+	if m.reads != 1 {
+		t.Errorf("Expected the prior state to be taken from the earlier read, got %d reads", m.reads)
+	}
+}
+
+func TestJournal_failedWrite(t *testing.T) {
+	j := NewJournal(&MockClient{ReturnError: errors.New("permission denied")})
+	j.PutPolicy(context.Background(), "foo", "")
+	if len(j.Operations()) != 0 {
+		t.Errorf("Expected failed writes not to be recorded, got %+v", j.Operations())
+	}
+}
+
+func TestUndo_stopsAtError(t *testing.T) {
+	ops := []Operation{
+		{Action: "Delete", Path: "secret/a"},
+		{Action: "EnableAuth", Path: "aws"}, // no options
+		{Action: "Delete", Path: "secret/b"},
+	}
+	remaining, err := Undo(context.Background(), &MockClient{}, ops)
+	if err == nil || !reflect.DeepEqual(remaining, ops[1:]) {
+		t.Errorf("Expected an error, with the last 2 operations remaining, got %v and %+v", err, remaining)
+	}
+}
+
+func TestRollbackFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rollback.json")
+	f := RollbackFile{
+		DocumentVersion: "abc123",
+		Operations: []Operation{
+			{Action: "Write", Path: "secret/a", Data: map[string]interface{}{"a": "1"}},
+			{Action: "PutPolicy", Path: "reader", Policy: "path {}"},
+		},
+	}
+	if err := WriteRollbackFile(path, f); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadRollbackFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, f) {
+		t.Errorf("Expected %+v, got %+v", f, read)
+	}
+}
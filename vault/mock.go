@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"context"
 	"fmt"
 	vaultApi "github.com/hashicorp/vault/api"
 	"github.com/stretchr/testify/mock"
@@ -13,7 +14,7 @@ type MockClient struct {
 	ReturnSecret *vaultApi.Secret
 }
 
-func (m *MockClient) Authenticate(role string) error {
+func (m *MockClient) Authenticate(ctx context.Context, role string) error {
 	m.Called(role)
 	if role == "ConnectionRefused" {
 		return fmt.Errorf("dial tcp [::1]:8200: getsockopt: connection refused")
@@ -23,48 +24,53 @@ func (m *MockClient) Authenticate(role string) error {
 	return m.ReturnError
 }
 
-func (m *MockClient) DisableAuth(string) error {
+func (m *MockClient) DisableAuth(ctx context.Context, path string) error {
 	return m.ReturnError
 }
 
-func (m *MockClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
+func (m *MockClient) EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error {
 	return m.ReturnError
 }
 
-func (m *MockClient) ListAuth() (map[string]*vaultApi.AuthMount, error) {
+func (m *MockClient) ListAuth(ctx context.Context) (map[string]*vaultApi.AuthMount, error) {
 	rv := make(map[string]*vaultApi.AuthMount)
 	return rv, m.ReturnError
 }
 
-func (m *MockClient) ListPolicies() ([]string, error) {
+func (m *MockClient) ListMounts(ctx context.Context) (map[string]*vaultApi.MountOutput, error) {
+	rv := make(map[string]*vaultApi.MountOutput)
+	return rv, m.ReturnError
+}
+
+func (m *MockClient) ListPolicies(ctx context.Context) ([]string, error) {
 	rv := make([]string, 0)
 	return rv, m.ReturnError
 }
 
-func (m *MockClient) GetPolicy(name string) (string, error) {
+func (m *MockClient) GetPolicy(ctx context.Context, name string) (string, error) {
 	return m.ReturnString, m.ReturnError
 }
 
-func (m *MockClient) PutPolicy(name string, data string) error {
+func (m *MockClient) PutPolicy(ctx context.Context, name string, data string) error {
 	return m.ReturnError
 }
 
-func (m *MockClient) DeletePolicy(name string) error {
+func (m *MockClient) DeletePolicy(ctx context.Context, name string) error {
 	return m.ReturnError
 }
 
-func (m *MockClient) Read(path string) (*vaultApi.Secret, error) {
+func (m *MockClient) Read(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	return m.ReturnSecret, m.ReturnError
 }
 
-func (m *MockClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+func (m *MockClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
 	return m.ReturnSecret, m.ReturnError
 }
 
-func (m *MockClient) List(path string) (*vaultApi.Secret, error) {
+func (m *MockClient) List(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	return m.ReturnSecret, m.ReturnError
 }
 
-func (m *MockClient) Delete(path string) (*vaultApi.Secret, error) {
+func (m *MockClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
 	return m.ReturnSecret, m.ReturnError
 }
@@ -0,0 +1,260 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	vaultApi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/mask"
+)
+
+// A PlannedChange is a change a run would make, with the state it would replace
+type PlannedChange struct {
+	Change
+	Before interface{} // document data, policy or *AuthMount, or nil if there is none
+	After  interface{} // document data, policy or *EnableAuthOptions, or nil for a deletion
+}
+
+// Whether the change removes something: a document, a policy, or an auth method along with
+// everything configured within it
+func (c PlannedChange) Destructive() bool {
+	switch c.Action {
+	case "Delete", "DeletePolicy", "DisableAuth":
+		return true
+	}
+	return false
+}
+
+// Planner wraps a Vault client, recording the writes made through it without making them, along
+// with the state of each path before the write. Like the dry client, writes always succeed.
+type Planner struct {
+	Vault
+	mu      sync.Mutex
+	changes []PlannedChange
+}
+
+func NewPlanner(c Vault) *Planner {
+	return &Planner{Vault: c}
+}
+
+// Return the changes planned so far, in the order they were made
+func (p *Planner) Changes() []PlannedChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PlannedChange{}, p.changes...)
+}
+
+func (p *Planner) record(action string, path string, before interface{}, after interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.changes = append(p.changes, PlannedChange{
+		Change: Change{Action: action, Path: path},
+		Before: before,
+		After:  after,
+	})
+}
+
+// Return the current data at a path, or nil if there is none or it can't be read
+func (p *Planner) readData(ctx context.Context, path string) interface{} {
+	secret, err := p.Vault.Read(ctx, path)
+	if err != nil {
+		log.WithFields(log.Fields{"path": path}).Debugf("Could not read current value: %s", err)
+	}
+	if err != nil || secret == nil || secret.Data == nil {
+		return nil
+	}
+	return secret.Data
+}
+
+func (p *Planner) readPolicy(ctx context.Context, name string) interface{} {
+	policy, err := p.Vault.GetPolicy(ctx, name)
+	if err != nil || policy == "" {
+		return nil
+	}
+	return policy
+}
+
+func (p *Planner) readMount(ctx context.Context, path string) interface{} {
+	mounts, err := p.Vault.ListAuth(ctx)
+	if mount, ok := mounts[strings.TrimSuffix(path, "/")+"/"]; err == nil && ok && mount != nil {
+		return mount
+	}
+	return nil
+}
+
+func (p *Planner) EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.record("EnableAuth", path, p.readMount(ctx, path), options)
+	return nil
+}
+
+func (p *Planner) DisableAuth(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.record("DisableAuth", path, p.readMount(ctx, path), nil)
+	return nil
+}
+
+func (p *Planner) PutPolicy(ctx context.Context, name string, data string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.record("PutPolicy", name, p.readPolicy(ctx, name), data)
+	return nil
+}
+
+func (p *Planner) DeletePolicy(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.record("DeletePolicy", name, p.readPolicy(ctx, name), nil)
+	return nil
+}
+
+func (p *Planner) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.record("Write", path, p.readData(ctx, path), data)
+	return &vaultApi.Secret{}, nil
+}
+
+func (p *Planner) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.record("Delete", path, p.readData(ctx, path), nil)
+	return &vaultApi.Secret{}, nil
+}
+
+// Return the change as a diff for people to read: a line with the action, prefixed with "+" if it
+// creates something, "-" if it removes something and "~" if it changes something, then a line for
+// each field or line of a policy which is changed. Secrets are masked, see the mask package.
+func (c PlannedChange) Diff() string {
+	var b strings.Builder
+	switch {
+	case c.Destructive():
+		b.WriteString("- ")
+	case c.Before == nil:
+		b.WriteString("+ ")
+	default:
+		b.WriteString("~ ")
+	}
+	b.WriteString(c.Action + " " + c.Path)
+	if c.Action == "DisableAuth" {
+		b.WriteString(" (everything configured within the auth method is destroyed)")
+	}
+	b.WriteString("\n")
+
+	switch before := c.Before.(type) {
+	case map[string]interface{}:
+		after, _ := c.After.(map[string]interface{})
+		diffFields(&b, before, after)
+	case string:
+		after, _ := c.After.(string)
+		diffLines(&b, before, after)
+	case *vaultApi.AuthMount:
+		if after, ok := c.After.(*vaultApi.EnableAuthOptions); ok {
+			diffFields(&b,
+				map[string]interface{}{"type": before.Type, "description": before.Description},
+				map[string]interface{}{"type": after.Type, "description": after.Description})
+		} else {
+			fmt.Fprintf(&b, "    - type: %s\n", formatValue(before.Type))
+		}
+	case nil:
+		switch after := c.After.(type) {
+		case map[string]interface{}:
+			diffFields(&b, nil, after)
+		case string:
+			diffLines(&b, "", after)
+		case *vaultApi.EnableAuthOptions:
+			fmt.Fprintf(&b, "    + type: %s\n", formatValue(after.Type))
+		}
+	}
+	return b.String()
+}
+
+// Write a line for each field of after which differs from before, or for each field of before
+// if after is nil. Fields only in before are left as they are by a write, so are not shown.
+func diffFields(b *strings.Builder, before map[string]interface{}, after map[string]interface{}) {
+	var keys []string
+	if after == nil {
+		for k := range before {
+			keys = append(keys, k)
+		}
+	} else {
+		for k := range after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		old, existed := before[k]
+		switch {
+		case after == nil:
+			fmt.Fprintf(b, "    - %s: %s\n", k, formatValue(old))
+		case !existed:
+			fmt.Fprintf(b, "    + %s: %s\n", k, formatValue(after[k]))
+		case formatValue(old) != formatValue(after[k]):
+			fmt.Fprintf(b, "    ~ %s: %s => %s\n", k, formatValue(old), formatValue(after[k]))
+		}
+	}
+}
+
+// Format a value as JSON, with secrets masked
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(mask.Value(v))
+	if err != nil {
+		return fmt.Sprint(mask.Value(v))
+	}
+	return string(b)
+}
+
+// Write the lines removed from before and added in after, in order
+func diffLines(b *strings.Builder, before string, after string) {
+	var a, z []string
+	if before != "" {
+		a = strings.Split(mask.String(before), "\n")
+	}
+	if after != "" {
+		z = strings.Split(mask.String(after), "\n")
+	}
+	// longest common subsequence of lines, from the end
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(z)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(z) - 1; j >= 0; j-- {
+			if a[i] == z[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(z) {
+		switch {
+		case i < len(a) && j < len(z) && a[i] == z[j]:
+			i++
+			j++
+		case j == len(z) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(b, "    - %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(b, "    + %s\n", z[j])
+			j++
+		}
+	}
+}
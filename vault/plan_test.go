@@ -0,0 +1,94 @@
+package vault
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+func TestPlanner(t *testing.T) {
+	ctx := context.Background()
+	m := newMemClient()
+	p := NewPlanner(m)
+
+	p.Write(ctx, "secret/kept", map[string]interface{}{"a": "changed"})
+	p.Delete(ctx, "secret/old")
+	p.PutPolicy(ctx, "writer", "path {}")
+	p.DisableAuth(ctx, "old")
+
+	exp := []PlannedChange{
+		{Change{"Write", "secret/kept"}, map[string]interface{}{"a": "1"}, map[string]interface{}{"a": "changed"}},
+		{Change{"Delete", "secret/old"}, map[string]interface{}{"b": "2"}, nil},
+		{Change{"PutPolicy", "writer"}, nil, "path {}"},
+		{Change{"DisableAuth", "old"}, &vaultApi.AuthMount{Type: "approle"}, nil},
+	}
+	if !reflect.DeepEqual(p.Changes(), exp) {
+		t.Errorf("Expected %+v, got %+v", exp, p.Changes())
+	}
+	if !reflect.DeepEqual(m.docs, newMemClient().docs) || len(m.mounts) != 2 || len(m.policies) != 2 {
+		t.Errorf("Expected nothing to be written, got %+v", m)
+	}
+}
+
+func TestPlannedChange_Diff(t *testing.T) {
+	tests := []struct {
+		name   string
+		change PlannedChange
+		exp    string
+	}{
+		{
+			name: "new document",
+			change: PlannedChange{Change{"Write", "secret/new"}, nil,
+				map[string]interface{}{"b": 2, "a": "x"}},
+			exp: "+ Write secret/new\n    + a: \"x\"\n    + b: 2\n",
+		},
+		{
+			name: "changed document",
+			change: PlannedChange{Change{"Write", "secret/kept"},
+				map[string]interface{}{"a": "1", "b": "same", "ttl": 60},
+				map[string]interface{}{"a": "2", "b": "same", "c": true}},
+			exp: "~ Write secret/kept\n    ~ a: \"1\" => \"2\"\n    + c: true\n",
+		},
+		{
+			name:   "deleted document",
+			change: PlannedChange{Change{"Delete", "secret/old"}, map[string]interface{}{"b": "2"}, nil},
+			exp:    "- Delete secret/old\n    - b: \"2\"\n",
+		},
+		{
+			name:   "changed policy",
+			change: PlannedChange{Change{"PutPolicy", "reader"}, "a\nb\nc", "a\nB\nc\nd"},
+			exp:    "~ PutPolicy reader\n    - b\n    + B\n    + d\n",
+		},
+		{
+			name:   "deleted policy",
+			change: PlannedChange{Change{"DeletePolicy", "unused"}, "path {}", nil},
+			exp:    "- DeletePolicy unused\n    - path {}\n",
+		},
+		{
+			name:   "new auth method",
+			change: PlannedChange{Change{"EnableAuth", "aws"}, nil, &vaultApi.EnableAuthOptions{Type: "aws"}},
+			exp:    "+ EnableAuth aws\n    + type: \"aws\"\n",
+		},
+		{
+			name: "changed auth method",
+			change: PlannedChange{Change{"EnableAuth", "aws"}, &vaultApi.AuthMount{Type: "aws"},
+				&vaultApi.EnableAuthOptions{Type: "aws", Description: "new"}},
+			exp: "~ EnableAuth aws\n    ~ description: \"\" => \"new\"\n",
+		},
+		{
+			name:   "disabled auth method",
+			change: PlannedChange{Change{"DisableAuth", "old"}, &vaultApi.AuthMount{Type: "approle"}, nil},
+			exp: "- DisableAuth old (everything configured within the auth method is destroyed)\n" +
+				"    - type: \"approle\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.change.Diff(); got != tt.exp {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.exp, got)
+			}
+		})
+	}
+}
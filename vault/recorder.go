@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"context"
+	"sync"
+
+	vaultApi "github.com/hashicorp/vault/api"
+)
+
+// A Change is a write made to Vault, or in dry mode, one that would have been made
+type Change struct {
+	Action string // name of the client method, e.g. "Write" or "DeletePolicy"
+	Path   string // API path, mount path or policy name
+}
+
+// Recorder wraps a Vault client, recording each successful write so it can be reported
+type Recorder struct {
+	Vault
+	mu      sync.Mutex
+	changes []Change
+}
+
+func NewRecorder(c Vault) *Recorder {
+	return &Recorder{Vault: c}
+}
+
+// Return the changes made so far, in the order they were made
+func (r *Recorder) Changes() []Change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Change{}, r.changes...)
+}
+
+func (r *Recorder) record(err error, action string, path string) {
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, Change{Action: action, Path: path})
+}
+
+func (r *Recorder) EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error {
+	err := r.Vault.EnableAuth(ctx, path, options)
+	r.record(err, "EnableAuth", path)
+	return err
+}
+
+func (r *Recorder) DisableAuth(ctx context.Context, path string) error {
+	err := r.Vault.DisableAuth(ctx, path)
+	r.record(err, "DisableAuth", path)
+	return err
+}
+
+func (r *Recorder) PutPolicy(ctx context.Context, name string, data string) error {
+	err := r.Vault.PutPolicy(ctx, name, data)
+	r.record(err, "PutPolicy", name)
+	return err
+}
+
+func (r *Recorder) DeletePolicy(ctx context.Context, name string) error {
+	err := r.Vault.DeletePolicy(ctx, name)
+	r.record(err, "DeletePolicy", name)
+	return err
+}
+
+func (r *Recorder) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	secret, err := r.Vault.Write(ctx, path, data)
+	r.record(err, "Write", path)
+	return secret, err
+}
+
+func (r *Recorder) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	secret, err := r.Vault.Delete(ctx, path)
+	r.record(err, "Delete", path)
+	return secret, err
+}
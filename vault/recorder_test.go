@@ -0,0 +1,31 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(&MockClient{})
+	r.Write(context.Background(), "auth/aws/role/foo", nil)
+	r.DeletePolicy(context.Background(), "bar")
+
+	exp := []Change{
+		{Action: "Write", Path: "auth/aws/role/foo"},
+		{Action: "DeletePolicy", Path: "bar"},
+	}
+	if !reflect.DeepEqual(r.Changes(), exp) {
+		t.Errorf("Expected %+v, got %+v", exp, r.Changes())
+	}
+}
+
+func TestRecorder_failedWrite(t *testing.T) {
+	r := NewRecorder(&MockClient{ReturnError: errors.New("permission denied")})
+	r.PutPolicy(context.Background(), "foo", "")
+
+	if len(r.Changes()) != 0 {
+		t.Errorf("Expected failed writes not to be recorded, got %+v", r.Changes())
+	}
+}
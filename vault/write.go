@@ -1,8 +1,11 @@
 package vault
 
 import (
+	"context"
+
 	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	"github.com/starlingbank/vaultsmith/mask"
 )
 
 type writeClient struct {
@@ -11,16 +14,22 @@ type writeClient struct {
 }
 
 // Used by sysAuthHandler
-func (c *writeClient) EnableAuth(path string, options *vaultApi.EnableAuthOptions) error {
+func (c *writeClient) EnableAuth(ctx context.Context, path string, options *vaultApi.EnableAuthOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action":  "EnableAuth",
-		"options": options,
+		"options": mask.Value(options),
 		"path":    path,
 	}).Debug()
 	return c.client.Sys().EnableAuthWithOptions(path, options)
 }
 
-func (c *writeClient) DisableAuth(path string) error {
+func (c *writeClient) DisableAuth(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "DisableAuth",
 		"path":   path,
@@ -29,16 +38,22 @@ func (c *writeClient) DisableAuth(path string) error {
 }
 
 // Used by sysPolicyHandler
-func (c *writeClient) PutPolicy(name string, data string) error {
+func (c *writeClient) PutPolicy(ctx context.Context, name string, data string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "PutPolicy",
 		"name":   name,
-		"data":   data,
+		"data":   mask.String(data),
 	}).Debug("Calling Vault API")
 	return c.client.Sys().PutPolicy(name, data)
 }
 
-func (c *writeClient) DeletePolicy(name string) error {
+func (c *writeClient) DeletePolicy(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "DeletePolicy",
 		"name":   name,
@@ -47,16 +62,22 @@ func (c *writeClient) DeletePolicy(name string) error {
 }
 
 // Used by genericHandler
-func (c *writeClient) Write(path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+func (c *writeClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "Write",
 		"path":   path,
-		"data":   data,
+		"data":   mask.Data(data),
 	}).Debug("Calling Vault API")
 	return c.client.Logical().Write(path, data)
 }
 
-func (c *writeClient) Delete(path string) (*vaultApi.Secret, error) {
+func (c *writeClient) Delete(ctx context.Context, path string) (*vaultApi.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	c.logger.WithFields(log.Fields{
 		"action": "Delete",
 		"path":   path,
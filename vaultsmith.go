@@ -1,182 +1,285 @@
-package main
+// Package vaultsmith applies a declarative tree of documents to Vault. It is used by the
+// vaultsmith command, but can be imported to run vaultsmith in-process.
+package vaultsmith
 
 import (
+	"context"
 	"fmt"
-	log "github.com/sirupsen/logrus"
-	flag "github.com/spf13/pflag"
+	"io/fs"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/config"
 	"github.com/starlingbank/vaultsmith/document"
 	"github.com/starlingbank/vaultsmith/internal"
+	"github.com/starlingbank/vaultsmith/path_handlers"
 	"github.com/starlingbank/vaultsmith/vault"
-	"io/ioutil"
-	"path/filepath"
 )
 
-var flags = flag.NewFlagSet("Vaultsmith", flag.ExitOnError)
-var documentPath string
-var dry bool
-var templateFile string
-var vaultRole string
-var logLevel string
-var templateParams []string
-var httpAuthToken string
-var tarDir string
-var noCleanUp bool
-
-func init() {
-	flags.StringVar(
-		// TODO: remove default value of "./example", could do bad things in production
-		&documentPath, "document-path", "",
-		"The root directory of the configuration. Can be a local directory, local gz "+
-			"tarball or http url to a gz tarball.",
-	)
-	flags.StringVar(
-		&vaultRole, "role", "root", "The Vault role to authenticate as",
-	)
-	flags.StringVar(
-		&templateFile, "template-file", "", "JSON file containing template "+
-			"mappings. If not specified, vaultsmith will look for \"_vaultsmith.json\" in the "+
-			"base of the document path.",
-	)
-	flags.BoolVar(
-		&dry, "dry", false, "Dry run; will read from but not write to vault",
-	)
-	flags.StringVar(
-		&logLevel, "log-level", "info", fmt.Sprintf("Log level, valid "+
-			"values are %+v", log.AllLevels),
-	)
-	flags.StringSliceVar(
-		&templateParams, "template-params", []string{}, "Template parameters. "+
-			"Applies globally, but values in template-file take precedence. E.G.: service=foo,account=bar",
-	)
-	flags.StringVar(
-		&httpAuthToken, "http-auth-token", "", "Auth token to pass as "+
-			"'Authorization' header. Useful for passing user tokens to private github repos.",
-	)
-	flags.StringVar(
-		&tarDir, "tar-dir", "", "Directory within the tarball to use as the "+
-			"document-path. If not specified, and there is only one directory within the archive, "+
-			"that one will be used. If there is more than one diretory, the root directory of the "+
-			"archive will be used.",
-	)
-	flags.BoolVar(
-		&noCleanUp, "no-cleanup", false, "Don't clean up temp directory on exit",
-	)
+// Options for a run of vaultsmith
+type Options struct {
+	Config config.VaultsmithConfig
+	// Client used to read from and write to Vault. Use vault.NewVaultClient to create one from
+	// the environment, as the vaultsmith command does.
+	Client vault.Vault
+	// Documents to apply. If nil, documents are fetched from Config.DocumentPath. Config.Overlays
+	// are merged over them in either case.
+	Documents fs.FS
+	// Handlers for the document tree. If nil, path_handlers.DefaultRegistry() is used.
+	Registry *path_handlers.Registry
+	// Called by Run with the changes it will make, before making any, unless Config.Dry is set.
+	// If it returns an error, Run returns it without making them. See vault.Planner.
+	Confirm func(plan []vault.PlannedChange) error
+}
 
-	flags.Usage = func() {
-		fmt.Printf("Usage of vaultsmith:\n")
-		flags.PrintDefaults()
-		fmt.Print("\nNotes:\n" +
-			"• BE CAREFUL with this tool, it will faithfully apply whatever config you give it " +
-			"without confirmation or warning! Use --dry until you are confident.\n" +
-			"• Vault authentication is handled by environment variables (the same " +
-			"ones as the Vault client, as vaultsmith uses the same code). So ensure VAULT_ADDR " +
-			"and VAULT_TOKEN are set.\n" +
-			"• Files that start with an underscore (e.g. _vaultsmith.json) are not published to " +
-			"vault.\n" +
-			"• If template-file is not specified, it is not mandatory for _vaultsmith.json to be " +
-			"present.\n" +
-			"• Specifying a parameter with --template-params allows only a single value. If you " +
-			"need multiple values, please use a template-file." +
-			"\n\n")
-	}
-
-	// Avoid parsing flags passed on running `go test`
-	var args []string
-
-	for _, s := range os.Args[1:] {
-		if !strings.HasPrefix(s, "-test.") {
-			args = append(args, s)
-		}
+// Result of a run of vaultsmith
+type Result struct {
+	Dry             bool           // if true, Changes were not actually made
+	Changes         []vault.Change // changes made to Vault, in the order they were made
+	DocumentVersion string         // version of the documents applied, e.g. a commit SHA, if known
+	DocumentBytes   int64          // bytes downloaded to fetch the documents, if downloaded
+	// how the documents were obtained from the cache, if one was used. See document.Cached
+	DocumentCacheStatus string
+	// changes made to undo Changes after the run failed, if Config.Rollback is set
+	RolledBack []vault.Change
+	// backup of the managed paths taken before any changes were made, if Config.BackupDir is set
+	BackupFile string
+}
+
+// Returned by Run if the documents are not valid, in which case no changes are made
+type InvalidDocumentsError struct {
+	Problems []error
+}
+
+func (e *InvalidDocumentsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d problems found in the documents:", len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  %s", p)
+	}
+	return b.String()
+}
+
+// Run applies the documents to Vault. The Result is returned even if there is an error, so the
+// caller can determine what was applied before the failure. If ctx is cancelled, the run stops
+// before the next operation, and temporary files are still cleaned up. The documents are checked
+// as by Validate first; if any are invalid, an *InvalidDocumentsError is returned and no changes
+// are made. Then, if opts.Confirm is set, the changes are planned and it is asked to confirm them.
+//
+// If Config.Rollback or Config.RollbackFile is set, the state of each path is recorded before it
+// is first written, see vault.Journal. If the run fails and Config.Rollback is set, the changes are
+// undone. The operations which undo the run, or those which could not be undone, are written to
+// Config.RollbackFile.
+//
+// If Config.BackupDir is set, the current contents of the managed paths are written there before
+// any changes are made, see Result.BackupFile. The backup is a document tree, so can be applied to
+// restore them.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	result := &Result{Dry: opts.Config.Dry}
+	if opts.Client == nil {
+		return result, fmt.Errorf("no Vault client given")
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
 	}
+	var journal *vault.Journal
+	var client *vault.Recorder
+	if (opts.Config.Rollback || opts.Config.RollbackFile != "") && !opts.Config.Dry {
+		journal = vault.NewJournal(opts.Client)
+		client = vault.NewRecorder(journal)
+	} else {
+		client = vault.NewRecorder(opts.Client)
+	}
+	defer func() { result.Changes = client.Changes() }()
 
-	err := flags.Parse(args)
+	err := client.Authenticate(ctx, opts.Config.VaultRole)
 	if err != nil {
-		log.Fatal(err)
+		return result, fmt.Errorf("failed authenticating with Vault: %s", err)
 	}
+
+	err = withDocuments(ctx, opts, result, func(docPath string, conf config.VaultsmithConfig) error {
+		// check every document before anything is sent to Vault
+		livePolicies, err := client.ListPolicies(ctx)
+		if err != nil {
+			return fmt.Errorf("could not list policies: %s", err)
+		}
+		docs, problems, err := internal.Validate(ctx, conf, docPath, opts.Registry, livePolicies)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return &InvalidDocumentsError{Problems: problems}
+		}
+		if opts.Confirm != nil && !conf.Dry {
+			if err := confirm(ctx, opts, conf, docPath); err != nil {
+				return err
+			}
+		}
+		if conf.BackupDir != "" && !conf.Dry {
+			secret, err := document.EncryptionSecret(conf)
+			if err != nil {
+				return err
+			}
+			result.BackupFile, err = internal.Backup(ctx, client, conf, docPath, opts.Registry, docs, conf.BackupDir, secret)
+			if err != nil {
+				return fmt.Errorf("could not back up before applying: %s", err)
+			}
+		}
+		cw, err := internal.NewConfigWalker(ctx, client, conf, docPath, opts.Registry)
+		if err != nil {
+			return err
+		}
+		return cw.Run(ctx)
+	})
+	if journal != nil {
+		err = finishJournal(opts, journal, result, err)
+	}
+	return result, err
 }
 
-func main() {
-	log.SetOutput(os.Stderr)
-	ll, err := log.ParseLevel(logLevel)
+// Work out the changes the run will make, by applying the documents through a vault.Planner, and
+// pass them to opts.Confirm
+func confirm(ctx context.Context, opts Options, conf config.VaultsmithConfig, docPath string) error {
+	planner := vault.NewPlanner(opts.Client)
+	// the planner writes nothing, so auth methods it enables are not there to be read back
+	conf.Dry = true
+	cw, err := internal.NewConfigWalker(ctx, planner, conf, docPath, opts.Registry)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	log.SetLevel(ll)
-
-	if dry {
-		log.Info("Dry mode enabled, no changes will be made")
+	if err := cw.Run(ctx); err != nil {
+		return fmt.Errorf("could not plan the changes: %s", err)
 	}
-	if documentPath == "" {
-		log.Fatalln("Please specify --document-path")
+	return opts.Confirm(planner.Changes())
+}
+
+// Undo the run if it failed and rollback is enabled, and write the rollback file. Returns the
+// error of the run, with any error undoing it.
+func finishJournal(opts Options, journal *vault.Journal, result *Result, runErr error) error {
+	ops := journal.Operations()
+	if runErr != nil && opts.Config.Rollback && len(ops) > 0 {
+		log.Warnf("Run failed, undoing %d changes", len(ops))
+		// the run's context may have been cancelled, but the rollback should still complete
+		undoClient := vault.NewRecorder(opts.Client)
+		remaining, err := vault.Undo(context.Background(), undoClient, ops)
+		result.RolledBack = undoClient.Changes()
+		if err != nil {
+			runErr = fmt.Errorf("%s; undoing the run also failed: %s", runErr, err)
+		}
+		ops = remaining
 	}
-	// Only check if specified, otherwise no template file is OK
-	if templateFile != "" {
-		if _, err := os.Stat(templateFile); os.IsNotExist(err) {
-			log.Fatalf("Specified template-file does not exist: %s", err)
+	if opts.Config.RollbackFile != "" {
+		err := vault.WriteRollbackFile(opts.Config.RollbackFile, vault.RollbackFile{
+			Created:         time.Now().UTC(),
+			DocumentVersion: result.DocumentVersion,
+			Operations:      ops,
+		})
+		if err != nil && runErr == nil {
+			return err
+		} else if err != nil {
+			log.Error(err)
 		}
 	}
+	return runErr
+}
 
-	conf := config.VaultsmithConfig{
-		DocumentPath:   documentPath,
-		VaultRole:      vaultRole,
-		TemplateFile:   templateFile,
-		Dry:            dry,
-		TemplateParams: templateParams,
-		HttpAuthToken:  httpAuthToken,
-		TarDir:         tarDir,
+// Rollback applies the operations in a rollback file written by Run, undoing that run. If it
+// fails, the file is rewritten with the operations which were not applied, so it can be retried.
+// Result.Changes are the changes made to undo the run.
+func Rollback(ctx context.Context, opts Options, rollbackFile string) (*Result, error) {
+	result := &Result{Dry: opts.Config.Dry}
+	if opts.Client == nil {
+		return result, fmt.Errorf("no Vault client given")
 	}
-
-	var client vault.Vault
-	client, err = vault.NewVaultClient(conf.Dry)
+	f, err := vault.ReadRollbackFile(rollbackFile)
 	if err != nil {
-		log.Fatal(err)
+		return result, err
 	}
+	result.DocumentVersion = f.DocumentVersion
+	client := vault.NewRecorder(opts.Client)
+	defer func() { result.Changes = client.Changes() }()
 
-	err = Run(client, conf)
-	if err != nil {
-		log.Fatalf("Error: %s", err)
+	if err := client.Authenticate(ctx, opts.Config.VaultRole); err != nil {
+		return result, fmt.Errorf("failed authenticating with Vault: %s", err)
+	}
+	remaining, err := vault.Undo(ctx, client, f.Operations)
+	if err != nil && !opts.Config.Dry {
+		f.Operations = remaining
+		if writeErr := vault.WriteRollbackFile(rollbackFile, f); writeErr != nil {
+			log.Error(writeErr)
+		}
 	}
-	log.Debugf("Success")
+	return result, err
 }
 
-func whichFileExists(filePath ...string) (file string) {
-	for _, f := range filePath {
-		if _, err := os.Stat(f); !os.IsNotExist(err) {
-			log.WithFields(log.Fields{"file": f}).Debug("file exists")
-			return f
-		} else {
-			log.WithFields(log.Fields{"file": f}).Debug("file does not exist")
-		}
+// Validate checks the documents without Vault: they are fetched, rendered and parsed as for Run,
+// and every problem found is returned, rather than stopping at the first. Run also checks that
+// roles reference policies which exist in Vault, which Validate can only do for those declared in
+// the documents. err is only set if the documents could not be checked at all, e.g. if they could
+// not be fetched.
+func Validate(ctx context.Context, opts Options) (problems []error, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	return ""
+	err = withDocuments(ctx, opts, &Result{}, func(docPath string, conf config.VaultsmithConfig) error {
+		_, problems, err = internal.Validate(ctx, conf, docPath, opts.Registry, nil)
+		return err
+	})
+	return problems, err
 }
 
-func Run(c vault.Vault, config config.VaultsmithConfig) error {
-	err := c.Authenticate(config.VaultRole)
-	if err != nil {
-		return fmt.Errorf("failed authenticating with Vault: %s", err)
+// Render returns the documents as they would be sent to Vault, after templates are rendered and
+// parsed, sorted by path. Like Validate, it does not need Vault. Problems are returned as for
+// Validate, along with the documents which could be rendered.
+func Render(ctx context.Context, opts Options) (docs []path_handlers.RenderedDocument, problems []error, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
 	}
+	err = withDocuments(ctx, opts, &Result{}, func(docPath string, conf config.VaultsmithConfig) error {
+		docs, problems, err = internal.Render(ctx, conf, docPath, opts.Registry)
+		return err
+	})
+	return docs, problems, err
+}
 
+// Fetch the documents and call fn with their path, and the config with the template file
+// resolved. Details of the fetch are recorded in result. Temporary files are removed afterwards.
+func withDocuments(ctx context.Context, opts Options, result *Result, fn func(docPath string, conf config.VaultsmithConfig) error) error {
+	conf := opts.Config
 	workDir, err := ioutil.TempDir(os.TempDir(), "vaultsmith-")
 	if err != nil {
 		return fmt.Errorf("could not create temp directory: %s", err)
 	}
 	defer os.Remove(workDir)
 
-	docSet, err := document.GetSet(workDir, config)
+	var base document.Set
+	if opts.Documents != nil {
+		base = &document.FS{WorkDir: workDir, FS: opts.Documents}
+	}
+	docSet, err := document.GetLayeredSet(workDir, base, conf)
 	if err != nil {
 		return err
 	}
-	err = docSet.Get()
+	err = docSet.Get(ctx)
+	if !conf.NoCleanUp {
+		defer docSet.CleanUp()
+	}
+	if d, ok := docSet.(document.Downloaded); ok {
+		result.DocumentBytes = d.BytesDownloaded()
+	}
+	if c, ok := docSet.(document.Cached); ok {
+		result.DocumentCacheStatus = c.CacheStatus()
+	}
 	if err != nil {
 		return err
 	}
-	if !noCleanUp {
-		defer docSet.CleanUp()
+
+	if v, ok := docSet.(document.Versioned); ok {
+		result.DocumentVersion = v.Version()
 	}
 
 	docPath, err := docSet.Path()
@@ -185,14 +288,27 @@ func Run(c vault.Vault, config config.VaultsmithConfig) error {
 	}
 
 	// Determine if we have a template file
-	config.TemplateFile = whichFileExists(
-		templateFile,
+	conf.TemplateFile = whichFileExists(
+		conf.TemplateFile,
 		filepath.Join(docPath, "_vaultsmith.json"),
+		filepath.Join(docPath, "_vaultsmith.yaml"),
+		filepath.Join(docPath, "_vaultsmith.yml"),
 	)
 
-	cw, err := internal.NewConfigWalker(c, config, docPath)
-	if err != nil {
-		return err
+	return fn(docPath, conf)
+}
+
+func whichFileExists(filePath ...string) (file string) {
+	for _, f := range filePath {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"file": f}).Debug("file exists")
+			return f
+		} else {
+			log.WithFields(log.Fields{"file": f}).Debug("file does not exist")
+		}
 	}
-	return cw.Run()
+	return ""
 }
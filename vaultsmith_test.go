@@ -1,12 +1,20 @@
-package main
+package vaultsmith
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	vaultApi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/starlingbank/vaultsmith/config"
 	"github.com/starlingbank/vaultsmith/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestRunWhenVaultNotListening(t *testing.T) {
@@ -17,7 +25,7 @@ func TestRunWhenVaultNotListening(t *testing.T) {
 	conf.VaultRole = "ConnectionRefused"
 	mockClient.On("Authenticate", conf.VaultRole)
 
-	err := Run(mockClient, conf)
+	_, err := Run(context.Background(), Options{Config: conf, Client: mockClient})
 	if err == nil {
 		log.Fatal("Expected error, got nil")
 	}
@@ -37,7 +45,7 @@ func TestRunWhenRoleIsInvalid(t *testing.T) {
 	conf.VaultRole = "InvalidRole"
 	mockClient.On("Authenticate", conf.VaultRole)
 
-	err := Run(mockClient, conf)
+	_, err := Run(context.Background(), Options{Config: conf, Client: mockClient})
 	if err == nil {
 		log.Fatal("Expected error, got nil")
 	}
@@ -49,3 +57,389 @@ func TestRunWhenRoleIsInvalid(t *testing.T) {
 		t.Errorf("bad reason message '%s'", err.Error())
 	}
 }
+
+func TestRunWithDocumentsFS(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json":     &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"auth/approle/role/foo.json": &fstest.MapFile{Data: []byte(`{"policies": "reader"}`)},
+	}
+
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", "root")
+
+	result, err := Run(context.Background(), Options{
+		Config:    config.VaultsmithConfig{Dry: true, VaultRole: "root"},
+		Client:    mockClient,
+		Documents: docs,
+	})
+	if err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+	if !result.Dry {
+		t.Errorf("Expected dry result")
+	}
+	exp := []vault.Change{
+		{Action: "PutPolicy", Path: "reader"},
+		{Action: "Write", Path: "auth/approle/role/foo"},
+	}
+	if !reflect.DeepEqual(result.Changes, exp) {
+		t.Errorf("Expected changes %+v, got %+v", exp, result.Changes)
+	}
+}
+
+func TestRunWithoutClient(t *testing.T) {
+	_, err := Run(context.Background(), Options{})
+	if err == nil {
+		t.Error("Expected error without a client, got nil")
+	}
+}
+
+// cancels the context after the first write
+type cancellingClient struct {
+	vault.MockClient
+	cancel context.CancelFunc
+}
+
+func (c *cancellingClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	defer c.cancel()
+	return c.MockClient.Write(ctx, path, data)
+}
+
+func TestRunCancelled(t *testing.T) {
+	docs := fstest.MapFS{
+		"auth/approle/role/bar.json": &fstest.MapFile{Data: []byte(`{"policies": "default"}`)},
+		"auth/approle/role/foo.json": &fstest.MapFile{Data: []byte(`{"policies": "default"}`)},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &cancellingClient{cancel: cancel}
+	client.On("Authenticate", "root")
+
+	result, err := Run(ctx, Options{
+		Config:    config.VaultsmithConfig{VaultRole: "root"},
+		Client:    client,
+		Documents: docs,
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected %q error, got %v", context.Canceled, err)
+	}
+	exp := []vault.Change{{Action: "Write", Path: "auth/approle/role/bar"}}
+	if !reflect.DeepEqual(result.Changes, exp) {
+		t.Errorf("Expected changes %+v, got %+v", exp, result.Changes)
+	}
+}
+
+func TestRunWithOverlay(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json":     &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"auth/approle/role/foo.json": &fstest.MapFile{Data: []byte(`{"policies": "reader"}`)},
+	}
+	overlay := t.TempDir()
+	os.MkdirAll(filepath.Join(overlay, "auth", "approle", "role"), 0755)
+	ioutil.WriteFile(filepath.Join(overlay, "auth", "approle", "role", "_delete"), []byte("foo.json\n"), 0644)
+	ioutil.WriteFile(filepath.Join(overlay, "auth", "approle", "role", "bar.json"), []byte(`{"policies": "reader"}`), 0644)
+
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", "root")
+
+	result, err := Run(context.Background(), Options{
+		Config:    config.VaultsmithConfig{Dry: true, VaultRole: "root", Overlays: []string{overlay}},
+		Client:    mockClient,
+		Documents: docs,
+	})
+	if err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+	exp := []vault.Change{
+		{Action: "PutPolicy", Path: "reader"},
+		{Action: "Write", Path: "auth/approle/role/bar"},
+	}
+	if !reflect.DeepEqual(result.Changes, exp) {
+		t.Errorf("Expected changes %+v, got %+v", exp, result.Changes)
+	}
+}
+
+func TestRunWithYAMLDocuments(t *testing.T) {
+	docs := fstest.MapFS{
+		"_vaultsmith.yaml": &fstest.MapFile{Data: []byte("variables:\n  env: prod\n")},
+		"sys/policy/reader.yaml": &fstest.MapFile{Data: []byte(
+			"# read only access\npolicy: |\n  path \"secret/{{ env }}/*\" {\n    capabilities = [\"read\"]\n  }\n")},
+		"auth/approle/role/foo.yml": &fstest.MapFile{Data: []byte("policies: [reader] # comment\n")},
+	}
+
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", "root")
+
+	result, err := Run(context.Background(), Options{
+		Config:    config.VaultsmithConfig{Dry: true, VaultRole: "root"},
+		Client:    mockClient,
+		Documents: docs,
+	})
+	if err != nil {
+		t.Fatalf("Error calling Run: %s", err)
+	}
+	exp := []vault.Change{
+		{Action: "PutPolicy", Path: "reader"},
+		{Action: "Write", Path: "auth/approle/role/foo"},
+	}
+	if !reflect.DeepEqual(result.Changes, exp) {
+		t.Errorf("Expected changes %+v, got %+v", exp, result.Changes)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json":      &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"auth/approle/role/foo.json":  &fstest.MapFile{Data: []byte(`{"policies": "reader"`)},
+		"auth/approle/role/bar.yaml":  &fstest.MapFile{Data: []byte("policies: [reader\n")},
+		"auth/approle/role/good.json": &fstest.MapFile{Data: []byte(`{"policies": "reader"}`)},
+	}
+
+	problems, err := Validate(context.Background(), Options{Documents: docs})
+	if err != nil {
+		t.Fatalf("Error calling Validate: %s", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", problems)
+	}
+	for _, p := range problems {
+		if !strings.HasPrefix(p.Error(), "auth/approle/role/foo.json: ") &&
+			!strings.HasPrefix(p.Error(), "auth/approle/role/bar.yaml: ") {
+			t.Errorf("Unexpected problem %q", p)
+		}
+	}
+}
+
+func TestRunWithInvalidDocuments(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json":     &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"auth/aws/role/foo.json":     &fstest.MapFile{Data: []byte(`{"max_tll": "1h"}`)},
+		"auth/approle/role/bar.json": &fstest.MapFile{Data: []byte(`{"policies": "reader"}`)},
+	}
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", "root")
+
+	result, err := Run(context.Background(), Options{
+		Config:    config.VaultsmithConfig{VaultRole: "root"},
+		Client:    mockClient,
+		Documents: docs,
+	})
+	invalid, ok := err.(*InvalidDocumentsError)
+	if !ok {
+		t.Fatalf("Expected *InvalidDocumentsError, got %v", err)
+	}
+	if len(invalid.Problems) != 1 || !strings.Contains(invalid.Problems[0].Error(), "max_tll") {
+		t.Errorf("Expected a problem with max_tll, got %v", invalid.Problems)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Expected no changes, got %+v", result.Changes)
+	}
+}
+
+func TestRender(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json":     &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"sys/auth/approle.json":      &fstest.MapFile{Data: []byte(`{"type": "approle"}`)},
+		"auth/approle/role/foo.yaml": &fstest.MapFile{Data: []byte("policies: [reader]\n")},
+		"auth/approle/role/bad.json": &fstest.MapFile{Data: []byte(`{"policies": `)},
+	}
+
+	rendered, problems, err := Render(context.Background(), Options{Documents: docs})
+	if err != nil {
+		t.Fatalf("Error calling Render: %s", err)
+	}
+	if len(problems) != 1 || !strings.HasPrefix(problems[0].Error(), "auth/approle/role/bad.json: ") {
+		t.Errorf("Expected a problem with bad.json, got %v", problems)
+	}
+
+	var paths []string
+	for _, doc := range rendered {
+		paths = append(paths, doc.Path)
+	}
+	expected := []string{"auth/approle/role/foo", "sys/auth/approle", "sys/policy/reader"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected paths %v, got %v", expected, paths)
+	}
+	role, _ := json.Marshal(rendered[0].Data)
+	if string(role) != `{"policies":["reader"]}` {
+		t.Errorf("Unexpected role %s", role)
+	}
+	policy, _ := json.Marshal(rendered[2].Data)
+	if string(policy) != `{"policy":"path \"secret/*\" {}"}` {
+		t.Errorf("Unexpected policy %s", policy)
+	}
+}
+
+func TestRunWithDependencyCycle(t *testing.T) {
+	docs := fstest.MapFS{
+		"_depends_on.yaml":         &fstest.MapFile{Data: []byte("transit: [kv]\nkv: [transit]\n")},
+		"transit/keys/a.json":      &fstest.MapFile{Data: []byte(`{"a": 1}`)},
+		"kv/config.json":           &fstest.MapFile{Data: []byte(`{"b": 1}`)},
+		"auth/approle/role/x.json": &fstest.MapFile{Data: []byte(`{"policies": "default"}`)},
+	}
+	mockClient := new(vault.MockClient)
+	mockClient.On("Authenticate", "root")
+
+	result, err := Run(context.Background(), Options{
+		Config:    config.VaultsmithConfig{VaultRole: "root", Parallelism: 4},
+		Client:    mockClient,
+		Documents: docs,
+	})
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle: kv -> transit -> kv") {
+		t.Errorf("Expected a dependency cycle error, got %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Expected no changes, got %+v", result.Changes)
+	}
+}
+
+// fails to write the given path
+type failingClient struct {
+	vault.MockClient
+	path string
+}
+
+func (c *failingClient) Write(ctx context.Context, path string, data map[string]interface{}) (*vaultApi.Secret, error) {
+	if path == c.path {
+		return nil, fmt.Errorf("permission denied")
+	}
+	return c.MockClient.Write(ctx, path, data)
+}
+
+func TestRunWithRollback(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json":   &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"auth/approle/role/a.json": &fstest.MapFile{Data: []byte(`{"policies": "reader"}`)},
+		"kv/b.json":                &fstest.MapFile{Data: []byte(`{"b": 1}`)},
+	}
+	client := &failingClient{path: "kv/b"}
+	client.On("Authenticate", "root")
+	rollbackFile := filepath.Join(t.TempDir(), "rollback.json")
+
+	result, err := Run(context.Background(), Options{
+		Config:    config.VaultsmithConfig{VaultRole: "root", Rollback: true, RollbackFile: rollbackFile},
+		Client:    client,
+		Documents: docs,
+	})
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected the run to fail, got %v", err)
+	}
+	exp := []vault.Change{
+		{Action: "PutPolicy", Path: "reader"},
+		{Action: "Write", Path: "auth/approle/role/a"},
+	}
+	if !reflect.DeepEqual(result.Changes, exp) {
+		t.Errorf("Expected changes %+v, got %+v", exp, result.Changes)
+	}
+	exp = []vault.Change{
+		{Action: "Delete", Path: "auth/approle/role/a"},
+		{Action: "DeletePolicy", Path: "reader"},
+	}
+	if !reflect.DeepEqual(result.RolledBack, exp) {
+		t.Errorf("Expected the changes to be undone with %+v, got %+v", exp, result.RolledBack)
+	}
+
+	f, err := vault.ReadRollbackFile(rollbackFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Operations) != 0 {
+		t.Errorf("Expected nothing left to undo, got %+v", f.Operations)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	rollbackFile := filepath.Join(t.TempDir(), "rollback.json")
+	err := vault.WriteRollbackFile(rollbackFile, vault.RollbackFile{
+		DocumentVersion: "abc123",
+		Operations: []vault.Operation{
+			{Action: "Delete", Path: "auth/approle/role/a"},
+			{Action: "PutPolicy", Path: "reader", Policy: "path {}"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := new(vault.MockClient)
+	client.On("Authenticate", "root")
+
+	result, err := Rollback(context.Background(), Options{
+		Config: config.VaultsmithConfig{VaultRole: "root"},
+		Client: client,
+	}, rollbackFile)
+	if err != nil {
+		t.Fatalf("Error calling Rollback: %s", err)
+	}
+	exp := []vault.Change{
+		{Action: "Delete", Path: "auth/approle/role/a"},
+		{Action: "PutPolicy", Path: "reader"},
+	}
+	if !reflect.DeepEqual(result.Changes, exp) || result.DocumentVersion != "abc123" {
+		t.Errorf("Expected changes %+v, got %+v", exp, result)
+	}
+}
+
+func TestRunWithBackup(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json": &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"kv/b.json":              &fstest.MapFile{Data: []byte(`{"b": 1}`)},
+	}
+	for _, dry := range []bool{false, true} {
+		client := new(vault.MockClient)
+		client.On("Authenticate", "root")
+		backupDir := t.TempDir()
+
+		result, err := Run(context.Background(), Options{
+			Config:    config.VaultsmithConfig{VaultRole: "root", Dry: dry, BackupDir: backupDir},
+			Client:    client,
+			Documents: docs,
+		})
+		if err != nil {
+			t.Fatalf("Error calling Run: %s", err)
+		}
+		files, _ := ioutil.ReadDir(backupDir)
+		if dry && (result.BackupFile != "" || len(files) != 0) {
+			t.Errorf("Expected no backup of a dry run, got %q", result.BackupFile)
+		} else if !dry && (filepath.Dir(result.BackupFile) != backupDir || len(files) != 1) {
+			t.Errorf("Expected a backup in %s, got %q", backupDir, result.BackupFile)
+		}
+	}
+}
+
+func TestRunWithConfirm(t *testing.T) {
+	docs := fstest.MapFS{
+		"sys/policy/reader.json": &fstest.MapFile{Data: []byte(`{"policy": "path \"secret/*\" {}"}`)},
+		"kv/b.json":              &fstest.MapFile{Data: []byte(`{"b": 1}`)},
+	}
+	for _, confirmed := range []bool{true, false} {
+		client := new(vault.MockClient)
+		client.On("Authenticate", "root")
+		var plan []vault.Change
+		result, err := Run(context.Background(), Options{
+			Config:    config.VaultsmithConfig{VaultRole: "root"},
+			Client:    client,
+			Documents: docs,
+			Confirm: func(planned []vault.PlannedChange) error {
+				for _, c := range planned {
+					plan = append(plan, c.Change)
+				}
+				if !confirmed {
+					return fmt.Errorf("not confirmed")
+				}
+				return nil
+			},
+		})
+
+		exp := []vault.Change{
+			{Action: "PutPolicy", Path: "reader"},
+			{Action: "Write", Path: "kv/b"},
+		}
+		if !reflect.DeepEqual(plan, exp) {
+			t.Errorf("Expected to confirm %+v, got %+v", exp, plan)
+		}
+		if confirmed && (err != nil || !reflect.DeepEqual(result.Changes, exp)) {
+			t.Errorf("Expected the changes to be made, got %+v, %v", result.Changes, err)
+		} else if !confirmed && (err == nil || len(result.Changes) != 0) {
+			t.Errorf("Expected no changes to be made, got %+v, %v", result.Changes, err)
+		}
+	}
+}